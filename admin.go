@@ -0,0 +1,157 @@
+package gopaste
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// doAdmin serves the admin pages, which are only available when an admin
+// password is configured, and require it with HTTP basic authentication (any
+// user name is accepted).
+//
+//	/admin/notifications   the notification outbox
+//	/admin/deliveries      the log of delivery attempts
+func (s *Server) doAdmin(q *Query) error {
+	if s.Config.AdminPassword == "" {
+		return HttpError{"admin pages are disabled", http.StatusNotFound}
+	}
+
+	_, password, ok := q.Request.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.Config.AdminPassword)) != 1 {
+		q.Response.Header().Set("WWW-Authenticate", `Basic realm="gopaste admin"`)
+		return HttpError{"admin password required", http.StatusUnauthorized}
+	}
+
+	if len(q.Args) == 0 {
+		http.Redirect(q.Response, q.Request, "/admin/notifications", http.StatusSeeOther)
+		return nil
+	}
+
+	switch q.Args[0] {
+	case "notifications":
+		return s.doAdminNotifications(q)
+	case "deliveries":
+		return s.doAdminDeliveries(q)
+	}
+	return HttpError{fmt.Sprintf("'%s' not found", q.Request.URL), http.StatusNotFound}
+}
+
+// adminFormToken returns the token which the admin forms must send back, so
+// that other sites can't use the browser's saved admin password to post them.
+// It is derived from the admin password, which other sites don't know.
+func (s *Server) adminFormToken() string {
+	mac := hmac.New(sha256.New, []byte(s.Config.AdminPassword))
+	mac.Write([]byte("gopaste admin form"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// doAdminNotifications lists the deliveries waiting in the notification outbox,
+// both pending and dead.  POSTing an "id" with "action" retry or discard
+// retries a delivery at once or removes it from the outbox; an id of "dead"
+// applies the action to every dead delivery.  POSTs must include the admin
+// form token.
+func (s *Server) doAdminNotifications(q *Query) error {
+	if q.Request.Method == "POST" {
+		token := q.Request.PostFormValue("token")
+		if !hmac.Equal([]byte(token), []byte(s.adminFormToken())) {
+			return HttpError{"invalid or missing form token; reload the page and try again", http.StatusForbidden}
+		}
+
+		if err := s.updateDeliveries(q); err != nil {
+			return err
+		}
+
+		http.Redirect(q.Response, q.Request, "/admin/notifications", http.StatusSeeOther)
+		return nil
+	}
+
+	deliveries, err := s.Store.GetDeliveries()
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	dead := 0
+	for _, d := range deliveries {
+		if d.Dead() {
+			dead++
+		}
+	}
+
+	return runTemplate(q.Response, "admin-notifications", AnyMap{
+		"Title":      "Notification outbox",
+		"Deliveries": deliveries,
+		"Dead":       dead,
+		"Token":      s.adminFormToken(),
+	})
+}
+
+// deliveryLogPage is how many of the latest delivery attempts are listed.
+const deliveryLogPage = 200
+
+// doAdminDeliveries lists the latest attempts in the delivery log.
+func (s *Server) doAdminDeliveries(q *Query) error {
+	attempts, err := s.Store.GetDeliveryAttempts(deliveryLogPage)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	return runTemplate(q.Response, "admin-deliveries", AnyMap{
+		"Title":    "Delivery log",
+		"Attempts": attempts,
+	})
+}
+
+// updateDeliveries retries or discards the deliveries selected by a POST to
+// the outbox page.
+func (s *Server) updateDeliveries(q *Query) error {
+	action := q.Request.PostFormValue("action")
+	if action != "retry" && action != "discard" {
+		return HttpError{fmt.Sprintf("invalid action '%s'", action), http.StatusBadRequest}
+	}
+
+	var deliveries []*Delivery
+	if idStr := q.Request.PostFormValue("id"); idStr == "dead" {
+		all, err := s.Store.GetDeliveries()
+		if err != nil {
+			return HttpError{err.Error(), http.StatusInternalServerError}
+		}
+		for _, d := range all {
+			if d.Dead() {
+				deliveries = append(deliveries, d)
+			}
+		}
+	} else {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return HttpError{fmt.Sprintf("invalid delivery ID '%s'", idStr), http.StatusBadRequest}
+		}
+
+		d, err := s.Store.GetDelivery(id)
+		if err != nil {
+			return HttpError{err.Error(), http.StatusInternalServerError}
+		}
+		if d == nil {
+			return HttpError{fmt.Sprintf("delivery %d not found", id), http.StatusNotFound}
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	for _, d := range deliveries {
+		var err error
+		if action == "retry" {
+			err = s.retryDelivery(d)
+		} else {
+			err = s.Store.DeleteDelivery(d.Id)
+		}
+		if err != nil {
+			return HttpError{err.Error(), http.StatusInternalServerError}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,455 @@
+package gopaste
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ApiVersion is the current version of the JSON API, which is served under
+// /api/<version>/.
+const ApiVersion = "v1"
+
+// maxApiBody is the largest request body the API will accept.
+const maxApiBody = 16 << 20
+
+// ApiPaste is the JSON representation of a paste.  Ref identifies the paste
+// in URLs: it is the slug of a private paste, or the ID of any other.
+type ApiPaste struct {
+	Id              int64       `json:"id"`
+	Ref             string      `json:"ref"`
+	Title           string      `json:"title,omitempty"`
+	Content         string      `json:"content"`
+	Author          string      `json:"author,omitempty"`
+	Language        string      `json:"language,omitempty"`
+	LanguageGuessed bool        `json:"language_guessed,omitempty"`
+	Filename        string      `json:"filename,omitempty"`
+	Files           []*ApiFile  `json:"files,omitempty"`
+	Channel         string      `json:"channel,omitempty"`
+	Annotates       int64       `json:"annotates,omitempty"`
+	Private         bool        `json:"private"`
+	Burn            bool        `json:"burn,omitempty"`
+	Revision        int         `json:"revision"`
+	Created         time.Time   `json:"created"`
+	Expires         *time.Time  `json:"expires,omitempty"`
+	Url             string      `json:"url"`
+	Annotations     []*ApiPaste `json:"annotations,omitempty"`
+
+	// DeleteToken is only included in the response to creating a paste.
+	DeleteToken string `json:"delete_token,omitempty"`
+}
+
+// ApiFile is the JSON representation of a file of a paste after the first,
+// which is described by the paste itself.  As a request, it is an additional
+// file of a new paste.
+type ApiFile struct {
+	Filename        string `json:"filename"`
+	Language        string `json:"language,omitempty"`
+	LanguageGuessed bool   `json:"language_guessed,omitempty"`
+	Content         string `json:"content"`
+}
+
+// ApiPasteSummary is the JSON representation of a top-level paste in a list.
+type ApiPasteSummary struct {
+	*ApiPaste
+	AnnotationCount int `json:"annotation_count"`
+}
+
+// ApiPasteList is the JSON representation of a page of top-level pastes.
+type ApiPasteList struct {
+	Total    int                `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"page_size"`
+	Pastes   []*ApiPasteSummary `json:"pastes"`
+}
+
+// ApiNewPaste is the JSON request body for creating a paste or annotation.
+type ApiNewPaste struct {
+	Title    string     `json:"title"`
+	Content  string     `json:"content"`
+	Author   string     `json:"author"`
+	Language string     `json:"language"`
+	Filename string     `json:"filename"`
+	Files    []*ApiFile `json:"files"`
+	Channel  string     `json:"channel"`
+	Private  bool       `json:"private"`
+	Burn     bool       `json:"burn"`
+	Expires  string     `json:"expires"`
+}
+
+// Values converts the request into the form values understood by NewPaste.
+func (n ApiNewPaste) Values() url.Values {
+	v := url.Values{
+		"Title":    {n.Title},
+		"Content":  {n.Content},
+		"Author":   {n.Author},
+		"Language": {n.Language},
+		"Filename": {n.Filename},
+		"Channel":  {n.Channel},
+		"Expires":  {n.Expires},
+	}
+	for _, f := range n.Files {
+		v.Add("FileName", f.Filename)
+		v.Add("FileLanguage", f.Language)
+		v.Add("FileContent", f.Content)
+	}
+	if n.Private {
+		v.Set("Private", "on")
+	}
+	if n.Burn {
+		v.Set("Burn", "on")
+	}
+	return v
+}
+
+// ApiError is the JSON error body returned by the API.
+type ApiError struct {
+	Error ApiErrorDetail `json:"error"`
+}
+
+type ApiErrorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiPaste converts a paste into its JSON representation.
+func (s *Server) apiPaste(p *Paste) *ApiPaste {
+	a := &ApiPaste{
+		Id:              p.Id,
+		Ref:             p.Ref(),
+		Title:           p.Title.String,
+		Content:         p.Content,
+		Author:          p.Author.String,
+		Language:        p.Language.String,
+		LanguageGuessed: p.LanguageGuessed,
+		Channel:         p.Channel.String,
+		Annotates:       p.Annotates.Int64,
+		Private:         p.Private,
+		Burn:            p.Burn,
+		Revision:        p.Revision,
+		Filename:        p.Filename.String,
+		Created:         p.CreatedTime().UTC(),
+	}
+
+	for _, f := range p.ExtraFiles {
+		a.Files = append(a.Files, &ApiFile{
+			Filename:        f.Filename,
+			Language:        f.Language.String,
+			LanguageGuessed: f.LanguageGuessed,
+			Content:         f.Content,
+		})
+	}
+
+	if p.Expires.Valid {
+		expires := p.ExpiresTime().UTC()
+		a.Expires = &expires
+	}
+
+	a.Url = s.externalUrl(viewPath(p))
+
+	return a
+}
+
+// apiPasteData converts a paste and its annotations into their JSON
+// representation.
+func (s *Server) apiPasteData(d *PasteData) *ApiPaste {
+	a := s.apiPaste(d.Paste)
+	a.Annotations = []*ApiPaste{}
+	for _, ann := range d.Annotations {
+		a.Annotations = append(a.Annotations, s.apiPaste(ann))
+	}
+	return a
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// writeJson writes a value to the response as JSON with the given status code.
+func writeJson(w http.ResponseWriter, code int, v interface{}) error {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(body)
+	w.Write([]byte("\n"))
+	return nil
+}
+
+// writeJsonError writes an error to the response as a JSON error body.
+func writeJsonError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	message := err.Error()
+	if e, ok := err.(HttpError); ok {
+		code = e.Code
+		message = e.Message
+	}
+
+	writeJson(w, code, ApiError{ApiErrorDetail{Code: code, Message: message}})
+}
+
+// doApi dispatches a request to the JSON API.  Errors are reported to the
+// client as JSON bodies rather than as plain text.
+func (s *Server) doApi(q *Query) error {
+	if err := s.handleApi(q); err != nil {
+		log.Printf("[api] %v", err)
+		writeJsonError(q.Response, err)
+	}
+	return nil
+}
+
+func (s *Server) handleApi(q *Query) error {
+	args := q.Args
+	if len(args) < 2 || args[1] != "pastes" {
+		return HttpError{fmt.Sprintf("'%s' not found", q.Request.URL), http.StatusNotFound}
+	}
+	if args[0] != ApiVersion {
+		return HttpError{fmt.Sprintf("unsupported API version '%s'", args[0]), http.StatusNotFound}
+	}
+
+	args = args[2:]
+	method := q.Request.Method
+	switch {
+	case len(args) == 0:
+		switch method {
+		case "GET", "HEAD":
+			return s.apiListPastes(q)
+		case "POST":
+			return s.apiCreatePaste(q, nil)
+		}
+
+	case len(args) == 1:
+		switch method {
+		case "GET", "HEAD":
+			return s.apiGetPaste(q, args[0])
+		case "DELETE":
+			return s.apiDeletePaste(q, args[0])
+		}
+
+	case len(args) == 2 && args[1] == "annotations":
+		switch method {
+		case "GET", "HEAD":
+			return s.apiGetAnnotations(q, args[0])
+		case "POST":
+			parent, err := s.apiFetchPaste(args[0])
+			if err != nil {
+				return err
+			}
+			return s.apiCreatePaste(q, parent)
+		}
+
+	default:
+		return HttpError{fmt.Sprintf("'%s' not found", q.Request.URL), http.StatusNotFound}
+	}
+
+	q.Response.Header().Set("Allow", allowedMethods(args))
+	return HttpError{fmt.Sprintf("unsupported request method: %s", method), http.StatusMethodNotAllowed}
+}
+
+// allowedMethods returns the value of the Allow header for an API resource.
+func allowedMethods(args []string) string {
+	if len(args) == 1 {
+		return "GET, HEAD, DELETE"
+	}
+	return "GET, HEAD, POST"
+}
+
+// apiFetchPaste looks up a paste from an ID string in an API path.
+func (s *Server) apiFetchPaste(idStr string) (*Paste, error) {
+	id, err := s.parsePasteId(idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err != nil {
+		return nil, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if paste == nil {
+		return nil, HttpError{fmt.Sprintf("paste %s not found", idStr), http.StatusNotFound}
+	}
+
+	return paste, nil
+}
+
+// apiBrowseOpts builds a BrowseOpts from the query string of an API request.
+// The "page" and "page_size" parameters control pagination; any others are
+// used as search filters, as with the /browse path syntax.
+func apiBrowseOpts(v url.Values) (*BrowseOpts, error) {
+	opts := NewBrowseOpts()
+	for key := range v {
+		val := v.Get(key)
+		switch key {
+		case "page", "page_size":
+			num, err := strconv.Atoi(val)
+			if err != nil || num < 1 {
+				return nil, fmt.Errorf("invalid %s: %s", key, val)
+			}
+			if key == "page" {
+				opts.Page = num
+			} else {
+				opts.PageSize = num
+			}
+		default:
+			opts.Search[key] = val
+		}
+	}
+
+	if opts.PageSize > 100 {
+		opts.PageSize = 100
+	}
+
+	return opts, nil
+}
+
+// apiListPastes returns a page of public top-level pastes.
+func (s *Server) apiListPastes(q *Query) error {
+	opts, err := apiBrowseOpts(q.Request.URL.Query())
+	if err != nil {
+		return HttpError{err.Error(), http.StatusBadRequest}
+	}
+
+	page, err := s.Store.TopLevelPastes(opts)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	list := &ApiPasteList{
+		Total:    page.Total,
+		Page:     opts.Page,
+		PageSize: opts.PageSize,
+		Pastes:   []*ApiPasteSummary{},
+	}
+	for _, data := range page.Pastes {
+		list.Pastes = append(list.Pastes, &ApiPasteSummary{
+			ApiPaste:        s.apiPaste(data.Paste),
+			AnnotationCount: len(data.Annotations),
+		})
+	}
+
+	return writeJson(q.Response, http.StatusOK, list)
+}
+
+// apiGetPaste returns a single paste along with its annotations.
+func (s *Server) apiGetPaste(q *Query, idStr string) error {
+	paste, err := s.apiFetchPaste(idStr)
+	if err != nil {
+		return err
+	}
+
+	if paste.Annotates.Valid {
+		return writeJson(q.Response, http.StatusOK, s.apiPaste(paste))
+	}
+
+	data, err := GetPasteData(s.Store, paste.Id)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if data == nil {
+		return HttpError{fmt.Sprintf("paste %s not found", idStr), http.StatusNotFound}
+	}
+
+	return writeJson(q.Response, http.StatusOK, s.apiPasteData(data))
+}
+
+// apiDeletePaste deletes a paste, along with its annotations if it is a
+// top-level paste.  The delete token returned when the paste was created must
+// be given in the X-Delete-Token header or the "token" query parameter.
+func (s *Server) apiDeletePaste(q *Query, idStr string) error {
+	paste, err := s.deletablePaste(idStr)
+	if err != nil {
+		return err
+	}
+
+	token := q.Request.Header.Get("X-Delete-Token")
+	if token == "" {
+		token = q.Request.URL.Query().Get("token")
+	}
+	if !paste.DeletableWith(token) {
+		return HttpError{fmt.Sprintf("invalid delete token for paste %s", paste.Ref()), http.StatusForbidden}
+	}
+
+	if err := s.deletePaste(paste); err != nil {
+		return err
+	}
+
+	q.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// apiGetAnnotations returns the annotations of a paste.
+func (s *Server) apiGetAnnotations(q *Query, idStr string) error {
+	paste, err := s.apiFetchPaste(idStr)
+	if err != nil {
+		return err
+	}
+
+	annotations, err := s.Store.GetAnnotations(paste.RootId())
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	list := []*ApiPaste{}
+	for _, ann := range annotations {
+		list = append(list, s.apiPaste(ann))
+	}
+
+	return writeJson(q.Response, http.StatusOK, list)
+}
+
+// apiCreatePaste creates a new paste, or an annotation of parent if it is not
+// nil.  The request body may be either a JSON object or a URL-encoded form
+// using the same field names as the web form.
+func (s *Server) apiCreatePaste(q *Query, parent *Paste) error {
+	req := q.Request
+	req.Body = http.MaxBytesReader(q.Response, req.Body, maxApiBody)
+
+	var values url.Values
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return HttpError{fmt.Sprintf("error reading request: %s", err.Error()), http.StatusBadRequest}
+		}
+
+		var n ApiNewPaste
+		if err := json.Unmarshal(body, &n); err != nil {
+			return HttpError{fmt.Sprintf("invalid JSON: %s", err.Error()), http.StatusBadRequest}
+		}
+		values = n.Values()
+
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := req.ParseMultipartForm(maxApiBody); err != nil && err != http.ErrNotMultipart {
+			return HttpError{fmt.Sprintf("error parsing form: %s", err.Error()), http.StatusBadRequest}
+		}
+		values = req.PostForm
+
+	default:
+		return HttpError{fmt.Sprintf("unsupported content type '%s'", mediaType), http.StatusUnsupportedMediaType}
+	}
+
+	if values.Get("Content") == "" {
+		return HttpError{"paste content is required", http.StatusBadRequest}
+	}
+
+	paste := NewPaste(values)
+	if err := setOwner(q, paste); err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if _, err := s.createPaste(paste, parent); err != nil {
+		return err
+	}
+
+	result := s.apiPaste(paste)
+	result.DeleteToken = paste.DeleteToken
+	q.Response.Header().Set("Location", fmt.Sprintf("/api/%s/pastes/%s", ApiVersion, paste.Ref()))
+	return writeJson(q.Response, http.StatusCreated, result)
+}
@@ -0,0 +1,279 @@
+package gopaste
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// apiRequest sends a request with the given content type and body to a
+// server's API, and returns the response.
+func apiRequest(s *Server, method, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// decodeJson decodes a JSON response body, failing the test if it is invalid.
+func decodeJson(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Fatalf("got content type %q, want JSON", got)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON response: %v: %s", err, w.Body)
+	}
+}
+
+func TestApiCreatePaste(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		contentType string
+		body        string
+		want        ApiPaste
+	}{
+		{
+			"application/json",
+			`{"title": "build log", "content": "make: *** [all] Error 1", "author": "alice", "channel": "ops"}`,
+			ApiPaste{Id: 1, Ref: "1", Title: "build log", Content: "make: *** [all] Error 1", Author: "alice", Channel: "#ops"},
+		},
+		{
+			"application/x-www-form-urlencoded",
+			url.Values{"Title": {"form"}, "Content": {"x = 1"}, "Language": {"python"}}.Encode(),
+			ApiPaste{Id: 2, Ref: "2", Title: "form", Content: "x = 1", Language: "python"},
+		},
+		{
+			"application/json; charset=utf-8",
+			`{"content": "secret", "private": true}`,
+			ApiPaste{Content: "secret", Private: true},
+		},
+	}
+
+	for _, test := range tests {
+		w := apiRequest(s, "POST", "/api/v1/pastes", test.contentType, test.body)
+		if w.Code != http.StatusCreated {
+			t.Errorf("POST %s: got status %d: %s", test.body, w.Code, w.Body)
+			continue
+		}
+
+		// private pastes have random IDs and slugs
+		var got ApiPaste
+		decodeJson(t, w, &got)
+		if test.want.Private {
+			if !isSlug(got.Ref) {
+				t.Errorf("POST %s: got ref %q, want a slug", test.body, got.Ref)
+			}
+			test.want.Id, test.want.Ref = got.Id, got.Ref
+		}
+		if got.Id != test.want.Id || got.Ref != test.want.Ref || got.Title != test.want.Title || got.Content != test.want.Content ||
+			got.Author != test.want.Author || got.Language != test.want.Language ||
+			got.Channel != test.want.Channel || got.Private != test.want.Private {
+			t.Errorf("POST %s: got %+v, want %+v", test.body, got, test.want)
+		}
+		if want := "/api/v1/pastes/" + test.want.Ref; w.Header().Get("Location") != want {
+			t.Errorf("POST %s: got Location %q, want %q", test.body, w.Header().Get("Location"), want)
+		}
+	}
+}
+
+func TestApiOwner(t *testing.T) {
+	s := newTestServer(t)
+	w := apiRequest(s, "POST", "/api/v1/pastes", "application/json", `{"content": "x = 1"}`)
+	var owner *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == OwnerCookie {
+			owner = cookie
+		}
+	}
+	if owner == nil {
+		t.Fatalf("POST /api/v1/pastes: no owner cookie")
+	}
+
+	// a request with the cookie makes a paste with the same owner
+	req := httptest.NewRequest("POST", "/api/v1/pastes", strings.NewReader(`{"content": "y = 2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(owner)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("POST with an owner cookie: got cookies %v", w.Result().Cookies())
+	}
+
+	for _, path := range []string{"/edit/1", "/edit/2"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.AddCookie(owner)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s with the owner cookie: got status %d", path, w.Code)
+		}
+		if w := request(s, "GET", path, nil); w.Code != http.StatusForbidden {
+			t.Errorf("GET %s without the owner cookie: got status %d, want 403", path, w.Code)
+		}
+	}
+}
+
+func TestApiAnnotations(t *testing.T) {
+	s := newTestServer(t)
+	apiRequest(s, "POST", "/api/v1/pastes", "application/json", `{"content": "x = 1"}`)
+
+	w := apiRequest(s, "POST", "/api/v1/pastes/1/annotations", "application/json", `{"title": "fixed", "content": "x = 2"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST annotation: got status %d: %s", w.Code, w.Body)
+	}
+	var ann ApiPaste
+	decodeJson(t, w, &ann)
+	if ann.Annotates != 1 {
+		t.Errorf("annotation annotates %d, want 1", ann.Annotates)
+	}
+
+	w = apiRequest(s, "GET", "/api/v1/pastes/1", "", "")
+	var paste ApiPaste
+	decodeJson(t, w, &paste)
+	if len(paste.Annotations) != 1 || paste.Annotations[0].Content != "x = 2" {
+		t.Errorf("GET /api/v1/pastes/1: got annotations %+v", paste.Annotations)
+	}
+
+	w = apiRequest(s, "GET", "/api/v1/pastes/1/annotations", "", "")
+	var annotations []*ApiPaste
+	decodeJson(t, w, &annotations)
+	if len(annotations) != 1 || annotations[0].Title != "fixed" {
+		t.Errorf("GET /api/v1/pastes/1/annotations: got %+v", annotations)
+	}
+}
+
+func TestApiListPastes(t *testing.T) {
+	s := newTestServer(t)
+	for _, body := range []string{
+		`{"content": "a", "author": "alice"}`,
+		`{"content": "b", "author": "bob"}`,
+		`{"content": "c", "author": "alice", "private": true}`,
+		`{"content": "d", "author": "alice"}`,
+	} {
+		apiRequest(s, "POST", "/api/v1/pastes", "application/json", body)
+	}
+	apiRequest(s, "POST", "/api/v1/pastes/1/annotations", "application/json", `{"content": "a2"}`)
+
+	tests := []struct {
+		query string
+		total int
+		ids   []int64
+	}{
+		{"", 3, []int64{3, 2, 1}},
+		{"?author=alice", 2, []int64{3, 1}},
+		{"?page_size=2", 3, []int64{3, 2}},
+		{"?page_size=2&page=2", 3, []int64{1}},
+	}
+
+	for _, test := range tests {
+		w := apiRequest(s, "GET", "/api/v1/pastes"+test.query, "", "")
+		var list ApiPasteList
+		decodeJson(t, w, &list)
+
+		var ids []int64
+		for _, p := range list.Pastes {
+			ids = append(ids, p.Id)
+		}
+		if list.Total != test.total || fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("GET /api/v1/pastes%s: got %d pastes %v, want %d %v", test.query, list.Total, ids, test.total, test.ids)
+		}
+	}
+}
+
+func TestApiErrors(t *testing.T) {
+	s := newTestServer(t)
+	apiRequest(s, "POST", "/api/v1/pastes", "application/json", `{"content": "x"}`)
+
+	tests := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{"GET", "/api/v2/pastes", "", "", http.StatusNotFound},
+		{"GET", "/api/v1/users", "", "", http.StatusNotFound},
+		{"GET", "/api/v1/pastes/abc", "", "", http.StatusBadRequest},
+		{"GET", "/api/v1/pastes/99", "", "", http.StatusNotFound},
+		{"GET", "/api/v1/pastes?page=0", "", "", http.StatusBadRequest},
+		{"POST", "/api/v1/pastes", "text/plain", "x", http.StatusUnsupportedMediaType},
+		{"POST", "/api/v1/pastes", "application/json", "{", http.StatusBadRequest},
+		{"POST", "/api/v1/pastes", "application/json", `{"title": "empty"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/pastes/99/annotations", "application/json", `{"content": "x"}`, http.StatusNotFound},
+		{"PUT", "/api/v1/pastes/1", "application/json", `{"content": "y"}`, http.StatusMethodNotAllowed},
+		{"DELETE", "/api/v1/pastes/1", "", "", http.StatusForbidden},
+		{"DELETE", "/api/v1/pastes/1?token=wrong", "", "", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		w := apiRequest(s, test.method, test.path, test.contentType, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.path, w.Code, test.code)
+			continue
+		}
+
+		var e ApiError
+		decodeJson(t, w, &e)
+		if e.Error.Code != test.code || e.Error.Message == "" {
+			t.Errorf("%s %s: got error %+v", test.method, test.path, e.Error)
+		}
+	}
+}
+
+func TestApiDeletePaste(t *testing.T) {
+	s := newTestServer(t)
+	create := func(path string) ApiPaste {
+		t.Helper()
+		w := apiRequest(s, "POST", path, "application/json", `{"content": "x"}`)
+		var p ApiPaste
+		decodeJson(t, w, &p)
+		if p.DeleteToken == "" {
+			t.Fatalf("POST %s: no delete token in %s", path, w.Body)
+		}
+		return p
+	}
+
+	root := create("/api/v1/pastes")
+	first := create("/api/v1/pastes/1/annotations")
+	second := create("/api/v1/pastes/1/annotations")
+
+	// deleting an annotation leaves the rest of the thread
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/api/v1/pastes/%d", first.Id), nil)
+	req.Header.Set("X-Delete-Token", first.DeleteToken)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE annotation: got status %d: %s", w.Code, w.Body)
+	}
+	var annotations []ApiPaste
+	decodeJson(t, apiRequest(s, "GET", "/api/v1/pastes/1/annotations", "", ""), &annotations)
+	if len(annotations) != 1 || annotations[0].Id != second.Id {
+		t.Errorf("annotations after deleting one: got %+v", annotations)
+	}
+
+	// a token only works for its own paste
+	path := fmt.Sprintf("/api/v1/pastes/%d?token=%s", root.Id, url.QueryEscape(second.DeleteToken))
+	if w := apiRequest(s, "DELETE", path, "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("DELETE with another paste's token: got status %d", w.Code)
+	}
+
+	// deleting a top-level paste takes its annotations with it
+	path = fmt.Sprintf("/api/v1/pastes/%d?token=%s", root.Id, url.QueryEscape(root.DeleteToken))
+	if w := apiRequest(s, "DELETE", path, "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE paste: got status %d: %s", w.Code, w.Body)
+	}
+	for _, id := range []int64{root.Id, second.Id} {
+		if w := apiRequest(s, "GET", fmt.Sprintf("/api/v1/pastes/%d", id), "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET paste %d after deleting the thread: got status %d", id, w.Code)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package gopaste
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Comment represents a comment on a single line of a file of a paste or
+// annotation.  Comments may be replies to other comments on the same line,
+// forming a thread.
+type Comment struct {
+	Id      int64          `sql:"id"`
+	PasteId int64          `sql:"paste_id"`
+	File    int            `sql:"file"`
+	Line    int            `sql:"line"`
+	ReplyTo sql.NullInt64  `sql:"reply_to"`
+	Author  sql.NullString `sql:"author"`
+	Content string         `sql:"content"`
+	Created int64          `sql:"created"`
+
+	// Location is the line the comment is attached to, filled in when the
+	// comment is displayed.
+	Location LineNumber `sql:"-"`
+	Replies  []*Comment `sql:"-"`
+}
+
+// NewComment creates a new comment on a line of a paste from a submitted web
+// form.
+func NewComment(paste *Paste, num LineNumber, v url.Values) *Comment {
+	comment := &Comment{
+		PasteId: paste.Id,
+		File:    num.File,
+		Line:    num.Num,
+		Content: strings.TrimSpace(v.Get("Content")),
+		Created: time.Now().Unix(),
+	}
+
+	if s := strings.TrimSpace(v.Get("Author")); s != "" {
+		comment.Author.Valid = true
+		comment.Author.String = s
+	}
+
+	if s := v.Get("ReplyTo"); s != "" {
+		if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+			comment.ReplyTo.Valid = true
+			comment.ReplyTo.Int64 = id
+		}
+	}
+
+	return comment
+}
+
+// AuthorDef returns the comment author if set, or "anonymous" otherwise.
+func (c Comment) AuthorDef() string {
+	if c.Author.Valid {
+		return c.Author.String
+	}
+	return "anonymous"
+}
+
+// CreatedTime returns the comment's creation time as a time.Time object.
+func (c Comment) CreatedTime() time.Time {
+	return time.Unix(c.Created, 0)
+}
+
+// CreatedDisplay returns the comment creation date in a human-readable format.
+func (c Comment) CreatedDisplay() string {
+	return c.CreatedTime().Format(TimeFormat)
+}
+
+// CreatedRel returns a string describing how long ago the comment was
+// created.
+func (c Comment) CreatedRel() string {
+	return relativeTime(c.CreatedTime())
+}
+
+// setLocation records the line a comment and all its replies are attached to.
+func (c *Comment) setLocation(num LineNumber) {
+	c.Location = num
+	for _, reply := range c.Replies {
+		reply.setLocation(num)
+	}
+}
+
+// annotationPrefix matches the annotation number at the start of a line
+// anchor.
+var annotationPrefix = regexp.MustCompile(`^[0-9]+\.`)
+
+// ParseAnchor splits a line anchor such as "14", "2.14" or "2.main.go:14" into
+// the annotation number (0 for the top-level paste), the file name (empty for
+// an unnamed file) and the line number.
+func ParseAnchor(anchor string) (annotation int, file string, line int, err error) {
+	lineStr := anchor
+	if prefix := annotationPrefix.FindString(anchor); prefix != "" {
+		annotation, err = strconv.Atoi(strings.TrimSuffix(prefix, "."))
+		if err != nil || annotation < 1 {
+			return 0, "", 0, fmt.Errorf("invalid line anchor '%s'", anchor)
+		}
+		lineStr = anchor[len(prefix):]
+	}
+
+	if colon := strings.LastIndex(lineStr, ":"); colon != -1 {
+		file, lineStr = lineStr[:colon], lineStr[colon+1:]
+	}
+
+	line, err = strconv.Atoi(lineStr)
+	if err != nil || line < 1 {
+		return 0, "", 0, fmt.Errorf("invalid line anchor '%s'", anchor)
+	}
+
+	return annotation, file, line, nil
+}
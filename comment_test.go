@@ -0,0 +1,90 @@
+package gopaste
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseAnchor(t *testing.T) {
+	tests := []struct {
+		anchor     string
+		annotation int
+		file       string
+		line       int
+		ok         bool
+	}{
+		{"14", 0, "", 14, true},
+		{"2.14", 2, "", 14, true},
+		{"main.go:3", 0, "main.go", 3, true},
+		{"2.main.go:3", 2, "main.go", 3, true},
+		{"a:b.c:3", 0, "a:b.c", 3, true},
+		{"0", 0, "", 0, false},
+		{"0.3", 0, "", 0, false},
+		{"2.", 0, "", 0, false},
+		{"a.1", 0, "", 0, false},
+		{"main.go:", 0, "", 0, false},
+		{"main.go:0", 0, "", 0, false},
+		{"", 0, "", 0, false},
+	}
+
+	for _, test := range tests {
+		annotation, file, line, err := ParseAnchor(test.anchor)
+		if (err == nil) != test.ok || annotation != test.annotation || file != test.file || line != test.line {
+			t.Errorf("ParseAnchor(%q) = %d, %q, %d, %v; want %d, %q, %d",
+				test.anchor, annotation, file, line, err, test.annotation, test.file, test.line)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{"Content": {"one\ntwo\nthree"}})
+	postPaste(t, s, "1", url.Values{"Content": {"uno\ndos"}})
+
+	w := request(s, "POST", "/comment/1/2", url.Values{"Author": {"alice"}, "Content": {"should be 2"}})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/view/1#c1" {
+		t.Fatalf("POST /comment/1/2: got status %d, redirect to %q", w.Code, w.Header().Get("Location"))
+	}
+	request(s, "POST", "/comment/1/2", url.Values{"Content": {"it is"}, "ReplyTo": {"1"}})
+	request(s, "POST", "/comment/1/1.2", url.Values{"Content": {"on the annotation"}})
+
+	tests := []struct {
+		path string
+		form url.Values
+		code int
+	}{
+		{"/comment/1/x", url.Values{"Content": {"x"}}, http.StatusBadRequest},
+		{"/comment/1/4", url.Values{"Content": {"x"}}, http.StatusNotFound},
+		{"/comment/1/2.1", url.Values{"Content": {"x"}}, http.StatusNotFound},
+		{"/comment/2/1", url.Values{"Content": {"x"}}, http.StatusNotFound},
+		{"/comment/9/1", url.Values{"Content": {"x"}}, http.StatusNotFound},
+		{"/comment/1/1", url.Values{"Content": {"  "}}, http.StatusBadRequest},
+		{"/comment/1/1", url.Values{"Content": {"x"}, "ReplyTo": {"1"}}, http.StatusBadRequest},
+		{"/comment/1/1.2", url.Values{"Content": {"x"}, "ReplyTo": {"1"}}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if w := request(s, "POST", test.path, test.form); w.Code != test.code {
+			t.Errorf("POST %s %v: got status %d, want %d", test.path, test.form, w.Code, test.code)
+		}
+	}
+
+	comments, err := s.Store.GetComments(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments[1]) != 1 || len(comments[1][0].Replies) != 1 || comments[1][0].Replies[0].Content != "it is" {
+		t.Errorf("got comments %+v on the paste, want one with a reply", comments[1])
+	}
+	if len(comments[2]) != 1 || comments[2][0].Line != 2 {
+		t.Errorf("got comments %+v on the annotation, want one on line 2", comments[2])
+	}
+
+	w = request(s, "GET", "/view/1", nil)
+	for _, want := range []string{"should be 2", "it is", "on the annotation", "alice"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("GET /view/1: page does not contain %q", want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
 const (
@@ -18,18 +19,69 @@ type Config struct {
 	Port         uint
 	ExternalHost string
 	HubotHost    string
+	Highlight    string
+
+	// Notify holds the notifiers for new pastes and comments, each of the form
+	// PREFIX=KIND:TARGET; see NewNotifications.
+	Notify         []string
+	NotifyTemplate string
+
+	// Webhooks holds the webhooks for paste events, each of the form URL or
+	// EVENTS=URL; see NewWebhooks.  Their requests are signed with
+	// WebhookSecret, if it is set.
+	Webhooks      []string
+	WebhookSecret string
+
+	// AdminPassword enables the admin pages; see doAdmin.
+	AdminPassword string
+}
+
+// stringList is a flag which may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 // ParseConfig creates a new Config object by reading the command-line arguments.
 func ParseConfig() *Config {
 	config := &Config{}
-	flag.StringVar(&config.DbDriver, "db-driver", DefaultDriver, "Database driver")
+	flag.StringVar(&config.DbDriver, "db-driver", DefaultDriver, "Database driver: sqlite3, postgres, mysql, or memory for a non-persistent in-memory store")
 	flag.StringVar(&config.DbSource, "db-source", DefaultDatabase, "Database source")
 	flag.UintVar(&config.Port, "port", DefaultPort, "HTTP server port")
 	flag.StringVar(&config.ExternalHost, "external-host", "", "Gopaste hostname for external links")
-	flag.StringVar(&config.HubotHost, "hubot-host", "", "Hubot location")
+	flag.StringVar(&config.HubotHost, "hubot-host", "", "Hubot location; the same as --notify =hubot:HOST")
+	flag.Var((*stringList)(&config.Notify), "notify", "Send notifications for channels starting with PREFIX to a notifier, given as PREFIX=KIND:TARGET where KIND is "+strings.Join(NotifierKinds(), ", ")+" (may be repeated)")
+	flag.StringVar(&config.NotifyTemplate, "notify-template", DefaultNotifyTemplate, "text/template for notification messages")
+	flag.StringVar(&config.Highlight, "highlight", HighlightServer, "Where to do syntax highlighting: server, falling back to highlight.js for languages the server doesn't know, or client to use highlight.js for everything")
+	flag.Var((*stringList)(&config.Webhooks), "webhook", "POST paste events to a URL, given as URL or EVENTS=URL where EVENTS is a comma-separated list of "+strings.Join(hookEvents, ", ")+" (may be repeated)")
+	flag.StringVar(&config.WebhookSecret, "webhook-secret", "", "Secret for signing webhook requests with HMAC-SHA256")
+	flag.StringVar(&config.AdminPassword, "admin-password", "", "Password for the admin pages under /admin, which are disabled if it is not set")
 	flag.Parse()
 
+	if config.Highlight != HighlightServer && config.Highlight != HighlightClient {
+		fmt.Fprintf(os.Stderr, "invalid value \"%s\" for flag -highlight\n", config.Highlight)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := NewNotifications(config); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := NewWebhooks(config); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	if config.ExternalHost == "" {
 		localhost, err := os.Hostname()
 		if err != nil {
@@ -1,27 +1,10 @@
 package gopaste
 
 import (
-	"database/sql"
-	"fmt"
-	_ "github.com/mattn/go-sqlite3"
 	"sort"
 	"strings"
 )
 
-const createTableSql = `
-	CREATE TABLE IF NOT EXISTS pastes (
-		id         INTEGER NOT NULL PRIMARY KEY,
-		title      TEXT,
-		content    TEXT NOT NULL,
-		author     TEXT,
-		language   TEXT,
-		channel    TEXT,
-		annotates  INTEGER,
-		private    INTEGER NOT NULL,
-		created    INTEGER NOT NULL
-	);
-`
-
 // LanguageNames maps language identifers to the human-readable names of the
 // languages supported by highlightjs.
 var LanguageNames = map[string]string{
@@ -159,19 +142,3 @@ func init() {
 	}
 	sort.Sort(byName(LanguageNamesSorted))
 }
-
-// initDb establishes Gopaste's database connection and creates the pastes table
-// if necessary.
-func (s *Server) initDb() error {
-	dbh, err := sql.Open(s.Config.DbDriver, s.Config.DbSource)
-	if err != nil {
-		return fmt.Errorf("Error opening %s %s: %v\n", s.Config.DbDriver, s.Config.DbSource, err)
-	}
-
-	if _, err := dbh.Exec(createTableSql); err != nil {
-		return err
-	}
-
-	s.Database = dbh
-	return nil
-}
@@ -0,0 +1,89 @@
+package gopaste
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DeleteCookie is the name of the short-lived cookie which carries a new
+// paste's delete token across the redirect to its view page, so that the
+// token can be shown there once.
+const DeleteCookie = "gopaste_delete"
+
+// deleteCookieAge is how long the delete token cookie lasts, in seconds.
+const deleteCookieAge = 5 * Minute
+
+// setDeleteToken gives a new paste a random delete token.  Only the token's
+// hash is stored with the paste.
+func setDeleteToken(paste *Paste) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	paste.DeleteToken = token
+	paste.DeleteHash.Valid = true
+	paste.DeleteHash.String = hashToken(token)
+	return nil
+}
+
+// DeletableWith reports whether a token allows the paste to be deleted.
+func (p Paste) DeletableWith(token string) bool {
+	return p.DeleteHash.Valid && tokenMatches(token, p.DeleteHash.String)
+}
+
+// deleteNotice tells the creator of a paste how to delete it.
+type deleteNotice struct {
+	Paste *Paste
+	Url   string
+}
+
+// setDeleteFlash stores a new paste's delete token in a cookie, to be shown
+// by the next page viewed.
+func setDeleteFlash(q *Query, paste *Paste) {
+	http.SetCookie(q.Response, &http.Cookie{
+		Name:     DeleteCookie,
+		Value:    fmt.Sprintf("%d:%s", paste.Id, paste.DeleteToken),
+		Path:     "/",
+		MaxAge:   deleteCookieAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// takeDeleteNotice returns a notice explaining how to delete a newly created
+// paste in a thread, if the client has just created one.  The delete token
+// cookie is cleared once it has been shown.
+func (s *Server) takeDeleteNotice(q *Query, data *PasteData) *deleteNotice {
+	cookie, err := q.Request.Cookie(DeleteCookie)
+	if err != nil {
+		return nil
+	}
+
+	parts := strings.SplitN(cookie.Value, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	for _, paste := range append([]*Paste{data.Paste}, data.Annotations...) {
+		if paste.Id == id && paste.DeletableWith(parts[1]) {
+			http.SetCookie(q.Response, &http.Cookie{Name: DeleteCookie, Path: "/", MaxAge: -1})
+			return &deleteNotice{paste, s.deleteUrl(paste, parts[1])}
+		}
+	}
+
+	return nil
+}
+
+// deleteUrl returns the URL at which a paste can be deleted with a token.
+func (s *Server) deleteUrl(paste *Paste, token string) string {
+	return s.externalUrl(fmt.Sprintf("/delete/%s?token=%s", paste.Ref(), url.QueryEscape(token)))
+}
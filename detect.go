@@ -0,0 +1,366 @@
+package gopaste
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// NoLanguage is the Language form value for pastes which are plain text, as
+// opposed to an empty value, which asks for the language to be detected.
+const NoLanguage = "none"
+
+// languageAliases maps the names used for languages by interpreters, editor
+// modes and file extensions to codes in LanguageNames, where the two differ.
+var languageAliases = map[string]string{
+	"asm":          "x86asm",
+	"bat":          "dos",
+	"batch":        "dos",
+	"c#":           "cs",
+	"c++":          "cpp",
+	"cc":           "cpp",
+	"cfg":          "ini",
+	"clj":          "clojure",
+	"cmd":          "dos",
+	"coffee":       "coffeescript",
+	"common-lisp":  "lisp",
+	"cperl":        "perl",
+	"csharp":       "cs",
+	"cxx":          "cpp",
+	"dash":         "bash",
+	"el":           "lisp",
+	"elisp":        "lisp",
+	"emacs-lisp":   "lisp",
+	"erl":          "erlang",
+	"ex":           "elixir",
+	"exs":          "elixir",
+	"fs":           "fsharp",
+	"gmake":        "makefile",
+	"h":            "c",
+	"hh":           "cpp",
+	"hpp":          "cpp",
+	"hs":           "haskell",
+	"htm":          "html",
+	"js":           "javascript",
+	"jsx":          "javascript",
+	"ksh":          "bash",
+	"latex":        "tex",
+	"make":         "makefile",
+	"md":           "markdown",
+	"mjs":          "javascript",
+	"mk":           "makefile",
+	"ml":           "ocaml",
+	"nasm":         "x86asm",
+	"node":         "javascript",
+	"nodejs":       "javascript",
+	"objc":         "objectivec",
+	"objective-c":  "objectivec",
+	"patch":        "diff",
+	"pl":           "perl",
+	"plaintex":     "tex",
+	"pm":           "perl",
+	"proto":        "protobuf",
+	"ps1":          "powershell",
+	"pwsh":         "powershell",
+	"py":           "python",
+	"rake":         "ruby",
+	"rb":           "ruby",
+	"rs":           "rust",
+	"rscript":      "r",
+	"scm":          "scheme",
+	"sh":           "bash",
+	"shell-script": "bash",
+	"st":           "smalltalk",
+	"tclsh":        "tcl",
+	"ts":           "typescript",
+	"tsx":          "typescript",
+	"v":            "verilog",
+	"vhd":          "vhdl",
+	"viml":         "vim",
+	"wish":         "tcl",
+	"xhtml":        "html",
+	"zsh":          "bash",
+}
+
+// languageFilenames maps file names which say what language a file is in
+// without an extension to codes in LanguageNames.
+var languageFilenames = map[string]string{
+	".bash_profile":  "bash",
+	".bashrc":        "bash",
+	".profile":       "bash",
+	".vimrc":         "vim",
+	".zshrc":         "bash",
+	"cmakelists.txt": "cmake",
+	"gemfile":        "ruby",
+	"gnumakefile":    "makefile",
+	"makefile":       "makefile",
+	"nginx.conf":     "nginx",
+	"rakefile":       "ruby",
+}
+
+// languageCode returns the code in LanguageNames for a language name, or the
+// empty string if the name is not recognised.
+func languageCode(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := languageAliases[name]; ok {
+		return alias
+	}
+	if _, ok := LanguageNames[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// DetectLanguage guesses the language of a paste from its title and content,
+// returning a code from LanguageNames or the empty string if it can't tell.
+// Modelines are trusted first, then shebang lines, then file names in the
+// title, and finally the content itself.
+func DetectLanguage(title, content string) string {
+	for _, detect := range []func() string{
+		func() string { return modelineLanguage(content) },
+		func() string { return shebangLanguage(content) },
+		func() string { return titleLanguage(title) },
+		func() string { return contentLanguage(content) },
+	} {
+		if code := detect(); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+var (
+	emacsModeline = regexp.MustCompile(`-\*-\s*(.*?)\s*-\*-`)
+	emacsMode     = regexp.MustCompile(`(?i)(?:^|;)\s*mode:\s*([\w+#-]+)`)
+	vimModeline   = regexp.MustCompile(`(?:^|\s)(?:vi|vim|ex):.*?\b(?:ft|filetype|syntax|syn)=([\w+#-]+)`)
+)
+
+// modelineLanguage returns the language named by an Emacs modeline in the first
+// two lines of a paste, or a Vim modeline in its first or last five lines.
+func modelineLanguage(content string) string {
+	lines := strings.Split(content, "\n")
+
+	head, tail := lines, lines
+	if len(lines) > 5 {
+		head, tail = lines[:5], lines[len(lines)-5:]
+	}
+
+	for i, line := range head {
+		if i >= 2 {
+			break
+		}
+		if m := emacsModeline.FindStringSubmatch(line); m != nil {
+			if !strings.Contains(m[1], ":") {
+				return languageCode(m[1])
+			}
+			if mode := emacsMode.FindStringSubmatch(m[1]); mode != nil {
+				return languageCode(mode[1])
+			}
+		}
+	}
+
+	for _, lines := range [][]string{head, tail} {
+		for _, line := range lines {
+			if m := vimModeline.FindStringSubmatch(line); m != nil {
+				return languageCode(m[1])
+			}
+		}
+	}
+
+	return ""
+}
+
+var versionSuffix = regexp.MustCompile(`[0-9.]+$`)
+
+// shebangLanguage returns the language of the interpreter named on a paste's
+// "#!" line, e.g. "#!/usr/bin/env python3".
+func shebangLanguage(content string) string {
+	if !strings.HasPrefix(content, "#!") {
+		return ""
+	}
+
+	line := strings.SplitN(content[2:], "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") && !strings.Contains(f, "=") {
+				interpreter = path.Base(f)
+				break
+			}
+		}
+	}
+
+	if code := languageCode(interpreter); code != "" {
+		return code
+	}
+	return languageCode(versionSuffix.ReplaceAllString(interpreter, ""))
+}
+
+// titleLanguage returns the language of the first word in a paste's title which
+// looks like a file name, e.g. "main.go" or "Makefile".
+func titleLanguage(title string) string {
+	for _, word := range strings.Fields(title) {
+		if code := filenameLanguage(strings.Trim(word, `"'(),:;`)); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+// filenameLanguage returns the language of a file from its name.
+func filenameLanguage(name string) string {
+	base := strings.ToLower(path.Base(name))
+	if code, ok := languageFilenames[base]; ok {
+		return code
+	}
+
+	ext := path.Ext(base)
+	if ext == "" || ext == base {
+		return ""
+	}
+	return languageCode(ext[1:])
+}
+
+// languageRule is a piece of evidence that a paste is in a particular language.
+type languageRule struct {
+	language string
+	pattern  *regexp.Regexp
+	weight   int
+}
+
+func rule(language string, weight int, pattern string) languageRule {
+	return languageRule{language, regexp.MustCompile("(?m)" + pattern), weight}
+}
+
+// languageRules are the clues contentLanguage looks for.  Each rule counts
+// once, however often its pattern matches.
+var languageRules = []languageRule{
+	rule("bash", 3, `^\s*(fi|done|esac)\s*$`),
+	rule("bash", 2, `^\s*(export \w+=|echo |if \[\[? )`),
+	rule("bash", 2, `\| *(grep|awk|sed|xargs)\b`),
+	rule("bash", 1, `\$\{\w+\}`),
+	rule("c", 3, `^#include <\w+\.h>`),
+	rule("c", 2, `\bint main\(`),
+	rule("c", 2, `\bmalloc\(`),
+	rule("c", 1, `\bprintf\(`),
+	rule("cpp", 3, `^#include <\w+>`),
+	rule("cpp", 3, `\bstd::`),
+	rule("cpp", 3, `\bcout\s*<<`),
+	rule("cpp", 2, `^\s*(template\s*<|namespace \w+)`),
+	rule("cs", 4, `^using System(\.\w+)*;`),
+	rule("cs", 3, `\bConsole\.Write`),
+	rule("css", 2, `^\s*@(media|import)\b`),
+	rule("css", 2, `^\s*[.#]?[\w-]+( [.#]?[\w-]+)*\s*\{\s*$`),
+	rule("css", 1, `^\s*[\w-]+:\s*[^;]+;\s*$`),
+	rule("diff", 6, `^(diff --git |--- \S.*\n\+\+\+ \S|@@ -\d+(,\d+)? \+\d+(,\d+)? @@)`),
+	rule("go", 3, `^package \w+\s*$`),
+	rule("go", 3, `\bif err != nil \{`),
+	rule("go", 2, `^func (\(\w+ \*?\w+\) )?\w+\(`),
+	rule("go", 2, `^import \($`),
+	rule("go", 2, `\bfmt\.\w+\(`),
+	rule("go", 1, ` := `),
+	rule("haskell", 3, `^module [\w.]+.* where`),
+	rule("haskell", 3, `^\w+ :: .+`),
+	rule("haskell", 3, `^import qualified `),
+	rule("html", 5, `(?i)<!DOCTYPE html|<html[\s>]`),
+	rule("html", 2, `(?i)</(div|p|span|body|head|a)>`),
+	rule("ini", 2, `^\[[\w .-]+\]\s*$`),
+	rule("ini", 1, `^\w+\s*=\s*\S`),
+	rule("java", 3, `^\s*(public|private|protected) (static )?(final )?(class|void|int|String)\b`),
+	rule("java", 3, `\bSystem\.out\.print`),
+	rule("java", 3, `^import java\.`),
+	rule("java", 2, `^package [\w.]+;`),
+	rule("javascript", 3, `\b(const|let|var) \w+ = require\(`),
+	rule("javascript", 2, `\bconsole\.log\(`),
+	rule("javascript", 2, `\bdocument\.\w+`),
+	rule("javascript", 2, `^\s*(export default|module\.exports)`),
+	rule("javascript", 1, `\bfunction\s*\w*\s*\(`),
+	rule("javascript", 1, `=> \{`),
+	rule("lua", 2, `^\s*local (function )?\w+`),
+	rule("lua", 1, `\bthen\s*$`),
+	rule("lua", 1, `^\s*end\s*$`),
+	rule("makefile", 4, `^\.PHONY:`),
+	rule("makefile", 3, `^[\w./-]+:.*\n\t`),
+	rule("markdown", 2, "^```"),
+	rule("markdown", 2, `\[.+\]\(https?://`),
+	rule("markdown", 1, `^#{1,6} \w`),
+	rule("perl", 4, `^use strict;`),
+	rule("perl", 3, `\bmy [$@%]\w+`),
+	rule("perl", 2, `^\s*sub \w+ *\{`),
+	rule("php", 6, `<\?php`),
+	rule("php", 2, `\$\w+->`),
+	rule("powershell", 4, `\b(Write-Host|Get-ChildItem|Set-Item|Get-Content)\b`),
+	rule("python", 3, `^\s*def \w+\(.*\)( -> .+)?:\s*$`),
+	rule("python", 3, `^\s*class \w+(\(.*\))?:\s*$`),
+	rule("python", 3, `^if __name__ == ['"]__main__['"]:`),
+	rule("python", 2, `^\s*(elif .*|except\b.*|else|try|finally):\s*$`),
+	rule("python", 1, `^(from [\w.]+ )?import [\w., ]+$`),
+	rule("python", 1, `\bself\.\w+`),
+	rule("ruby", 3, `\.each do \|`),
+	rule("ruby", 3, `^\s*attr_(accessor|reader|writer) `),
+	rule("ruby", 2, `^\s*def \w+[?!]?(\(.*\))?\s*$`),
+	rule("ruby", 2, `^\s*require ['"]`),
+	rule("ruby", 1, `^\s*end\s*$`),
+	rule("ruby", 1, `\bputs\b`),
+	rule("rust", 3, `\blet mut\b`),
+	rule("rust", 3, `\bprintln!\(`),
+	rule("rust", 3, `^use \w+::`),
+	rule("rust", 2, `\bfn \w+(<.*>)?\(`),
+	rule("rust", 1, `^\s*(pub )?(struct|enum|impl|trait|mod) \w+`),
+	rule("sql", 3, `(?i)^\s*(SELECT\s.+\sFROM|INSERT INTO|CREATE (TABLE|INDEX|VIEW)|UPDATE \w+ SET|DELETE FROM|ALTER TABLE)\b`),
+	rule("typescript", 3, `^\s*(export )?interface \w+ \{`),
+	rule("typescript", 3, `\): (string|number|boolean|void)\b`),
+	rule("typescript", 2, `\b(let|const|var) \w+: \w+`),
+	rule("xml", 5, `^\s*<\?xml `),
+}
+
+// minLanguageScore is how much evidence contentLanguage needs before it will
+// guess a language.
+const minLanguageScore = 3
+
+// maxDetectBytes is how much of a paste contentLanguage looks at.
+const maxDetectBytes = 64 << 10
+
+// contentLanguage guesses the language of a paste from clues in its content,
+// choosing the language with the most evidence.  It returns the empty string
+// if there is too little evidence or two languages are tied.
+func contentLanguage(content string) string {
+	if len(content) > maxDetectBytes {
+		content = content[:maxDetectBytes]
+	}
+
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if json.Valid([]byte(trimmed)) {
+			return "json"
+		}
+	}
+
+	scores := make(map[string]int)
+	for _, r := range languageRules {
+		if r.pattern.MatchString(content) {
+			scores[r.language] += r.weight
+		}
+	}
+
+	best, bestScore, tied := "", 0, false
+	for language, score := range scores {
+		if score > bestScore {
+			best, bestScore, tied = language, score, false
+		} else if score == bestScore {
+			tied = true
+		}
+	}
+
+	if bestScore < minLanguageScore || tied {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,159 @@
+package gopaste
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLanguageTables(t *testing.T) {
+	for name, code := range languageAliases {
+		if _, ok := LanguageNames[code]; !ok {
+			t.Errorf("alias %q maps to unknown language %q", name, code)
+		}
+	}
+	for name, code := range languageFilenames {
+		if _, ok := LanguageNames[code]; !ok {
+			t.Errorf("file name %q maps to unknown language %q", name, code)
+		}
+		if name != strings.ToLower(name) {
+			t.Errorf("file name %q is not lowercase", name)
+		}
+	}
+	for _, r := range languageRules {
+		if _, ok := LanguageNames[r.language]; !ok {
+			t.Errorf("rule %s is for unknown language %q", r.pattern, r.language)
+		}
+	}
+}
+
+func TestModelineLanguage(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"# -*- python -*-\nx = 1\n", "python"},
+		{"#!/bin/sh\n# -*- mode: ruby; coding: utf-8 -*-\n", "ruby"},
+		{"/* -*- Mode: C++; tab-width: 4 -*- */\n", "cpp"},
+		{"-*- coding: utf-8 -*-\n", ""},
+		{"one\ntwo\n# -*- python -*-\n", ""},
+		{"# vim: set ft=perl :\nprint 1;\n", "perl"},
+		{"x\n# vi: filetype=sh\n", "bash"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n// vim:syntax=javascript\n", "javascript"},
+		{"1\n2\n3\n4\n5\n// vim: ft=go\n7\n8\n9\n10\n11\n", ""},
+		{"# vim: ft=klingon\n", ""},
+		{"not a modeline: ft=go\n", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := modelineLanguage(test.content); got != test.want {
+			t.Errorf("modelineLanguage(%q): got %q, want %q", test.content, got, test.want)
+		}
+	}
+}
+
+func TestShebangLanguage(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"#!/bin/bash\necho hi\n", "bash"},
+		{"#!/bin/sh", "bash"},
+		{"#! /usr/bin/perl -w\n", "perl"},
+		{"#!/usr/bin/env python3\n", "python"},
+		{"#!/usr/bin/python2.7\n", "python"},
+		{"#!/usr/bin/env -S node --harmony\n", "javascript"},
+		{"#!/usr/bin/env LANG=C ruby\n", "ruby"},
+		{"#!/usr/bin/env\n", ""},
+		{"#!\n", ""},
+		{"#!/usr/local/bin/frobnicate\n", ""},
+		{"# !/bin/bash\n", ""},
+		{"\n#!/bin/bash\n", ""},
+	}
+
+	for _, test := range tests {
+		if got := shebangLanguage(test.content); got != test.want {
+			t.Errorf("shebangLanguage(%q): got %q, want %q", test.content, got, test.want)
+		}
+	}
+}
+
+func TestTitleLanguage(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"main.go", "go"},
+		{"fix for (parser.PY)", "python"},
+		{"my Makefile", "makefile"},
+		{"src/CMakeLists.txt", "cmake"},
+		{"'lib.rs': borrow checker error", "rust"},
+		{"notes on ~/.bashrc", "bash"},
+		{"README", ""},
+		{"v1.0 release notes", ""},
+		{"archive.tar.xyz", ""},
+		{".go", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := titleLanguage(test.title); got != test.want {
+			t.Errorf("titleLanguage(%q): got %q, want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestContentLanguage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"go", "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n", "go"},
+		{"python", "import os\n\ndef main():\n    print(os.getcwd())\n\nif __name__ == '__main__':\n    main()\n", "python"},
+		{"c", "#include <stdio.h>\n\nint main() {\n\tprintf(\"hi\\n\");\n}\n", "c"},
+		{"cpp", "#include <iostream>\n\nint main() {\n\tstd::cout << \"hi\";\n}\n", "cpp"},
+		{"diff", "--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n", "diff"},
+		{"json object", `{"a": [1, 2, {"b": null}]}`, "json"},
+		{"json array", "  [1, 2, 3]\n", "json"},
+		{"invalid json", `{"a": 1,}`, ""},
+		{"sql", "SELECT id, title FROM pastes WHERE id = 1;\n", "sql"},
+		{"php", "<?php echo $x; ?>\n", "php"},
+		{"xml", "<?xml version=\"1.0\"?>\n<a/>\n", "xml"},
+		{"html", "<!DOCTYPE html>\n<html><body></body></html>\n", "html"},
+		{"bash", "for f in *; do\n  echo $f | grep x\ndone\n", "bash"},
+		{"ruby", "require 'json'\n[1, 2].each do |x|\n  puts x\nend\n", "ruby"},
+		{"rust", "fn main() {\n    let mut x = 1;\n    println!(\"{}\", x);\n}\n", "rust"},
+		{"prose", "The quick brown fox jumps over the lazy dog.\n", ""},
+		{"too little evidence", "x := 1\n", ""},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		if got := contentLanguage(test.content); got != test.want {
+			t.Errorf("%s: contentLanguage(%q): got %q, want %q", test.name, test.content, got, test.want)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name    string
+		title   string
+		content string
+		want    string
+	}{
+		{"modeline beats shebang", "", "#!/bin/sh\n# -*- mode: python -*-\n", "python"},
+		{"shebang beats title", "build.rb", "#!/bin/bash\nmake\n", "bash"},
+		{"title beats content", "query.txt.go", "SELECT 1 FROM t;\n", "go"},
+		{"content", "untitled", "package main\n\nfunc main() {\n}\n", "go"},
+		{"unknown shebang falls through", "x.lua", "#!/opt/weird\nprint(1)\n", "lua"},
+		{"nothing", "", "hello, world\n", ""},
+	}
+
+	for _, test := range tests {
+		if got := DetectLanguage(test.title, test.content); got != test.want {
+			t.Errorf("%s: DetectLanguage(%q, %q): got %q, want %q", test.name, test.title, test.content, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package gopaste
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// execer is the subset of methods shared by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Dialect describes the differences between the SQL databases gopaste can
+// store pastes in.  Queries in SqlStore are written with ? placeholders and
+// otherwise portable SQL; anything else goes through the dialect.
+type Dialect interface {
+	// Name returns the name of the dialect, which is also the name of its
+	// database/sql driver.
+	Name() string
+
+	// DataSource adjusts a data source name before it is opened, adding any
+	// options the store relies on.
+	DataSource(source string) string
+
+	// Rebind converts a query written with ? placeholders into the dialect's
+	// own placeholder syntax.
+	Rebind(query string) string
+
+	// ExecScript runs a series of semicolon-terminated statements.
+	ExecScript(e execer, script string) error
+
+	// InsertId runs an INSERT statement, written with ? placeholders, on a
+	// table with an automatically generated id column and returns the ID of
+	// the new row.
+	InsertId(e execer, query string, args ...interface{}) (int64, error)
+
+	// NextPublicId allocates the next ID for a public paste.
+	NextPublicId(e execer) (int64, error)
+
+	// SearchSql returns a subquery, and its parameters, which yields a row
+	// with "root" and "score" columns for each paste thread containing a
+	// paste which matches a full-text search query.  Lower scores are better
+	// matches.
+	SearchSql(query string) (string, []interface{})
+
+	// SetupSearch prepares the full-text index which SearchSql relies on when
+	// the store is opened, and reports whether the database has one.  Without
+	// it, searches fall back to likeSearchSql.
+	SetupSearch(db *sql.DB) (bool, error)
+
+	// Retryable reports whether an error is a transient conflict with another
+	// transaction, so that the failed transaction can safely be run again.
+	Retryable(err error) bool
+}
+
+var dialects = map[string]Dialect{
+	"sqlite3":  sqliteDialect{},
+	"postgres": postgresDialect{},
+	"mysql":    mysqlDialect{},
+}
+
+// GetDialect returns the Dialect for a database/sql driver name.
+func GetDialect(driver string) (Dialect, error) {
+	dialect, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver '%s'", driver)
+	}
+	return dialect, nil
+}
+
+// insertLastId runs an INSERT statement and returns the ID of the new row
+// using sql.Result.LastInsertId.
+func insertLastId(e execer, query string, args ...interface{}) (int64, error) {
+	result, err := e.Exec(query, args...)
+	if err != nil {
+		return InvalidPasteId, err
+	}
+	return result.LastInsertId()
+}
+
+// nextCounterId allocates an ID by inserting a row into the paste_ids table,
+// whose id column is generated automatically, then discards any older rows.
+// The latest row is kept so that the counter never goes backwards.
+func nextCounterId(e execer, insertSql string) (int64, error) {
+	id, err := insertLastId(e, insertSql)
+	if err != nil {
+		return InvalidPasteId, err
+	}
+
+	if _, err := e.Exec("DELETE FROM paste_ids WHERE id < ?", id); err != nil {
+		return InvalidPasteId, err
+	}
+
+	return id, nil
+}
+
+// likeSearchSql returns a subquery like those from Dialect.SearchSql for
+// databases without a full-text index.  It matches the pastes whose title or
+// content contains every word of the query, ignoring case, and gives them all
+// the same score, so results are listed newest first.
+func likeSearchSql(query string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, term := range searchTerms(query) {
+		// terms contain only letters and digits, so they need no escaping
+		conditions = append(conditions, "(LOWER(COALESCE(p.title, '')) LIKE ? OR LOWER(p.content) LIKE ?)")
+		args = append(args, "%"+term+"%", "%"+term+"%")
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "1 = 0")
+	}
+
+	return `
+		SELECT COALESCE(p.annotates, p.id) AS root,
+		       0 AS score
+		FROM pastes p
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY root
+	`, args
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite3"
+}
+
+// DataSource makes transactions take the write lock as soon as they begin.
+// Otherwise a transaction which reads before it writes fails at once with
+// SQLITE_BUSY if another one is writing, instead of waiting its turn.
+func (sqliteDialect) DataSource(source string) string {
+	if strings.Contains(source, "_txlock=") {
+		return source
+	}
+	if strings.Contains(source, "?") {
+		return source + "&_txlock=immediate"
+	}
+	return source + "?_txlock=immediate"
+}
+
+func (sqliteDialect) Rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) ExecScript(e execer, script string) error {
+	_, err := e.Exec(script)
+	return err
+}
+
+func (sqliteDialect) InsertId(e execer, query string, args ...interface{}) (int64, error) {
+	return insertLastId(e, query, args...)
+}
+
+func (sqliteDialect) NextPublicId(e execer) (int64, error) {
+	return nextCounterId(e, "INSERT INTO paste_ids DEFAULT VALUES")
+}
+
+func (sqliteDialect) SearchSql(query string) (string, []interface{}) {
+	return `
+		SELECT COALESCE(p.annotates, p.id) AS root,
+		       MIN(pastes_fts.rank) AS score
+		FROM pastes_fts
+		    JOIN pastes p ON p.id = pastes_fts.rowid
+		WHERE pastes_fts MATCH ?
+		  AND pastes_fts.rank MATCH 'bm25(10.0, 1.0)'
+		GROUP BY root
+	`, []interface{}{ftsQuery(query)}
+}
+
+// sqliteFtsSql creates the FTS5 index of paste titles and content, along with
+// the triggers which keep it up to date, and rebuilds it from the pastes table.
+const sqliteFtsSql = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS pastes_fts USING fts5(
+		title,
+		content,
+		content='pastes',
+		content_rowid='id'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS pastes_fts_insert AFTER INSERT ON pastes BEGIN
+		INSERT INTO pastes_fts (rowid, title, content)
+		VALUES (new.id, new.title, new.content);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS pastes_fts_delete AFTER DELETE ON pastes BEGIN
+		INSERT INTO pastes_fts (pastes_fts, rowid, title, content)
+		VALUES ('delete', old.id, old.title, old.content);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS pastes_fts_update AFTER UPDATE ON pastes BEGIN
+		INSERT INTO pastes_fts (pastes_fts, rowid, title, content)
+		VALUES ('delete', old.id, old.title, old.content);
+		INSERT INTO pastes_fts (rowid, title, content)
+		VALUES (new.id, new.title, new.content);
+	END;
+
+	INSERT INTO pastes_fts (pastes_fts) VALUES ('rebuild');
+`
+
+// sqliteFtsTriggers drops the triggers which keep the FTS5 index up to date.
+const sqliteFtsTriggers = `
+	DROP TRIGGER IF EXISTS pastes_fts_insert;
+	DROP TRIGGER IF EXISTS pastes_fts_delete;
+	DROP TRIGGER IF EXISTS pastes_fts_update;
+`
+
+// SetupSearch creates the FTS5 index if SQLite was built with FTS5 and the
+// index is missing.  If it was not, the index's triggers are dropped, since
+// they would make every change to the pastes table fail; the index is rebuilt
+// the next time the store is opened with FTS5.
+func (sqliteDialect) SetupSearch(db *sql.DB) (bool, error) {
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return false, err
+	}
+
+	if !fts5 {
+		_, err := db.Exec(sqliteFtsTriggers)
+		return false, err
+	}
+
+	var triggers int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'pastes_fts_%'").Scan(&triggers)
+	if err != nil || triggers == 3 {
+		return err == nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	if _, err = tx.Exec(sqliteFtsSql); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (sqliteDialect) Retryable(err error) bool {
+	var e sqlite3.Error
+	return errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) DataSource(source string) string {
+	return source
+}
+
+// Rebind replaces each ? placeholder outside of a quoted string with $1, $2,
+// etc.
+func (postgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	var quote rune
+	n := 0
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (postgresDialect) ExecScript(e execer, script string) error {
+	_, err := e.Exec(script)
+	return err
+}
+
+// InsertId uses a RETURNING clause, since lib/pq does not support
+// LastInsertId.
+func (d postgresDialect) InsertId(e execer, query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := e.QueryRow(d.Rebind(query)+" RETURNING id", args...).Scan(&id)
+	if err != nil {
+		return InvalidPasteId, err
+	}
+	return id, nil
+}
+
+func (postgresDialect) NextPublicId(e execer) (int64, error) {
+	var id int64
+	if err := e.QueryRow("SELECT nextval('paste_ids')").Scan(&id); err != nil {
+		return InvalidPasteId, err
+	}
+	return id, nil
+}
+
+func (postgresDialect) SearchSql(query string) (string, []interface{}) {
+	return `
+		SELECT COALESCE(p.annotates, p.id) AS root,
+		       -MAX(ts_rank(p.search, q)) AS score
+		FROM pastes p, plainto_tsquery('simple', ?) q
+		WHERE p.search @@ q
+		GROUP BY root
+	`, []interface{}{strings.Join(searchTerms(query), " ")}
+}
+
+// SetupSearch does nothing, since the search column and its index are created
+// by migrations.
+func (postgresDialect) SetupSearch(db *sql.DB) (bool, error) {
+	return true, nil
+}
+
+// Retryable accepts serialization failures and deadlocks.
+func (postgresDialect) Retryable(err error) bool {
+	var e *pq.Error
+	return errors.As(err, &e) && (e.Code == "40001" || e.Code == "40P01")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) DataSource(source string) string {
+	return source
+}
+
+func (mysqlDialect) Rebind(query string) string {
+	return query
+}
+
+// ExecScript runs each statement separately, since the MySQL driver only
+// accepts multiple statements at once when the multiStatements option is set.
+func (mysqlDialect) ExecScript(e execer, script string) error {
+	for _, statement := range strings.Split(script, ";\n") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := e.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mysqlDialect) InsertId(e execer, query string, args ...interface{}) (int64, error) {
+	return insertLastId(e, query, args...)
+}
+
+func (mysqlDialect) NextPublicId(e execer) (int64, error) {
+	return nextCounterId(e, "INSERT INTO paste_ids () VALUES ()")
+}
+
+func (mysqlDialect) SearchSql(query string) (string, []interface{}) {
+	// require every word in boolean mode, as the other dialects do
+	var words []string
+	for _, term := range searchTerms(query) {
+		words = append(words, `+"`+term+`"`)
+	}
+	match := strings.Join(words, " ")
+
+	return `
+		SELECT COALESCE(p.annotates, p.id) AS root,
+		       -MAX(MATCH (p.title, p.content) AGAINST (? IN BOOLEAN MODE)) AS score
+		FROM pastes p
+		WHERE MATCH (p.title, p.content) AGAINST (? IN BOOLEAN MODE)
+		GROUP BY root
+	`, []interface{}{match, match}
+}
+
+// SetupSearch does nothing, since the FULLTEXT index is created by migrations.
+func (mysqlDialect) SetupSearch(db *sql.DB) (bool, error) {
+	return true, nil
+}
+
+// Retryable accepts deadlocks and lock wait timeouts.
+func (mysqlDialect) Retryable(err error) bool {
+	var e *mysql.MySQLError
+	return errors.As(err, &e) && (e.Number == 1213 || e.Number == 1205)
+}
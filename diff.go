@@ -0,0 +1,555 @@
+package gopaste
+
+import (
+	"fmt"
+	"github.com/aryann/difflib"
+	"regexp"
+	"strings"
+)
+
+// Diff display modes, chosen with the "mode" query parameter.
+const (
+	DiffUnified = "unified"
+	DiffSplit   = "split"
+	DiffWords   = "words"
+)
+
+// DiffModes lists the diff display modes, the default first.
+var DiffModes = []string{DiffUnified, DiffSplit, DiffWords}
+
+// validDiffMode reports whether a string is one of DiffModes.
+func validDiffMode(mode string) bool {
+	for _, m := range DiffModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffText is a run of text within a line of a diff.  Op is "del" if the text
+// was removed, "ins" if it was added, or empty if it is on both sides.
+type DiffText struct {
+	Op   string
+	Text string
+}
+
+// DiffCell is one side of a row of a diff.  Num is 0 if the row has no line on
+// this side.
+type DiffCell struct {
+	Num   int
+	Url   string
+	Parts []DiffText
+}
+
+// DiffRow is one row of a diff.  Op is "del" for a line only on the left,
+// "ins" for a line only on the right, "change" for a changed line shown on
+// both sides, or empty for an unchanged line.
+type DiffRow struct {
+	Op    string
+	Left  DiffCell
+	Right DiffCell
+}
+
+// FileDiff is the diff between a file of one paste and the file with the same
+// name in another.  From or To is nil if the file is only in one of them.
+type FileDiff struct {
+	From *PasteFile
+	To   *PasteFile
+	Rows []DiffRow
+}
+
+// Label describes the files being compared, or is empty if neither is named.
+func (d FileDiff) Label() string {
+	switch {
+	case d.From == nil:
+		return d.To.Filename + " (added)"
+	case d.To == nil:
+		return d.From.Filename + " (removed)"
+	case d.From.Filename != d.To.Filename && d.From.Filename != "" && d.To.Filename != "":
+		return d.From.Filename + " \u2192 " + d.To.Filename
+	case d.From.Filename != "":
+		return d.From.Filename
+	}
+	return d.To.Filename
+}
+
+// filePair is a file of one paste and the matching file of another.  Either
+// is nil if the file is only in one of the pastes.
+type filePair struct {
+	from, to *PasteFile
+}
+
+// pairFiles matches up the files of two pastes by name, in the order of the
+// first paste followed by the files only in the second.  The first files of
+// the pastes are also paired if neither has a match by name, so that two
+// pastes with one file each are always compared with each other.
+func pairFiles(from, to *Paste) []filePair {
+	toFiles := to.Files()
+	matched := make(map[int]bool)
+
+	var pairs []filePair
+	for _, f := range from.Files() {
+		pair := filePair{from: f}
+		if g := to.File(f.Filename); g != nil {
+			pair.to = g
+			matched[g.Num] = true
+		}
+		pairs = append(pairs, pair)
+	}
+
+	if first := toFiles[0]; pairs[0].to == nil && !matched[first.Num] && from.File(first.Filename) == nil {
+		pairs[0].to = first
+		matched[first.Num] = true
+	}
+
+	for _, g := range toFiles {
+		if !matched[g.Num] {
+			pairs = append(pairs, filePair{to: g})
+		}
+	}
+	return pairs
+}
+
+// diffSide is one of the two versions of a file being diffed.
+type diffSide struct {
+	paste   *Paste
+	rev     int
+	lines   []string
+	numbers []LineNumber
+}
+
+// newDiffSide returns the side of a diff for a file of a paste, which has no
+// lines if the file is nil.
+func newDiffSide(p *Paste, f *PasteFile, rev int) *diffSide {
+	d := &diffSide{paste: p, rev: rev}
+	if f != nil {
+		d.lines = strings.Split(strings.TrimSuffix(f.Content, "\n"), "\n")
+		d.numbers = f.LineNumbers(*p)
+	}
+	return d
+}
+
+// cell returns the cell for the line at index i, split into parts.
+func (d *diffSide) cell(i int, parts []DiffText) DiffCell {
+	if parts == nil {
+		parts = []DiffText{{Text: d.lines[i]}}
+	}
+	return DiffCell{Num: i + 1, Url: d.lineUrl(d.numbers[i]), Parts: parts}
+}
+
+// lineUrl returns the URL of a line within the view of its paste, or of the
+// diffed revision of its paste.
+func (d *diffSide) lineUrl(num LineNumber) string {
+	if d.rev > 0 {
+		return fmt.Sprintf("/view/%s/rev/%d#%s", d.paste.Ref(), d.rev, num.Anchor)
+	}
+	return fmt.Sprintf("/view/%s#%s", num.RootRef, num.Anchor)
+}
+
+// Values of the "ignore" query parameter, which chooses which differences
+// between lines a diff disregards.  They are named after the matching options
+// to git diff.
+const (
+	IgnoreNothing     = ""
+	IgnoreSpaceChange = "space-change"
+	IgnoreAllSpace    = "all-space"
+	IgnoreBlankLines  = "blank-lines"
+	IgnoreCase        = "case"
+)
+
+// DiffIgnore describes a value of the "ignore" query parameter.
+type DiffIgnore struct {
+	Value string
+	Label string
+}
+
+// DiffIgnores lists the values of the "ignore" query parameter, the default
+// first.
+var DiffIgnores = []DiffIgnore{
+	{IgnoreNothing, "exact"},
+	{IgnoreSpaceChange, "ignore amount of whitespace"},
+	{IgnoreAllSpace, "ignore all whitespace"},
+	{IgnoreBlankLines, "ignore blank lines"},
+	{IgnoreCase, "ignore case"},
+}
+
+// validDiffIgnore reports whether a string is a value in DiffIgnores.
+func validDiffIgnore(ignore string) bool {
+	for _, i := range DiffIgnores {
+		if i.Value == ignore {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	spaceRun  = regexp.MustCompile(`[ \t\r]+`)
+	allSpace  = regexp.MustCompile(`\s+`)
+	blankLine = regexp.MustCompile(`^\s*$`)
+)
+
+// lineKeys returns the lines as they are compared under an ignore option.
+func lineKeys(lines []string, ignore string) []string {
+	keys := make([]string, len(lines))
+	for i, line := range lines {
+		switch ignore {
+		case IgnoreSpaceChange:
+			line = strings.TrimRight(spaceRun.ReplaceAllString(line, " "), " ")
+		case IgnoreAllSpace:
+			line = allSpace.ReplaceAllString(line, "")
+		case IgnoreCase:
+			line = strings.ToLower(line)
+		}
+		keys[i] = line
+	}
+	return keys
+}
+
+// lineOp is one step of a line diff.  Op is "del" for a line only on the left,
+// "ins" for a line only on the right, or empty for a line on both sides.  L
+// and R are the indexes of the line on each side, or -1 if the line is not on
+// that side; an unchanged line on only one side is a blank line whose removal
+// or addition is ignored.
+type lineOp struct {
+	Op   string
+	L, R int
+}
+
+// diffLines compares two lists of lines, given the keys they are compared by.
+func diffLines(a, b, aKeys, bKeys []string, ignore string) (ops []lineOp) {
+	l, r := 0, 0
+	for _, record := range difflib.Diff(aKeys, bKeys) {
+		switch record.Delta {
+		case difflib.LeftOnly:
+			if ignore == IgnoreBlankLines && blankLine.MatchString(a[l]) {
+				ops = append(ops, lineOp{"", l, -1})
+			} else {
+				ops = append(ops, lineOp{"del", l, -1})
+			}
+			l++
+		case difflib.RightOnly:
+			if ignore == IgnoreBlankLines && blankLine.MatchString(b[r]) {
+				ops = append(ops, lineOp{"", -1, r})
+			} else {
+				ops = append(ops, lineOp{"ins", -1, r})
+			}
+			r++
+		default:
+			ops = append(ops, lineOp{"", l, r})
+			l++
+			r++
+		}
+	}
+	return ops
+}
+
+// DiffFiles compares the files of two pastes line by line, disregarding the
+// differences chosen by an ignore option, and returns the diff of each pair of
+// files in the given mode.  A revision number of 0 means the current version
+// of a paste.
+func DiffFiles(from *Paste, fromRev int, to *Paste, toRev int, mode, ignore string) (diffs []FileDiff) {
+	for _, pair := range pairFiles(from, to) {
+		left, right := newDiffSide(from, pair.from, fromRev), newDiffSide(to, pair.to, toRev)
+		diffs = append(diffs, FileDiff{pair.from, pair.to, diffRows(left, right, mode, ignore)})
+	}
+	return diffs
+}
+
+// diffRows compares two versions of a file and returns the rows of the diff.
+func diffRows(left, right *diffSide, mode, ignore string) []DiffRow {
+	var rows []DiffRow
+	var dels, inss []int
+	flush := func() {
+		rows = append(rows, changeRows(left, right, dels, inss, mode)...)
+		dels, inss = nil, nil
+	}
+
+	aKeys, bKeys := lineKeys(left.lines, ignore), lineKeys(right.lines, ignore)
+	for _, op := range diffLines(left.lines, right.lines, aKeys, bKeys, ignore) {
+		switch op.Op {
+		case "del":
+			dels = append(dels, op.L)
+		case "ins":
+			inss = append(inss, op.R)
+		default:
+			flush()
+			var row DiffRow
+			if op.L >= 0 {
+				row.Left = left.cell(op.L, nil)
+			}
+			if op.R >= 0 {
+				row.Right = right.cell(op.R, nil)
+			}
+			rows = append(rows, row)
+		}
+	}
+	flush()
+
+	return rows
+}
+
+// DiffStat counts the lines added and removed between the files of two
+// pastes, comparing lines exactly.  A nil paste counts as empty.
+func DiffStat(from, to *Paste) (added, removed int) {
+	if from == nil {
+		from = &Paste{}
+	}
+	if to == nil {
+		to = &Paste{}
+	}
+
+	for _, pair := range pairFiles(from, to) {
+		var a, b []string
+		if pair.from != nil {
+			a, _ = patchLines(pair.from.Content)
+		}
+		if pair.to != nil {
+			b, _ = patchLines(pair.to.Content)
+		}
+
+		for _, op := range diffLines(a, b, a, b, IgnoreNothing) {
+			switch op.Op {
+			case "del":
+				removed++
+			case "ins":
+				added++
+			}
+		}
+	}
+	return added, removed
+}
+
+// changeRows returns the rows for a block of removed lines followed by a block
+// of added lines.  In split and words modes, removed and added lines are
+// paired up and the differences within each pair are marked.
+func changeRows(left, right *diffSide, dels, inss []int, mode string) (rows []DiffRow) {
+	paired := 0
+	if mode != DiffUnified {
+		paired = len(dels)
+		if len(inss) < paired {
+			paired = len(inss)
+		}
+	}
+
+	for i := 0; i < paired; i++ {
+		l, r := dels[i], inss[i]
+		parts := diffWords(left.lines[l], right.lines[r])
+
+		row := DiffRow{Op: "change"}
+		if mode == DiffWords {
+			row.Left = left.cell(l, parts)
+			row.Right = right.cell(r, parts)
+		} else {
+			row.Left = left.cell(l, filterParts(parts, "del"))
+			row.Right = right.cell(r, filterParts(parts, "ins"))
+		}
+		rows = append(rows, row)
+	}
+
+	for _, l := range dels[paired:] {
+		rows = append(rows, DiffRow{Op: "del", Left: left.cell(l, nil)})
+	}
+	for _, r := range inss[paired:] {
+		rows = append(rows, DiffRow{Op: "ins", Right: right.cell(r, nil)})
+	}
+	return rows
+}
+
+var wordToken = regexp.MustCompile(`[\p{L}\p{N}_]+|\s+|.`)
+
+// diffWords compares two lines word by word, with each punctuation character
+// counting as a word of its own.
+func diffWords(a, b string) []DiffText {
+	var parts []DiffText
+	add := func(op, text string) {
+		if n := len(parts); n > 0 && parts[n-1].Op == op {
+			parts[n-1].Text += text
+		} else {
+			parts = append(parts, DiffText{op, text})
+		}
+	}
+
+	for _, record := range difflib.Diff(wordToken.FindAllString(a, -1), wordToken.FindAllString(b, -1)) {
+		switch record.Delta {
+		case difflib.LeftOnly:
+			add("del", record.Payload)
+		case difflib.RightOnly:
+			add("ins", record.Payload)
+		default:
+			add("", record.Payload)
+		}
+	}
+	return parts
+}
+
+// filterParts returns the unchanged parts of a line along with those with the
+// given op, i.e. one side of a word diff.
+func filterParts(parts []DiffText, op string) []DiffText {
+	var out []DiffText
+	for _, p := range parts {
+		if p.Op == "" || p.Op == op {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// patchLines splits text into lines for a patch, and reports whether the last
+// line ends with a newline.
+func patchLines(text string) ([]string, bool) {
+	if text == "" {
+		return nil, true
+	}
+	eol := strings.HasSuffix(text, "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n"), eol
+}
+
+// hunkRange formats the start and length of one side of a hunk.  start is the
+// number of lines on that side before the hunk.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Patch returns a unified diff that turns the files of one paste into those of
+// another, with a section for each file that differs, or the empty string if
+// there are no differences.  Files are matched up by name, and named as in the
+// first paste, or after the paste itself if they have no name, so that the
+// patch applies to its raw files with git apply or patch -p1.
+func Patch(from, to *Paste, context int, ignore string) string {
+	var out strings.Builder
+	for _, pair := range pairFiles(from, to) {
+		oldName, newName := "/dev/null", "/dev/null"
+		var a, b string
+		if pair.from != nil {
+			oldName = "a/" + patchName(from, pair.from)
+			a = pair.from.Content
+		}
+		if pair.to != nil {
+			newName = "b/" + patchName(to, pair.to)
+			if pair.from != nil {
+				newName = "b/" + patchName(from, pair.from)
+			}
+			b = pair.to.Content
+		}
+		out.WriteString(filePatch(oldName, newName, a, b, context, ignore))
+	}
+	return out.String()
+}
+
+// patchName returns the name of a file of a paste in a patch.
+func patchName(p *Paste, f *PasteFile) string {
+	if f.Filename != "" {
+		return f.Filename
+	}
+	return p.Ref()
+}
+
+// filePatch returns a unified diff that turns one text into the other, with
+// the given number of lines of context around each change, or the empty
+// string if there are no differences.
+//
+// Differences disregarded by the ignore option are left out, so applying the
+// patch keeps the left text's version of those lines.
+func filePatch(oldName, newName, from, to string, context int, ignore string) string {
+	a, aEol := patchLines(from)
+	b, bEol := patchLines(to)
+
+	// a missing newline at the end is a change to the last line
+	aKeys, bKeys := lineKeys(a, ignore), lineKeys(b, ignore)
+	if !aEol {
+		aKeys[len(aKeys)-1] += "\x00"
+	}
+	if !bEol {
+		bKeys[len(bKeys)-1] += "\x00"
+	}
+
+	// ignored blank lines on the left stay as context, and those on the right
+	// are dropped
+	var ops []lineOp
+	for _, op := range diffLines(a, b, aKeys, bKeys, ignore) {
+		if op.L >= 0 || op.Op == "ins" {
+			ops = append(ops, op)
+		}
+	}
+
+	// group the changes into hunks, merging hunks whose context would overlap
+	type hunk struct{ start, end int }
+	var hunks []hunk
+	for i, op := range ops {
+		if op.Op == "" {
+			continue
+		}
+		start, end := i-context, i+context+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if n := len(hunks); n > 0 && start <= hunks[n-1].end {
+			hunks[n-1].end = end
+		} else {
+			hunks = append(hunks, hunk{start, end})
+		}
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	// the number of lines on each side before the op being written
+	oldPos, newPos, i := 0, 0, 0
+	advance := func(op lineOp) {
+		if op.Op != "ins" {
+			oldPos++
+		}
+		if op.Op != "del" {
+			newPos++
+		}
+	}
+
+	for _, h := range hunks {
+		for ; i < h.start; i++ {
+			advance(ops[i])
+		}
+
+		oldCount, newCount := 0, 0
+		for _, op := range ops[h.start:h.end] {
+			if op.Op != "ins" {
+				oldCount++
+			}
+			if op.Op != "del" {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldPos, oldCount), hunkRange(newPos, newCount))
+
+		for ; i < h.end; i++ {
+			op := ops[i]
+			switch op.Op {
+			case "del":
+				fmt.Fprintf(&out, "-%s\n", a[op.L])
+			case "ins":
+				fmt.Fprintf(&out, "+%s\n", b[op.R])
+			default:
+				fmt.Fprintf(&out, " %s\n", a[op.L])
+			}
+			if (op.Op != "ins" && op.L == len(a)-1 && !aEol) || (op.Op == "ins" && op.R == len(b)-1 && !bEol) {
+				out.WriteString("\\ No newline at end of file\n")
+			}
+			advance(op)
+		}
+	}
+
+	return out.String()
+}
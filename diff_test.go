@@ -0,0 +1,426 @@
+package gopaste
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []DiffText
+	}{
+		{"same", "same", []DiffText{{"", "same"}}},
+		{"x = 1", "x = 2", []DiffText{{"", "x = "}, {"del", "1"}, {"ins", "2"}}},
+		{"foo(a, b)", "foo(a)", []DiffText{{"", "foo(a"}, {"del", ", b"}, {"", ")"}}},
+		{"hello world", "hello big world", []DiffText{{"", "hello "}, {"ins", "big "}, {"", "world"}}},
+		{"naïve café", "naïve cafés", []DiffText{{"", "naïve "}, {"del", "café"}, {"ins", "cafés"}}},
+		{"a\tb", "a b", []DiffText{{"", "a"}, {"del", "\t"}, {"ins", " "}, {"", "b"}}},
+		{"", "new", []DiffText{{"ins", "new"}}},
+		{"old", "", []DiffText{{"del", "old"}}},
+	}
+
+	for _, test := range tests {
+		if got := diffWords(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("diffWords(%q, %q): got %q, want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// diffRowSummary describes a diff row as its op and each side's line number
+// and text, with removed text marked as {-...} and added text as {+...}.
+func diffRowSummary(row DiffRow) string {
+	marks := map[string]string{"": "%s", "del": "{-%s}", "ins": "{+%s}"}
+	side := func(c DiffCell) string {
+		text := ""
+		for _, p := range c.Parts {
+			text += fmt.Sprintf(marks[p.Op], p.Text)
+		}
+		return fmt.Sprintf("%d:%s", c.Num, text)
+	}
+	return fmt.Sprintf("%s %s %s", row.Op, side(row.Left), side(row.Right))
+}
+
+func TestDiffRows(t *testing.T) {
+	from := &Paste{Id: 1, Content: "a\nx = 1\nb\nold\n"}
+	to := &Paste{Id: 2, Content: "a\nx = 2\nb\nnew\nmore\n"}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{DiffUnified, []string{
+			" 1:a 1:a",
+			"del 2:x = 1 0:",
+			"ins 0: 2:x = 2",
+			" 3:b 3:b",
+			"del 4:old 0:",
+			"ins 0: 4:new",
+			"ins 0: 5:more",
+		}},
+		{DiffSplit, []string{
+			" 1:a 1:a",
+			"change 2:x = {-1} 2:x = {+2}",
+			" 3:b 3:b",
+			"change 4:{-old} 4:{+new}",
+			"ins 0: 5:more",
+		}},
+		{DiffWords, []string{
+			" 1:a 1:a",
+			"change 2:x = {-1}{+2} 2:x = {-1}{+2}",
+			" 3:b 3:b",
+			"change 4:{-old}{+new} 4:{-old}{+new}",
+			"ins 0: 5:more",
+		}},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, row := range DiffFiles(from, 0, to, 0, test.mode, IgnoreNothing)[0].Rows {
+			got = append(got, diffRowSummary(row))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("mode %s: got\n%s\nwant\n%s", test.mode, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	tests := []struct {
+		from, to       string
+		added, removed int
+	}{
+		{"a\nb\n", "a\nb\n", 0, 0},
+		{"a\nb\n", "a\nc\nd\n", 2, 1},
+		{"a\nb\n", "a\nb", 0, 0},
+		{"a\n", "", 0, 1},
+		{"", "a\nb", 2, 0},
+		{"a b\n", "a  b\n", 1, 1},
+	}
+
+	for _, test := range tests {
+		added, removed := DiffStat(&Paste{Content: test.from}, &Paste{Content: test.to})
+		if added != test.added || removed != test.removed {
+			t.Errorf("DiffStat(%q, %q): got +%d -%d, want +%d -%d", test.from, test.to, added, removed, test.added, test.removed)
+		}
+	}
+
+	if added, removed := DiffStat(nil, &Paste{Content: "a\nb\n"}); added != 2 || removed != 0 {
+		t.Errorf("DiffStat from nil: got +%d -%d, want +2 -0", added, removed)
+	}
+}
+
+func TestDiffIgnore(t *testing.T) {
+	tests := []struct {
+		ignore   string
+		from, to string
+		changed  bool
+	}{
+		{IgnoreNothing, "if x:\n\treturn 1\n", "if x:\n    return  1\n", true},
+		{IgnoreSpaceChange, "if x:\n\treturn 1\n", "if x:\n return  1 \n", false},
+		{IgnoreSpaceChange, "return 1\n", "return1\n", true},
+		{IgnoreAllSpace, "return 1\n", "  return1\n", false},
+		{IgnoreAllSpace, "return 1\n", "return 2\n", true},
+		{IgnoreBlankLines, "a\nb\n", "a\n\n  \nb\n", false},
+		{IgnoreBlankLines, "a\n\nb\n", "a\nb\n", false},
+		{IgnoreBlankLines, "a\nb\n", "a\nc\n", true},
+		{IgnoreCase, "SELECT 1\n", "select 1\n", false},
+		{IgnoreCase, "SELECT 1\n", "select  1\n", true},
+	}
+
+	for _, test := range tests {
+		from, to := &Paste{Id: 1, Content: test.from}, &Paste{Id: 2, Content: test.to}
+		changed := false
+		for _, row := range DiffFiles(from, 0, to, 0, DiffSplit, test.ignore)[0].Rows {
+			changed = changed || row.Op != ""
+		}
+		if changed != test.changed {
+			t.Errorf("ignore %q, %q to %q: got changed %v, want %v", test.ignore, test.from, test.to, changed, test.changed)
+		}
+		if patch := Patch(from, to, 3, test.ignore); (patch != "") != test.changed {
+			t.Errorf("ignore %q, %q to %q: got patch %q", test.ignore, test.from, test.to, patch)
+		}
+	}
+}
+
+// numberedLines returns lines "1" to "n", each followed by a newline.
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestPatchHunks(t *testing.T) {
+	lines := numberedLines(20)
+	tests := []struct {
+		name    string
+		to      string
+		context int
+		headers []string
+	}{
+		{"one change", strings.Replace(lines, "10\n", "ten\n", 1), 3, []string{"@@ -7,7 +7,7 @@"}},
+		{"merged hunks", strings.Replace(strings.Replace(lines, "5\n", "five\n", 1), "10\n", "ten\n", 1), 3,
+			[]string{"@@ -2,12 +2,12 @@"}},
+		{"separate hunks", strings.Replace(strings.Replace(lines, "2\n", "two\n", 1), "19\n", "nineteen\n", 1), 3,
+			[]string{"@@ -1,5 +1,5 @@", "@@ -16,5 +16,5 @@"}},
+		{"insert at start", "0\n" + lines, 3, []string{"@@ -1,3 +1,4 @@"}},
+		{"insert at start without context", "0\n" + lines, 0, []string{"@@ -0,0 +1 @@"}},
+		{"remove a line without context", strings.Replace(lines, "4\n", "", 1), 0, []string{"@@ -4 +3,0 @@"}},
+		{"remove everything", "", 3, []string{"@@ -1,20 +0,0 @@"}},
+	}
+
+	for _, test := range tests {
+		var headers []string
+		for _, line := range strings.Split(filePatch("a/x", "b/x", lines, test.to, test.context, IgnoreNothing), "\n") {
+			if strings.HasPrefix(line, "@@") {
+				headers = append(headers, line)
+			}
+		}
+		if !reflect.DeepEqual(headers, test.headers) {
+			t.Errorf("%s: got hunks %q, want %q", test.name, headers, test.headers)
+		}
+	}
+}
+
+// applyPatch applies a patch to the files in a temporary directory with git
+// apply, and returns the files afterwards.  A file which is missing before or
+// after is left out of the map.  git apply only accepts hunks without context
+// lines when asked to with --unidiff-zero.
+func applyPatch(t *testing.T, patch string, unidiffZero bool, files map[string]string) map[string]string {
+	t.Helper()
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := []string{"apply", "--verbose"}
+	if unidiffZero {
+		args = append(args, "--unidiff-zero")
+	}
+	cmd := exec.Command(git, append(args, "-")...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply: %v: %s\n%s", err, out, patch)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]string)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result[entry.Name()] = string(content)
+	}
+	return result
+}
+
+func TestFilePatchRoundTrip(t *testing.T) {
+	lines := numberedLines(20)
+	tests := []struct {
+		name     string
+		from, to string
+		context  int
+	}{
+		{"one change", lines, strings.Replace(lines, "10\n", "ten\n", 1), 3},
+		{"merged hunks", lines, strings.Replace(strings.Replace(lines, "5\n", "five\n", 1), "10\n", "ten\n", 1), 3},
+		{"separate hunks", lines, strings.Replace(strings.Replace(lines, "2\n", "two\n", 1), "19\n", "nineteen\n", 1), 3},
+		{"no context", lines, strings.Replace(strings.Replace(lines, "2\n", "two\n", 1), "4\n", "", 1), 0},
+		{"insert and append", lines, "0\n" + lines + "21\n", 3},
+		{"no newline at end of new", "a\nb\n", "a\nb", 3},
+		{"no newline at end of old", "a\nb", "a\nb\n", 3},
+		{"no newline at end of either", "a\nb", "a\nc", 3},
+		{"no newline, unchanged last line", "a\nb\nc\nd\ne", "A\nb\nc\nd\ne", 1},
+		{"from empty", "", "a\nb\n", 3},
+		{"to empty", "a\nb\n", "", 3},
+		{"from empty without newline", "", "a", 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := filePatch("a/x", "b/x", test.from, test.to, test.context, IgnoreNothing)
+			got := applyPatch(t, patch, test.context == 0, map[string]string{"x": test.from})
+			if got["x"] != test.to {
+				t.Errorf("got %q after applying\n%s\nwant %q", got["x"], patch, test.to)
+			}
+		})
+	}
+}
+
+// multiFilePaste returns a paste with the given files, each given as
+// name=content.
+func multiFilePaste(id int64, files ...string) *Paste {
+	p := &Paste{Id: id}
+	for i, file := range files {
+		name, content, _ := strings.Cut(file, "=")
+		if i == 0 {
+			p.Filename = nullString(name)
+			p.Content = content
+		} else {
+			p.ExtraFiles = append(p.ExtraFiles, &PasteFile{Num: i + 1, Filename: name, Content: content})
+		}
+	}
+	return p
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	lines := numberedLines(20)
+	tests := []struct {
+		name     string
+		from, to []string
+	}{
+		{"changed files", []string{"main.go=" + lines, "go.mod=module a\n"},
+			[]string{"main.go=" + strings.Replace(lines, "10\n", "ten\n", 1), "go.mod=module b"}},
+		{"added file", []string{"main.go=" + lines}, []string{"main.go=" + lines, "go.mod=module a\n"}},
+		{"removed file", []string{"main.go=" + lines, "go.mod=module a\n"}, []string{"main.go=" + lines}},
+		{"added and removed files", []string{"main.go=a\n", "old.txt=gone\n"},
+			[]string{"main.go=b\n", "new.txt=here"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, to := multiFilePaste(1, test.from...), multiFilePaste(2, test.to...)
+			files := make(map[string]string)
+			for _, f := range from.Files() {
+				files[f.Filename] = f.Content
+			}
+			want := make(map[string]string)
+			for _, f := range to.Files() {
+				want[f.Filename] = f.Content
+			}
+
+			patch := Patch(from, to, 3, IgnoreNothing)
+			if got := applyPatch(t, patch, false, files); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q after applying\n%s\nwant %q", got, patch, want)
+			}
+		})
+	}
+}
+
+func TestDiffModes(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{"Content": {"x = 1\n"}})
+	postPaste(t, s, "1", url.Values{"Content": {"x = 2\n"}})
+
+	tests := []struct {
+		mode string
+		code int
+		want string
+	}{
+		{"", http.StatusOK, "x = 1"},
+		{DiffUnified, http.StatusOK, "x = 2"},
+		{DiffSplit, http.StatusOK, "<del>1</del>"},
+		{DiffWords, http.StatusOK, "<ins>2</ins>"},
+		{"sideways", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		path := "/diff/1/2"
+		if test.mode != "" {
+			path += "?mode=" + test.mode
+		}
+		w := request(s, "GET", path, nil)
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("GET %s: got status %d, want %d with %q", path, w.Code, test.code, test.want)
+		}
+	}
+}
+
+func TestDiffPatch(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{"Content": {"x = 1\ny = 2\n"}})
+	postPaste(t, s, "1", url.Values{"Content": {"x = 1\ny  =  3\n"}})
+
+	tests := []struct {
+		query string
+		code  int
+		want  string
+	}{
+		{"", http.StatusOK, "--- a/1\n+++ b/1\n@@ -1,2 +1,2 @@\n x = 1\n-y = 2\n+y  =  3\n"},
+		{"?context=0", http.StatusOK, "--- a/1\n+++ b/1\n@@ -2 +2 @@\n-y = 2\n+y  =  3\n"},
+		{"?context=-1", http.StatusBadRequest, ""},
+		{"?context=x", http.StatusBadRequest, ""},
+		{"?ignore=all-space&context=0", http.StatusOK, "--- a/1\n+++ b/1\n@@ -2 +2 @@\n-y = 2\n+y  =  3\n"},
+		{"?ignore=nonsense", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		path := "/diff/1/2.patch" + test.query
+		w := request(s, "GET", path, nil)
+		if w.Code != test.code {
+			t.Errorf("GET %s: got status %d, want %d", path, w.Code, test.code)
+		} else if test.code == http.StatusOK && w.Body.String() != test.want {
+			t.Errorf("GET %s: got\n%s\nwant\n%s", path, w.Body, test.want)
+		}
+	}
+}
+
+func TestMultiFileDiff(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{
+		"Filename":    {"main.go"},
+		"Content":     {"package main\n"},
+		"FileName":    {"go.mod", "old.txt"},
+		"FileContent": {"module a\n", "gone\n"},
+	})
+	postPaste(t, s, "1", url.Values{
+		"Filename":    {"main.go"},
+		"Content":     {"package main\n"},
+		"FileName":    {"new.txt", "go.mod"},
+		"FileContent": {"here\n", "module b\n"},
+	})
+
+	w := request(s, "GET", "/diff/1/1.patch", nil)
+	if w.Body.String() != "" {
+		t.Errorf("patch between a paste and itself: got %q", w.Body)
+	}
+
+	// the second paste is the annotation, whose ID is also 2
+	w = request(s, "GET", "/diff/1/2.patch", nil)
+	want := "--- a/go.mod\n+++ b/go.mod\n@@ -1 +1 @@\n-module a\n+module b\n" +
+		"--- a/old.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-gone\n" +
+		"--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+here\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("GET /diff/1/2.patch: got\n%s\nwant\n%s", got, want)
+	}
+
+	w = request(s, "GET", "/diff/1/2", nil)
+	for _, label := range []string{"go.mod", "old.txt (removed)", "new.txt (added)"} {
+		if !strings.Contains(w.Body.String(), `<h3 class="filename">`+label+`</h3>`) {
+			t.Errorf("GET /diff/1/2: no section for %s", label)
+		}
+	}
+}
+
+func TestSingleFileDiff(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{"Content": {"one\ntwo\n"}})
+	postPaste(t, s, "1", url.Values{"Filename": {"renamed.txt"}, "Content": {"one\n2\n"}})
+
+	// files with different names are still compared when each paste has one
+	w := request(s, "GET", "/diff/1/2.patch", nil)
+	want := "--- a/1\n+++ b/1\n@@ -1,2 +1,2 @@\n one\n-two\n+2\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("GET /diff/1/2.patch: got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,364 @@
+package gopaste
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// feedSize is how many of the latest pastes a feed holds.
+	feedSize = 50
+
+	// The content of each paste in a feed is cut short after feedExcerptLines
+	// lines or feedExcerptBytes bytes, whichever comes first.
+	feedExcerptLines = 20
+	feedExcerptBytes = 2000
+)
+
+// feedItem is a paste or annotation in a feed.
+type feedItem struct {
+	Title    string
+	Author   string
+	Language string
+	Url      string
+	Created  time.Time
+	Excerpt  string
+}
+
+// feed is the format-neutral content of a feed, which is written out as Atom
+// or RSS.
+type feed struct {
+	Title   string
+	Url     string // the page the feed follows
+	SelfUrl string // the feed itself
+	Updated time.Time
+	Items   []feedItem
+}
+
+// doFeed serves Atom and RSS 2.0 feeds of the latest pastes, filtered in the
+// same path syntax as /browse, and feeds of the annotations of a paste:
+//
+//	/feed/atom/channel/%23ops     pastes in #ops, as Atom
+//	/feed/rss/author/alice        pastes by alice, as RSS
+//	/feed/atom/annotations/{id}   annotations of a paste, as Atom
+//
+// Private and burn-after-reading pastes are never included.
+func (s *Server) doFeed(q *Query) error {
+	if len(q.Args) < 1 || (q.Args[0] != "atom" && q.Args[0] != "rss") {
+		return HttpError{"expected /feed/atom/... or /feed/rss/...", http.StatusNotFound}
+	}
+	format, args := q.Args[0], q.Args[1:]
+
+	var f *feed
+	var err error
+	if len(args) > 0 && args[0] == "annotations" {
+		if len(args) < 2 {
+			return HttpError{"invalid request", http.StatusBadRequest}
+		}
+		f, err = s.annotationFeed(args[1])
+	} else {
+		f, err = s.browseFeed(args)
+	}
+	if err != nil {
+		return err
+	}
+
+	f.SelfUrl = s.externalUrl(q.Request.URL.EscapedPath())
+	if format == "rss" {
+		return writeFeed(q.Response, "application/rss+xml", f.rss())
+	}
+	return writeFeed(q.Response, "application/atom+xml", f.atom())
+}
+
+// browseFeed makes the feed of the latest top-level pastes matching the
+// filters in a /browse path.
+func (s *Server) browseFeed(args []string) (*feed, error) {
+	opts := NewBrowseOpts()
+	if err := opts.Parse(args); err != nil {
+		return nil, HttpError{err.Error(), http.StatusBadRequest}
+	}
+	opts.Page = 1
+	opts.PageSize = feedSize
+
+	page, err := s.Store.TopLevelPastes(opts)
+	if err != nil {
+		return nil, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	f := &feed{
+		Title: "Gopaste: " + feedTitle(opts),
+		Url:   s.externalUrl("/browse/" + opts.String()),
+	}
+	for _, data := range page.Pastes {
+		f.add(s.feedItem(data.Paste))
+	}
+	return f, nil
+}
+
+// feedTitle describes the pastes matching a set of browse filters.
+func feedTitle(opts *BrowseOpts) string {
+	title := "Recent pastes"
+	if author, ok := opts.Search["author"]; ok {
+		title += " by " + author
+	}
+	if channel, ok := opts.Search["channel"]; ok {
+		title += " in " + channel
+	}
+	if language, ok := opts.Search["language"]; ok {
+		if name, ok := LanguageNames[language]; ok {
+			language = name
+		}
+		title += " in " + language
+	}
+	if query, ok := opts.Search["q"]; ok {
+		title += fmt.Sprintf(" matching \"%s\"", query)
+	}
+	return title
+}
+
+// annotationFeed makes the feed of the annotations of a paste.
+func (s *Server) annotationFeed(idStr string) (*feed, error) {
+	id, err := s.parsePasteId(idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err != nil {
+		return nil, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if paste != nil && paste.Annotates.Valid {
+		if paste, err = s.Store.GetPaste(paste.Annotates.Int64); err != nil {
+			return nil, HttpError{err.Error(), http.StatusInternalServerError}
+		}
+	}
+	// private pastes are treated as nonexistent, even when asked for by slug
+	if paste == nil || paste.Private {
+		return nil, HttpError{fmt.Sprintf("paste %s not found", idStr), http.StatusNotFound}
+	}
+
+	annotations, err := s.Store.GetAnnotations(paste.Id)
+	if err != nil {
+		return nil, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	f := &feed{
+		Title:   fmt.Sprintf("Gopaste: Annotations of paste #%s: %s", paste.Ref(), paste.TitleDef()),
+		Url:     s.externalUrl(viewPath(paste)),
+		Updated: paste.CreatedTime(),
+	}
+	// newest first, as in the other feeds
+	for i := len(annotations) - 1; i >= 0 && len(f.Items) < feedSize; i-- {
+		if a := annotations[i]; !a.Private && !a.Burn {
+			f.add(s.feedItem(a))
+		}
+	}
+	return f, nil
+}
+
+// feedItem makes the feed entry for a paste.
+func (s *Server) feedItem(p *Paste) feedItem {
+	title := p.TitleDef()
+	if p.Annotates.Valid {
+		title = fmt.Sprintf("Annotation %d: %s", p.AnnotationNum, title)
+	}
+	return feedItem{
+		Title:    title,
+		Author:   p.AuthorDef(),
+		Language: p.LanguageDef(),
+		Url:      s.externalUrl(viewPath(p)),
+		Created:  p.CreatedTime(),
+		Excerpt:  excerpt(p.Content, feedExcerptLines, feedExcerptBytes),
+	}
+}
+
+// add appends an item to the feed, keeping track of when it was last updated.
+func (f *feed) add(item feedItem) {
+	f.Items = append(f.Items, item)
+	if item.Created.After(f.Updated) {
+		f.Updated = item.Created
+	}
+}
+
+// excerpt returns the start of some text, cut short after the given number of
+// lines or bytes, with an ellipsis if anything was cut.
+func excerpt(text string, lines, size int) string {
+	cut := false
+	if parts := strings.SplitN(text, "\n", lines+1); len(parts) > lines {
+		text = strings.Join(parts[:lines], "\n")
+		cut = strings.TrimSpace(parts[lines]) != ""
+	}
+	if len(text) > size {
+		for size > 0 && !utf8.RuneStart(text[size]) {
+			size--
+		}
+		text = text[:size]
+		cut = true
+	}
+
+	text = strings.TrimRight(text, " \t\r\n")
+	if cut {
+		text += "\n..."
+	}
+	return text
+}
+
+// writeFeed writes a feed out as XML.
+func writeFeed(w http.ResponseWriter, contentType string, v interface{}) error {
+	buf := bytes.NewBufferString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return HttpError{fmt.Sprintf("error encoding feed: %v", err), http.StatusInternalServerError}
+	}
+	buf.WriteString("\n")
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	buf.WriteTo(w)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Id        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+	Author    atomAuthor   `xml:"author"`
+	Category  atomCategory `xml:"category"`
+	Link      atomLink     `xml:"link"`
+	Summary   string       `xml:"summary"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// atom returns the feed in Atom format.
+func (f *feed) atom() *atomFeed {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	a := &atomFeed{
+		Id:      f.SelfUrl,
+		Title:   f.Title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.SelfUrl},
+			{Rel: "alternate", Type: "text/html", Href: f.Url},
+		},
+	}
+	for _, item := range f.Items {
+		created := item.Created.UTC().Format(time.RFC3339)
+		a.Entries = append(a.Entries, atomEntry{
+			Id:        item.Url,
+			Title:     item.Title,
+			Published: created,
+			Updated:   created,
+			Author:    atomAuthor{item.Author},
+			Category:  atomCategory{item.Language},
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: item.Url},
+			Summary:   item.Excerpt,
+		})
+	}
+	return a
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DcNs    string     `xml:"xmlns:dc,attr"`
+	AtomNs  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          rssSelf   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssSelf struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Creator     string  `xml:"dc:creator"`
+	Category    string  `xml:"category"`
+	Description string  `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// rss returns the feed in RSS 2.0 format.  RSS's own author element must be an
+// email address, so authors are given with the Dublin Core creator element.
+func (f *feed) rss() *rssFeed {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	r := &rssFeed{
+		Version: "2.0",
+		DcNs:    "http://purl.org/dc/elements/1.1/",
+		AtomNs:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Url,
+			Description:   f.Title,
+			LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+			Self:          rssSelf{Rel: "self", Type: "application/rss+xml", Href: f.SelfUrl},
+		},
+	}
+	for _, item := range f.Items {
+		r.Channel.Items = append(r.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Url,
+			Guid:        rssGuid{true, item.Url},
+			PubDate:     item.Created.UTC().Format(time.RFC1123Z),
+			Creator:     item.Author,
+			Category:    item.Language,
+			Description: item.Excerpt,
+		})
+	}
+	return r
+}
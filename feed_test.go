@@ -0,0 +1,112 @@
+package gopaste
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		text  string
+		lines int
+		size  int
+		want  string
+	}{
+		{"short\n", 3, 100, "short"},
+		{"a\nb\nc\n", 3, 100, "a\nb\nc"},
+		{"a\nb\nc\nd\n", 3, 100, "a\nb\nc\n..."},
+		{"abcdef", 3, 4, "abcd\n..."},
+		{"ab\u00e9", 3, 3, "ab\n..."},
+		{"a  \n\n", 3, 100, "a"},
+	}
+
+	for _, test := range tests {
+		if got := excerpt(test.text, test.lines, test.size); got != test.want {
+			t.Errorf("excerpt(%q, %d, %d): got %q, want %q", test.text, test.lines, test.size, got, test.want)
+		}
+	}
+}
+
+func TestFeedTitle(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "Recent pastes"},
+		{[]string{"author", "alice"}, "Recent pastes by alice"},
+		{[]string{"channel", "%23ops", "language", "go"}, "Recent pastes in #ops in Go"},
+		{[]string{"q", "panic"}, `Recent pastes matching "panic"`},
+	}
+
+	for _, test := range tests {
+		opts := NewBrowseOpts()
+		if err := opts.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := feedTitle(opts); got != test.want {
+			t.Errorf("feedTitle(%q): got %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{"Title": {"first"}, "Author": {"alice"}, "Channel": {"#ops"}, "Language": {"go"}, "Content": {"package main\n"}})
+	postPaste(t, s, "", url.Values{"Title": {"second"}, "Author": {"bob"}, "Content": {"hello\n"}})
+	postPaste(t, s, "", url.Values{"Title": {"hidden"}, "Private": {"on"}, "Content": {"secret\n"}})
+	postPaste(t, s, "", url.Values{"Title": {"burnt"}, "Burn": {"on"}, "Content": {"once\n"}})
+	postPaste(t, s, "1", url.Values{"Title": {"reply"}, "Author": {"carol"}, "Content": {"package foo\n"}})
+
+	tests := []struct {
+		path    string
+		code    int
+		want    []string
+		notWant []string
+	}{
+		{"/feed/atom", http.StatusOK,
+			[]string{"<feed xmlns=\"http://www.w3.org/2005/Atom\">", "<title>first</title>", "<title>second</title>", "<name>alice</name>"},
+			[]string{"hidden", "burnt", "reply"}},
+		{"/feed/rss", http.StatusOK,
+			[]string{"<rss version=\"2.0\"", "<title>first</title>", "<dc:creator>bob</dc:creator>"},
+			[]string{"hidden", "burnt"}},
+		{"/feed/atom/author/alice", http.StatusOK,
+			[]string{"Recent pastes by alice", "<title>first</title>"},
+			[]string{"<title>second</title>"}},
+		{"/feed/rss/channel/%23ops", http.StatusOK,
+			[]string{"Recent pastes in #ops", "package main"},
+			[]string{"<title>second</title>"}},
+		{"/feed/atom/annotations/1", http.StatusOK,
+			[]string{"Annotations of paste #1: first", "Annotation 1: reply", "<name>carol</name>"},
+			[]string{"<title>first</title>"}},
+		{"/feed/rss/annotations/1", http.StatusOK, []string{"Annotation 1: reply"}, nil},
+		{"/feed/atom/annotations/99", http.StatusNotFound, nil, nil},
+		{"/feed/atom/annotations", http.StatusBadRequest, nil, nil},
+		{"/feed/json", http.StatusNotFound, nil, nil},
+		{"/feed", http.StatusNotFound, nil, nil},
+	}
+
+	for _, test := range tests {
+		w := request(s, "GET", test.path, nil)
+		if w.Code != test.code {
+			t.Errorf("GET %s: got status %d, want %d", test.path, w.Code, test.code)
+			continue
+		}
+		body := w.Body.String()
+		for _, want := range test.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s: does not contain %q:\n%s", test.path, want, body)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(body, notWant) {
+				t.Errorf("GET %s: contains %q", test.path, notWant)
+			}
+		}
+	}
+
+	if w := request(s, "GET", "/feed/rss", nil); w.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" {
+		t.Errorf("GET /feed/rss: got Content-Type %q", w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,139 @@
+package gopaste
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxFilenameLength is the longest file name allowed in a paste.
+const maxFilenameLength = 100
+
+// PasteFile is one named file within a paste.  The first file of a paste is
+// stored in the paste itself; any more are stored separately, numbered from 2.
+type PasteFile struct {
+	PasteId         int64          `sql:"paste_id"`
+	Num             int            `sql:"num"`
+	Filename        string         `sql:"filename"`
+	Language        sql.NullString `sql:"language"`
+	LanguageGuessed bool           `sql:"language_guessed"`
+	Content         string         `sql:"content"`
+}
+
+// newPasteFiles creates the additional files of a new paste from a submitted
+// web form, in which each file is given by a FileName, FileLanguage and
+// FileContent field.  Files without content are skipped.
+func newPasteFiles(v url.Values) (files []*PasteFile) {
+	names, languages := v["FileName"], v["FileLanguage"]
+	for i, content := range v["FileContent"] {
+		if content == "" {
+			continue
+		}
+
+		f := &PasteFile{Num: len(files) + 2, Content: content}
+		if i < len(names) {
+			f.Filename = strings.TrimSpace(names[i])
+		}
+
+		language := ""
+		if i < len(languages) {
+			language = languages[i]
+		}
+		f.Language, f.LanguageGuessed = chooseLanguage(language, f.Filename, content)
+
+		files = append(files, f)
+	}
+	return files
+}
+
+// chooseLanguage returns the language for a file given the language picked for
+// it in a web form, detecting it if none was picked.
+func chooseLanguage(picked, name, content string) (language sql.NullString, guessed bool) {
+	switch picked {
+	case "":
+		if code := DetectLanguage(name, content); code != "" {
+			return sql.NullString{String: code, Valid: true}, true
+		}
+	case NoLanguage:
+	default:
+		return sql.NullString{String: picked, Valid: true}, false
+	}
+	return sql.NullString{}, false
+}
+
+// Files returns every file in the paste, starting with the one stored in the
+// paste itself.  A paste with a single unnamed file is an ordinary paste.
+func (p Paste) Files() []*PasteFile {
+	first := &PasteFile{
+		PasteId:         p.Id,
+		Num:             1,
+		Filename:        p.Filename.String,
+		Language:        p.Language,
+		LanguageGuessed: p.LanguageGuessed,
+		Content:         p.Content,
+	}
+	return append([]*PasteFile{first}, p.ExtraFiles...)
+}
+
+// File returns the file with the given name, or nil if the paste has none.
+func (p Paste) File(name string) *PasteFile {
+	for _, f := range p.Files() {
+		if f.Filename != "" && f.Filename == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// MultiFile reports whether the paste has more than one file.
+func (p Paste) MultiFile() bool {
+	return len(p.ExtraFiles) > 0
+}
+
+var filenamePattern = regexp.MustCompile(`^[\w.+-]+$`)
+
+// checkFiles returns an error if the files of a new paste are not properly
+// named: every file of a paste with several must have a name, and names must
+// be unique and safe to use in URLs and archives.
+func (p Paste) checkFiles() error {
+	seen := make(map[string]bool)
+	for _, f := range p.Files() {
+		name := f.Filename
+		switch {
+		case name == "":
+			if p.MultiFile() {
+				return fmt.Errorf("every file of a paste with several files needs a name")
+			}
+			continue
+		case len(name) > maxFilenameLength:
+			return fmt.Errorf("file name '%s' is longer than %d characters", trunc(name, 20), maxFilenameLength)
+		case !filenamePattern.MatchString(name) || name == "." || name == "..":
+			return fmt.Errorf("invalid file name '%s'; names may contain letters, numbers and . _ + -", name)
+		case annotationPrefix.MatchString(name):
+			// it would be mistaken for an annotation number in line anchors
+			return fmt.Errorf("invalid file name '%s'; names may not start with a number and a dot", name)
+		case seen[name]:
+			return fmt.Errorf("duplicate file name '%s'", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// LineNumbers returns a list of LineNumber objects for a file of a paste.
+func (f PasteFile) LineNumbers(p Paste) (ns []LineNumber) {
+	for i := 0; i <= strings.Count(strings.TrimSuffix(f.Content, "\n"), "\n"); i++ {
+		n := i + 1
+		s := fmt.Sprint(n)
+		if f.Filename != "" {
+			s = fmt.Sprintf("%s:%d", f.Filename, n)
+		}
+		if p.AnnotationNum > 0 {
+			s = fmt.Sprintf("%d.%s", p.AnnotationNum, s)
+		}
+		ns = append(ns, LineNumber{Num: n, File: f.Num, Anchor: s, RootRef: p.RootRef()})
+	}
+	return ns
+}
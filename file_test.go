@@ -0,0 +1,141 @@
+package gopaste
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNewPasteFiles(t *testing.T) {
+	paste := NewPaste(url.Values{
+		"Filename":     {"main.go"},
+		"Content":      {"package main\n"},
+		"FileName":     {" go.mod ", "skipped", "notes"},
+		"FileLanguage": {"", "", NoLanguage},
+		"FileContent":  {"module example\n", "", "# todo\n"},
+	})
+
+	if paste.Filename.String != "main.go" || paste.Language.String != "go" || !paste.LanguageGuessed {
+		t.Errorf("first file: got name %v, language %v", paste.Filename, paste.Language)
+	}
+
+	var got []string
+	for _, f := range paste.Files() {
+		got = append(got, f.Filename)
+	}
+	if strings.Join(got, " ") != "main.go go.mod notes" {
+		t.Errorf("got files %v, want main.go go.mod notes", got)
+	}
+	if notes := paste.File("notes"); notes == nil || notes.Num != 3 || notes.Language.Valid {
+		t.Errorf("File(\"notes\"): got %+v", notes)
+	}
+	if paste.File("skipped") != nil || paste.File("") != nil {
+		t.Errorf("File found a file which should not exist")
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	tests := []struct {
+		first string
+		extra []string
+		ok    bool
+	}{
+		{"", nil, true},
+		{"main.go", nil, true},
+		{"main.go", []string{"go.mod", "README", "a_b-c+d.txt"}, true},
+		{"", []string{"go.mod"}, false},
+		{"main.go", []string{""}, false},
+		{"main.go", []string{"main.go"}, false},
+		{"main.go", []string{"dir/file"}, false},
+		{"main.go", []string{"a b"}, false},
+		{"..", nil, false},
+		{".", nil, false},
+		{"2.main.go", nil, false},
+		{"v2.go", nil, true},
+		{strings.Repeat("x", maxFilenameLength), nil, true},
+		{strings.Repeat("x", maxFilenameLength+1), nil, false},
+	}
+
+	for _, test := range tests {
+		paste := &Paste{Content: "x"}
+		if test.first != "" {
+			paste.Filename = nullString(test.first)
+		}
+		for i, name := range test.extra {
+			paste.ExtraFiles = append(paste.ExtraFiles, &PasteFile{Num: i + 2, Filename: name, Content: "y"})
+		}
+
+		if err := paste.checkFiles(); (err == nil) != test.ok {
+			t.Errorf("checkFiles(%q, %q): got %v, want ok %v", test.first, test.extra, err, test.ok)
+		}
+	}
+}
+
+func TestMultiFilePaste(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{
+		"Title":       {"example"},
+		"Filename":    {"main.go"},
+		"Content":     {"package main\n"},
+		"FileName":    {"go.mod"},
+		"FileContent": {"module example\n"},
+	})
+
+	if w := request(s, "POST", "/new", url.Values{"Content": {"x"}, "FileContent": {"y"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /new with an unnamed second file: got status %d", w.Code)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		form   url.Values
+		code   int
+		want   string
+	}{
+		{"GET", "/view/1", nil, http.StatusOK, `id="go.mod:1"`},
+		{"GET", "/raw/1", nil, http.StatusOK, "package main\n"},
+		{"GET", "/raw/1/main.go", nil, http.StatusOK, "package main\n"},
+		{"GET", "/raw/1/go.mod", nil, http.StatusOK, "module example\n"},
+		{"GET", "/raw/1/missing.txt", nil, http.StatusNotFound, ""},
+		{"POST", "/comment/1/go.mod:1", url.Values{"Content": {"which version?"}}, http.StatusSeeOther, ""},
+		{"POST", "/comment/1/go.mod:2", url.Values{"Content": {"x"}}, http.StatusNotFound, ""},
+		{"POST", "/comment/1/missing.txt:1", url.Values{"Content": {"x"}}, http.StatusNotFound, ""},
+		{"GET", "/view/1", nil, http.StatusOK, "which version?"},
+	}
+
+	for _, test := range tests {
+		w := request(s, test.method, test.path, test.form)
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s %s: got status %d, want %d with %q", test.method, test.path, w.Code, test.code, test.want)
+		}
+	}
+
+	w := request(s, "GET", "/raw/1.zip", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("GET /raw/1.zip: got status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+	if len(files) != 2 || files["main.go"] != "package main\n" || files["go.mod"] != "module example\n" {
+		t.Errorf("GET /raw/1.zip: got files %q", files)
+	}
+}
@@ -1,27 +1,60 @@
 package gopaste
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 )
 
+// ReapInterval is how often expired pastes are deleted from the store.
+const ReapInterval = time.Minute
+
 type Server struct {
-	Config   *Config
-	Database *sql.DB
+	Config *Config
+	Store  PasteStore
+	Notify *Notifications
+	Hooks  []*Webhook
+
+	// highlight is whether pastes are highlighted on the server rather than
+	// by highlight.js in the browser.
+	highlight bool
+
+	// deliveryWake holds a channel for each notifier which wakes the worker
+	// delivering its queued notifications.
+	deliveryWake map[string]chan struct{}
 }
 
-// New creates a new Gopaste server object and opens its database connection.
-func New(config *Config) (*Server, error) {
-	server := &Server{Config: config}
+// New creates a new Gopaste server object which keeps its pastes in the given
+// store.
+func New(config *Config, store PasteStore) *Server {
+	notify, err := NewNotifications(config)
+	if err != nil {
+		log.Printf("[notify] notifications disabled: %v", err)
+	}
 
-	err := server.initDb()
+	hooks, err := NewWebhooks(config)
 	if err != nil {
-		return nil, err
+		log.Printf("[webhook] webhooks disabled: %v", err)
 	}
 
-	return server, nil
+	s := &Server{
+		Config:       config,
+		Store:        store,
+		Notify:       notify,
+		Hooks:        hooks,
+		highlight:    config.Highlight != HighlightClient,
+		deliveryWake: make(map[string]chan struct{}),
+	}
+	if notify != nil {
+		for _, r := range notify.routes {
+			s.deliveryWake[r.name] = make(chan struct{}, 1)
+		}
+	}
+	for _, h := range hooks {
+		s.deliveryWake[h.name] = make(chan struct{}, 1)
+	}
+	return s
 }
 
 // ListenAndServe starts the server listening for incoming requests on the
@@ -37,6 +70,9 @@ func (s *Server) ListenAndServe() error {
 		Handler: mux,
 	}
 
+	go s.reapExpiredPastes(ReapInterval)
+	go s.deliverNotifications(DeliveryInterval)
+
 	log.Printf("[server] listening on %s", addr)
 	err := httpServer.ListenAndServe()
 	if err != nil {
@@ -46,3 +82,22 @@ func (s *Server) ListenAndServe() error {
 	log.Print("[server] exiting")
 	return nil
 }
+
+// reapExpiredPastes periodically deletes expired pastes from the store.
+func (s *Server) reapExpiredPastes(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := s.Store.DeleteExpiredPastes(time.Now().Unix())
+		if err != nil {
+			log.Printf("[reaper] error deleting expired pastes: %v", err)
+		} else if count > 0 {
+			log.Printf("[reaper] deleted %d expired pastes", count)
+		}
+
+		s.pruneDeliveryLog()
+
+		<-ticker.C
+	}
+}
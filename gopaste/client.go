@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Client talks to a gopaste server.
+type Client struct {
+	Url string
+}
+
+// PasteOpts holds the metadata for a new paste.
+type PasteOpts struct {
+	Title    string
+	Author   string
+	Language string
+	Filename string
+	Channel  string
+	Expires  string
+	Private  bool
+	Burn     bool
+	Annotate string
+}
+
+// query returns the options as query parameters for the upload endpoint.
+func (o *PasteOpts) query() url.Values {
+	v := url.Values{}
+	for name, value := range map[string]string{
+		"title":    o.Title,
+		"author":   o.Author,
+		"language": o.Language,
+		"filename": o.Filename,
+		"channel":  o.Channel,
+		"expires":  o.Expires,
+		"annotate": o.Annotate,
+	} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	if o.Private {
+		v.Set("private", "yes")
+	}
+	if o.Burn {
+		v.Set("burn", "yes")
+	}
+	return v
+}
+
+// ListOpts holds the filters for listing pastes, as for /browse.
+type ListOpts struct {
+	Author   string
+	Channel  string
+	Language string
+	Query    string
+	Page     int
+	PageSize int
+}
+
+// get fetches a path from the server and copies the response body to w.
+func (c *Client) get(w io.Writer, path string) error {
+	resp, err := http.Get(c.Url + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// responseError is a failed request, with the server's error message.
+type responseError struct {
+	Status int
+	Msg    string
+}
+
+func (e responseError) Error() string {
+	return e.Msg
+}
+
+// checkResponse returns the server's error message if a request failed.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return responseError{resp.StatusCode, msg}
+	}
+	return responseError{resp.StatusCode, "server returned " + resp.Status}
+}
+
+// notFound reports whether a request failed with 404 Not Found.
+func notFound(err error) bool {
+	e, ok := err.(responseError)
+	return ok && e.Status == http.StatusNotFound
+}
+
+// pasteFile is a file to be pasted.  Name is empty for standard input.
+type pasteFile struct {
+	Name    string
+	Content []byte
+}
+
+// readFiles reads the files to be pasted, or standard input if there are none.
+func readFiles(names []string) ([]pasteFile, error) {
+	if len(names) == 0 {
+		content, err := io.ReadAll(os.Stdin)
+		return []pasteFile{{Content: content}}, err
+	}
+
+	var files []pasteFile
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pasteFile{filepath.Base(name), content})
+	}
+	return files, nil
+}
+
+// Paste uploads files as a new paste, or standard input if there are no files,
+// and writes the URL of the new paste to w.
+//
+// Pastes are sent to /upload, which takes the files as they are and answers
+// with the URL.  Servers which predate it answer 404, and are sent the web
+// form from /new (or /annotate/{id}) instead.
+func (c *Client) Paste(w io.Writer, opts *PasteOpts, names []string) error {
+	files, err := readFiles(names)
+	if err != nil {
+		return err
+	}
+
+	err = c.upload(w, opts, files)
+	if notFound(err) {
+		return c.postForm(w, opts, files)
+	}
+	return err
+}
+
+// upload sends a paste to /upload.
+func (c *Client) upload(w io.Writer, opts *PasteOpts, files []pasteFile) error {
+	var body bytes.Buffer
+	contentType := "text/plain; charset=utf-8"
+
+	if files[0].Name == "" {
+		body.Write(files[0].Content)
+	} else {
+		form := multipart.NewWriter(&body)
+		for _, f := range files {
+			part, err := form.CreateFormFile("file", f.Name)
+			if err != nil {
+				return err
+			}
+			if _, err = part.Write(f.Content); err != nil {
+				return err
+			}
+		}
+		if err := form.Close(); err != nil {
+			return err
+		}
+		contentType = form.FormDataContentType()
+	}
+
+	resp, err := http.Post(c.Url+"/upload?"+opts.query().Encode(), contentType, &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// viewLink finds the link to a new paste on the page shown for
+// burn-after-reading pastes.
+var viewLink = regexp.MustCompile(`https?://[^"<\s]+/view/[A-Za-z0-9]+`)
+
+// postForm sends a paste through the web form, which redirects to the new
+// paste.
+func (c *Client) postForm(w io.Writer, opts *PasteOpts, files []pasteFile) error {
+	v := url.Values{
+		"Title":    {opts.Title},
+		"Author":   {opts.Author},
+		"Language": {opts.Language},
+		"Channel":  {opts.Channel},
+		"Expires":  {opts.Expires},
+		"Filename": {files[0].Name},
+		"Content":  {string(files[0].Content)},
+	}
+	if opts.Filename != "" {
+		v.Set("Filename", opts.Filename)
+	}
+	for _, f := range files[1:] {
+		v.Add("FileName", f.Name)
+		v.Add("FileContent", string(f.Content))
+	}
+	if opts.Private {
+		v.Set("Private", "on")
+	}
+	if opts.Burn {
+		v.Set("Burn", "on")
+	}
+
+	path := "/new"
+	if opts.Annotate != "" {
+		path = "/annotate/" + url.PathEscape(opts.Annotate)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.PostForm(c.Url+path, v)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusSeeOther {
+		if err := checkResponse(resp); err != nil {
+			return err
+		}
+
+		// burn-after-reading pastes aren't redirected to, since that would
+		// burn them, but linked to instead
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		location = viewLink.FindString(string(body))
+	}
+	if location == "" {
+		return fmt.Errorf("no paste URL in the response from %s", path)
+	}
+
+	base, err := url.Parse(c.Url + path)
+	if err != nil {
+		return err
+	}
+	pasteUrl, err := base.Parse(location)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, pasteUrl)
+	return err
+}
+
+// Raw writes the raw content of a paste, or of one of its files, to w.
+func (c *Client) Raw(w io.Writer, id string, filename ...string) error {
+	path := "/raw/" + url.PathEscape(id)
+	if len(filename) > 0 {
+		path += "/" + url.PathEscape(filename[0])
+	}
+	return c.get(w, path)
+}
+
+// Diff writes a unified diff between two pastes to w.
+func (c *Client) Diff(w io.Writer, from, to string, context int, ignore string) error {
+	v := url.Values{"context": {strconv.Itoa(context)}}
+	if ignore != "" {
+		v.Set("ignore", ignore)
+	}
+	return c.get(w, fmt.Sprintf("/diff/%s/%s.patch?%s", url.PathEscape(from), url.PathEscape(to), v.Encode()))
+}
+
+// listedPaste is the part of the JSON API's representation of a paste which
+// is listed.
+type listedPaste struct {
+	Ref             string    `json:"ref"`
+	Title           string    `json:"title"`
+	Author          string    `json:"author"`
+	Language        string    `json:"language"`
+	Channel         string    `json:"channel"`
+	Created         time.Time `json:"created"`
+	Url             string    `json:"url"`
+	AnnotationCount int       `json:"annotation_count"`
+}
+
+// List writes a table of recent public pastes matching the filters to w.
+func (c *Client) List(w io.Writer, opts *ListOpts) error {
+	v := url.Values{
+		"page":      {strconv.Itoa(opts.Page)},
+		"page_size": {strconv.Itoa(opts.PageSize)},
+	}
+	for name, value := range map[string]string{
+		"author":   opts.Author,
+		"channel":  opts.Channel,
+		"language": opts.Language,
+		"q":        opts.Query,
+	} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+
+	// the web pages for browsing are HTML, so listing needs the JSON API
+	var body bytes.Buffer
+	if err := c.get(&body, "/api/v1/pastes?"+v.Encode()); notFound(err) {
+		return fmt.Errorf("listing pastes needs the JSON API at /api/v1, which this server does not have")
+	} else if err != nil {
+		return err
+	}
+
+	var list struct {
+		Total  int            `json:"total"`
+		Pastes []*listedPaste `json:"pastes"`
+	}
+	if err := json.Unmarshal(body.Bytes(), &list); err != nil {
+		return fmt.Errorf("invalid response from server: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tAUTHOR\tLANGUAGE\tCHANNEL\tANNOTATIONS\tCREATED")
+	for _, p := range list.Pastes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", p.Ref, orDash(p.Title), orDash(p.Author),
+			orDash(p.Language), orDash(p.Channel), p.AnnotationCount, p.Created.Local().Format("2006-01-02 15:04"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if shown := (opts.Page-1)*opts.PageSize + len(list.Pastes); shown < list.Total {
+		fmt.Fprintf(w, "(%d of %d pastes; use --page=%d for more)\n", shown, list.Total, opts.Page+1)
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
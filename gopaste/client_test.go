@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// request is a request received by a fake server.
+type request struct {
+	method      string
+	uri         string
+	contentType string
+	body        string
+}
+
+// fakeServer starts a server which records every request it receives and
+// answers each with the given status and body.
+func fakeServer(t *testing.T, status int, body string) (*Client, *[]request) {
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"), string(content)})
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return &Client{Url: server.URL}, &requests
+}
+
+func TestPasteOptsQuery(t *testing.T) {
+	tests := []struct {
+		opts PasteOpts
+		want string
+	}{
+		{PasteOpts{}, ""},
+		{PasteOpts{Title: "build log", Author: "alice"}, "author=alice&title=build+log"},
+		{PasteOpts{Language: "go", Filename: "main.go", Channel: "#ops"}, "channel=%23ops&filename=main.go&language=go"},
+		{PasteOpts{Expires: "1h", Annotate: "12"}, "annotate=12&expires=1h"},
+		{PasteOpts{Private: true, Burn: true}, "burn=yes&private=yes"},
+	}
+
+	for _, test := range tests {
+		if got := test.opts.query().Encode(); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestPasteFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"main.go": "package main\n", "go.mod": "module example\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, requests := fakeServer(t, http.StatusCreated, "http://paste.example.com/view/1\n")
+	var out bytes.Buffer
+	files := []string{filepath.Join(dir, "main.go"), filepath.Join(dir, "go.mod")}
+	if err := client.Paste(&out, &PasteOpts{Title: "example"}, files); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "http://paste.example.com/view/1\n" {
+		t.Errorf("got output %q", out.String())
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.method != "POST" || req.uri != "/upload?title=example" || !strings.HasPrefix(req.contentType, "multipart/form-data") {
+		t.Errorf("got %s %s (%s)", req.method, req.uri, req.contentType)
+	}
+	for _, want := range []string{`filename="main.go"`, "package main\n", `filename="go.mod"`, "module example\n"} {
+		if !strings.Contains(req.body, want) {
+			t.Errorf("upload does not contain %q:\n%s", want, req.body)
+		}
+	}
+
+	if err := client.Paste(&out, &PasteOpts{}, []string{filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("pasting a missing file: got no error")
+	}
+}
+
+func TestClientRequests(t *testing.T) {
+	client, requests := fakeServer(t, http.StatusOK, "content")
+	tests := []struct {
+		call func(w io.Writer) error
+		uri  string
+	}{
+		{func(w io.Writer) error { return client.Raw(w, "12") }, "/raw/12"},
+		{func(w io.Writer) error { return client.Raw(w, "12", "main.go") }, "/raw/12/main.go"},
+		{func(w io.Writer) error { return client.Raw(w, "12", "a b") }, "/raw/12/a%20b"},
+		{func(w io.Writer) error { return client.Diff(w, "12", "13", 3, "") }, "/diff/12/13.patch?context=3"},
+		{func(w io.Writer) error { return client.Diff(w, "12@1", "12", 0, "all-space") }, "/diff/12@1/12.patch?context=0&ignore=all-space"},
+	}
+
+	for _, test := range tests {
+		*requests = nil
+		var out bytes.Buffer
+		if err := test.call(&out); err != nil {
+			t.Errorf("%s: %v", test.uri, err)
+			continue
+		}
+		if len(*requests) != 1 || (*requests)[0].method != "GET" || (*requests)[0].uri != test.uri {
+			t.Errorf("got requests %+v, want GET %s", *requests, test.uri)
+		}
+		if out.String() != "content" {
+			t.Errorf("%s: got output %q", test.uri, out.String())
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusNotFound, "paste 12 not found\n", "paste 12 not found"},
+		{http.StatusInternalServerError, "", "server returned 500 Internal Server Error"},
+	}
+
+	for _, test := range tests {
+		client, _ := fakeServer(t, test.status, test.body)
+		if err := client.Raw(io.Discard, "12"); err == nil || err.Error() != test.want {
+			t.Errorf("status %d: got error %v, want %q", test.status, err, test.want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	response := `{"total": 3, "pastes": [
+		{"ref": "3", "title": "build log", "author": "alice", "language": "go", "channel": "#ops",
+		 "created": "2014-01-02T03:04:05Z", "annotation_count": 2},
+		{"ref": "2", "created": "2014-01-02T03:04:05Z"}
+	]}`
+	client, requests := fakeServer(t, http.StatusOK, response)
+
+	var out bytes.Buffer
+	opts := &ListOpts{Author: "alice", Query: "error log", Page: 1, PageSize: 2}
+	if err := client.List(&out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if uri := (*requests)[0].uri; uri != "/api/v1/pastes?author=alice&page=1&page_size=2&q=error+log" {
+		t.Errorf("got request for %s", uri)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got output:\n%s", out.String())
+	}
+	for i, want := range [][]string{
+		{"ID", "TITLE", "AUTHOR", "LANGUAGE", "CHANNEL", "ANNOTATIONS", "CREATED"},
+		{"3", "build", "log", "alice", "go", "#ops", "2"},
+		{"2", "-", "-", "-", "-", "0"},
+	} {
+		fields := strings.Fields(lines[i])
+		if len(fields) < len(want) || strings.Join(fields[:len(want)], " ") != strings.Join(want, " ") {
+			t.Errorf("line %d: got %q, want it to start with %q", i+1, lines[i], want)
+		}
+	}
+	if lines[3] != "(2 of 3 pastes; use --page=2 for more)" {
+		t.Errorf("got last line %q", lines[3])
+	}
+
+	bad, _ := fakeServer(t, http.StatusOK, "<html>")
+	if err := bad.List(io.Discard, opts); err == nil {
+		t.Errorf("List with an invalid response: got no error")
+	}
+}
+
+func TestPasteFallback(t *testing.T) {
+	var form url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		switch {
+		case r.URL.Path == "/annotate/3":
+			http.Redirect(w, r, "/view/3#a1", http.StatusSeeOther)
+		case r.URL.Path != "/new":
+			http.NotFound(w, r)
+		case form.Get("Burn") == "on":
+			io.WriteString(w, `<p>View: <a href="http://paste.example.com/view/AbC">http://paste.example.com/view/AbC</a></p>`)
+		default:
+			http.Redirect(w, r, "/view/7", http.StatusSeeOther)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := &Client{Url: server.URL}
+
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts PasteOpts
+		want string
+	}{
+		{PasteOpts{Title: "example"}, server.URL + "/view/7\n"},
+		{PasteOpts{Burn: true}, "http://paste.example.com/view/AbC\n"},
+		{PasteOpts{Annotate: "3"}, server.URL + "/view/3#a1\n"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := client.Paste(&out, &test.opts, []string{path}); err != nil {
+			t.Errorf("%+v: %v", test.opts, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("%+v: got output %q, want %q", test.opts, out.String(), test.want)
+		}
+		if form.Get("Filename") != "main.go" || form.Get("Content") != "package main\n" || form.Get("Title") != test.opts.Title {
+			t.Errorf("%+v: got form %v", test.opts, form)
+		}
+	}
+
+	// listing has no fallback
+	if err := client.List(io.Discard, &ListOpts{}); err == nil || !strings.Contains(err.Error(), "JSON API") {
+		t.Errorf("List without the API: got error %v", err)
+	}
+}
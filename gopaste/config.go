@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds the client settings which can be given in the config file or
+// the environment.
+type Config struct {
+	Url     string
+	Author  string
+	Channel string
+}
+
+// configPath returns the location of the config file: $GOPASTE_CONFIG if set,
+// or gopaste/config in the user's config directory (e.g. ~/.config).
+func configPath() string {
+	if path := os.Getenv("GOPASTE_CONFIG"); path != "" {
+		return path
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gopaste", "config")
+}
+
+// LoadConfig reads the config file, if there is one, and then the GOPASTE_URL,
+// GOPASTE_AUTHOR and GOPASTE_CHANNEL environment variables, which override
+// it.
+func LoadConfig() (*Config, error) {
+	config := &Config{}
+
+	if path := configPath(); path != "" {
+		if err := config.readFile(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	for name, field := range map[string]*string{
+		"GOPASTE_URL":     &config.Url,
+		"GOPASTE_AUTHOR":  &config.Author,
+		"GOPASTE_CHANNEL": &config.Channel,
+	} {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	return config, nil
+}
+
+// readFile reads settings from a config file of "key = value" lines, where
+// the keys are url, author and channel.  Blank lines and lines starting with
+// "#" are ignored.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq == -1 {
+			return fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+
+		key, value := strings.TrimSpace(line[:eq]), strings.TrimSpace(line[eq+1:])
+		switch key {
+		case "url":
+			c.Url = value
+		case "author":
+			c.Author = value
+		case "channel":
+			c.Channel = value
+		default:
+			return fmt.Errorf("%s:%d: unknown setting '%s'", path, n, key)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		file string
+		want Config
+		ok   bool
+	}{
+		{"", Config{}, true},
+		{"url = http://paste.example.com\nauthor=alice\n", Config{Url: "http://paste.example.com", Author: "alice"}, true},
+		{"# settings\n\n  channel = #ops  \n", Config{Channel: "#ops"}, true},
+		{"url = http://a/?x=y\n", Config{Url: "http://a/?x=y"}, true},
+		{"url\n", Config{}, false},
+		{"colour = blue\n", Config{}, false},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "config")
+		if err := os.WriteFile(path, []byte(test.file), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var got Config
+		err := got.readFile(path)
+		if (err == nil) != test.ok || (test.ok && got != test.want) {
+			t.Errorf("readFile(%q): got %+v, %v; want %+v", test.file, got, err, test.want)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("url = http://file.example.com\nauthor = alice\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOPASTE_CONFIG", path)
+	t.Setenv("GOPASTE_URL", "http://env.example.com")
+	t.Setenv("GOPASTE_AUTHOR", "")
+	t.Setenv("GOPASTE_CHANNEL", "#ops")
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the environment overrides the file, except where it is empty
+	want := Config{Url: "http://env.example.com", Author: "alice", Channel: "#ops"}
+	if *config != want {
+		t.Errorf("got %+v, want %+v", *config, want)
+	}
+
+	// a missing config file is not an error
+	t.Setenv("GOPASTE_CONFIG", filepath.Join(t.TempDir(), "missing"))
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("LoadConfig with no config file: %v", err)
+	}
+}
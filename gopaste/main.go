@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const usage = `usage: gopaste [options] [paste] [file...]
+       gopaste [options] annotate <id> [file...]
+       gopaste [options] raw <id> [filename]
+       gopaste [options] diff [--context=n] [--ignore=option] <id> <id>
+       gopaste [options] list [--author=a] [--channel=c] [--language=l] [-q query] [--page=n]
+
+  paste      paste files, or standard input if there are none (the default)
+  annotate   annotate an existing paste with files or standard input
+  raw        print the raw content of a paste, or of one of its files
+  diff       print a unified diff between two pastes, e.g. 12 and 12@1
+  list       list recent public pastes
+
+The server URL and the default author and channel are read from the config file
+(gopaste/config in the user config directory, or $GOPASTE_CONFIG) and the
+GOPASTE_URL, GOPASTE_AUTHOR and GOPASTE_CHANNEL environment variables.
+
+options:
+`
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("gopaste: ")
+
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	opts := &PasteOpts{}
+	flag.StringVar(&config.Url, "url", config.Url, "Gopaste server URL")
+	flag.StringVar(&opts.Author, "author", config.Author, "Author of new pastes")
+	flag.StringVar(&opts.Channel, "channel", config.Channel, "Channel to announce new pastes in")
+	flag.StringVar(&opts.Title, "title", "", "Title of the new paste")
+	flag.StringVar(&opts.Language, "language", "", "Language of the new paste, detected if not given")
+	flag.StringVar(&opts.Filename, "filename", "", "File name for a paste read from standard input")
+	flag.StringVar(&opts.Expires, "expires", "", "Lifetime of the new paste: 10m, 1h, 1d, 1w or 1M")
+	flag.BoolVar(&opts.Private, "private", false, "Make the new paste private")
+	flag.BoolVar(&opts.Burn, "burn", false, "Delete the new paste after it is first read")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if config.Url == "" {
+		log.Fatal("no server URL; set GOPASTE_URL or url in the config file, or pass --url")
+	}
+	client := &Client{Url: strings.TrimSuffix(config.Url, "/")}
+
+	args := flag.Args()
+	command := "paste"
+	if len(args) > 0 && commands[args[0]] != nil {
+		command, args = args[0], args[1:]
+	}
+
+	if err := commands[command](client, opts, args); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+type commandFunc func(*Client, *PasteOpts, []string) error
+
+var commands = map[string]commandFunc{
+	"annotate": annotateCommand,
+	"diff":     diffCommand,
+	"list":     listCommand,
+	"paste":    pasteCommand,
+	"raw":      rawCommand,
+}
+
+func pasteCommand(c *Client, opts *PasteOpts, args []string) error {
+	return c.Paste(os.Stdout, opts, args)
+}
+
+func annotateCommand(c *Client, opts *PasteOpts, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: gopaste annotate <id> [file...]")
+	}
+	opts.Annotate = args[0]
+	return c.Paste(os.Stdout, opts, args[1:])
+}
+
+func rawCommand(c *Client, opts *PasteOpts, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: gopaste raw <id> [filename]")
+	}
+	return c.Raw(os.Stdout, args[0], args[1:]...)
+}
+
+func diffCommand(c *Client, opts *PasteOpts, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	context := flags.Int("context", 3, "Lines of context around each change")
+	ignore := flags.String("ignore", "", "Differences to ignore: space-change, all-space, blank-lines or case")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: gopaste diff [--context=n] [--ignore=option] <id> <id>")
+	}
+	return c.Diff(os.Stdout, flags.Arg(0), flags.Arg(1), *context, *ignore)
+}
+
+func listCommand(c *Client, opts *PasteOpts, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	filter := &ListOpts{}
+	flags.StringVar(&filter.Author, "author", "", "Only list pastes by this author")
+	flags.StringVar(&filter.Channel, "channel", "", "Only list pastes announced in this channel")
+	flags.StringVar(&filter.Language, "language", "", "Only list pastes in this language")
+	flags.StringVar(&filter.Query, "q", "", "Only list pastes matching this full-text search")
+	flags.IntVar(&filter.Page, "page", 1, "Page of results to list")
+	flags.IntVar(&filter.PageSize, "page-size", 20, "Number of pastes per page")
+	flags.Parse(args)
+
+	return c.List(os.Stdout, filter)
+}
@@ -1,17 +1,31 @@
 package main
 
 import (
+	"flag"
 	"github.com/wisnij/gopaste"
 	"log"
+	"os"
 )
 
 func main() {
 	config := gopaste.ParseConfig()
-	server, err := gopaste.New(config)
+
+	if flag.NArg() > 0 {
+		switch command := flag.Arg(0); command {
+		case "migrate":
+			os.Exit(migrate(config, flag.Args()[1:]))
+		default:
+			log.Fatalf("unknown command '%s'", command)
+		}
+	}
+
+	store, err := gopaste.OpenStore(config)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	defer store.Close()
 
+	server := gopaste.New(config, store)
 	err = server.ListenAndServe()
 	if err != nil {
 		log.Fatal(err.Error())
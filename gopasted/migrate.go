@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/wisnij/gopaste"
+	"log"
+	"os"
+)
+
+const migrateUsage = `usage: gopasted [options] migrate [--dry-run] <status|up>
+
+  status   list all schema migrations and whether each has been applied
+  up       apply all pending migrations
+
+`
+
+// migrate implements the "migrate" command, returning the process exit code.
+func migrate(config *gopaste.Config, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Run pending migrations, then roll them back")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	action := "status"
+	if flags.NArg() > 0 {
+		action = flags.Arg(0)
+	}
+
+	dbh, dialect, err := gopaste.OpenDatabase(config)
+	if err != nil {
+		log.Print(err.Error())
+		return 1
+	}
+	defer dbh.Close()
+
+	switch action {
+	case "status":
+		states, err := gopaste.MigrationStatus(dbh)
+		if err != nil {
+			log.Print(err.Error())
+			return 1
+		}
+
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied.Valid {
+				applied = "applied " + state.AppliedTime().Format(gopaste.TimeFormat)
+			}
+			fmt.Printf("%4d  %-40s  %s\n", state.Version, state.Description, applied)
+		}
+
+	case "up":
+		applied, err := gopaste.Migrate(dbh, dialect, *dryRun)
+		if err != nil {
+			log.Print(err.Error())
+			return 1
+		}
+
+		verb := "applied"
+		if *dryRun {
+			verb = "would apply"
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		for _, m := range applied {
+			fmt.Printf("%s migration %d: %s\n", verb, m.Version, m.Description)
+		}
+
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	return 0
+}
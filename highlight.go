@@ -0,0 +1,121 @@
+package gopaste
+
+import (
+	"fmt"
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"html"
+	"html/template"
+	"strings"
+)
+
+// Values of the --highlight option.
+const (
+	HighlightServer = "server"
+	HighlightClient = "client"
+)
+
+// lexerNames maps the language codes in LanguageNames to the names chroma uses
+// for them, where the two differ.
+var lexerNames = map[string]string{
+	"capnproto": "capnp",
+	"delphi":    "objectpascal",
+	"dos":       "batchfile",
+	"x86asm":    "nasm",
+}
+
+// lexer returns the chroma lexer for a language code, or nil if chroma does not
+// know the language.
+func lexer(language string) chroma.Lexer {
+	if name, ok := lexerNames[language]; ok {
+		language = name
+	}
+
+	l := lexers.Get(language)
+	if l == nil {
+		return nil
+	}
+	return chroma.Coalesce(l)
+}
+
+// tokenClass returns the CSS class for a token type, or the empty string if
+// tokens of that type are not styled.
+func tokenClass(t chroma.TokenType) string {
+	for _, tt := range []chroma.TokenType{t, t.SubCategory(), t.Category()} {
+		if class, ok := chroma.StandardTypes[tt]; ok {
+			return class
+		}
+	}
+	return ""
+}
+
+// HighlightedLines returns the file content as syntax-highlighted HTML, one
+// element per line.  It returns nil if chroma does not know the file's
+// language, in which case highlight.js will highlight it in the browser if it
+// can.
+func (f PasteFile) HighlightedLines() []template.HTML {
+	if !f.Language.Valid {
+		return nil
+	}
+
+	l := lexer(f.Language.String)
+	if l == nil {
+		return nil
+	}
+
+	// leave line endings alone, so that lone carriage returns don't become
+	// extra lines
+	it, err := l.Tokenise(&chroma.TokeniseOptions{State: "root"}, f.Content)
+	if err != nil {
+		return nil
+	}
+
+	var lines []template.HTML
+	for _, tokens := range chroma.SplitTokensIntoLines(it.Tokens()) {
+		var b strings.Builder
+		for _, t := range tokens {
+			text := html.EscapeString(strings.TrimSuffix(t.Value, "\n"))
+			if text == "" {
+				continue
+			}
+			if class := tokenClass(t.Type); class != "" {
+				fmt.Fprintf(&b, `<span class="%s">%s</span>`, class, text)
+			} else {
+				b.WriteString(text)
+			}
+		}
+		lines = append(lines, template.HTML(b.String()))
+	}
+
+	// every highlighted line must line up with its line number
+	if len(lines) != strings.Count(strings.TrimSuffix(f.Content, "\n"), "\n")+1 {
+		return nil
+	}
+	return lines
+}
+
+// highlightedLines returns the lines of a file highlighted as by
+// HighlightedLines, or nil if the server leaves highlighting to the browser.
+func (s *Server) highlightedLines(f *PasteFile) []template.HTML {
+	if !s.highlight {
+		return nil
+	}
+	return f.HighlightedLines()
+}
+
+// joinLines joins a range of highlighted lines into a single block of HTML, or
+// returns the empty string if there are no highlighted lines.
+func joinLines(lines []template.HTML, start, end int) template.HTML {
+	if lines == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, line := range lines[start:end] {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(string(line))
+	}
+	return template.HTML(b.String())
+}
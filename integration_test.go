@@ -0,0 +1,77 @@
+//go:build integration
+
+package gopaste
+
+import (
+	"os"
+	"testing"
+)
+
+// The integration tests run the PasteStore tests against PostgreSQL and
+// MySQL.  They need a database for gopaste to use, which is emptied before
+// each test, given as a data source in the environment:
+//
+//	GOPASTE_TEST_POSTGRES='postgres://gopaste@localhost/gopaste_test?sslmode=disable' \
+//	GOPASTE_TEST_MYSQL='gopaste@tcp(localhost)/gopaste_test' \
+//	go test -tags integration
+//
+// A backend whose variable is unset is skipped.
+
+// gopasteTables are all the tables the migrations create.
+var gopasteTables = []string{
+	"schema_migrations",
+	"delivery_log",
+	"outbox",
+	"paste_files",
+	"revisions",
+	"comments",
+	"pastes",
+}
+
+func TestPostgresStore(t *testing.T) {
+	runStoreTests(t, openTestDatabase(t, "postgres", "GOPASTE_TEST_POSTGRES"))
+}
+
+func TestMysqlStore(t *testing.T) {
+	runStoreTests(t, openTestDatabase(t, "mysql", "GOPASTE_TEST_MYSQL"))
+}
+
+// openTestDatabase returns a function which empties the database named by
+// an environment variable, then opens it as a store.
+func openTestDatabase(t *testing.T, driver, env string) openStore {
+	source := os.Getenv(env)
+	if source == "" {
+		t.Skipf("%s not set", env)
+	}
+
+	return func(t *testing.T) PasteStore {
+		dbh, _, err := OpenDatabase(&Config{DbDriver: driver, DbSource: source})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		drop := []string{}
+		for _, table := range gopasteTables {
+			drop = append(drop, "DROP TABLE IF EXISTS "+table)
+		}
+		if driver == "postgres" {
+			drop = append(drop, "DROP SEQUENCE IF EXISTS paste_ids")
+		} else {
+			drop = append(drop, "DROP TABLE IF EXISTS paste_ids")
+		}
+
+		for _, stmt := range drop {
+			if _, err := dbh.Exec(stmt); err != nil {
+				dbh.Close()
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+		dbh.Close()
+
+		store, err := NewSqlStore(driver, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+}
@@ -0,0 +1,132 @@
+package gopaste
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ircStub is a fake IRC server which welcomes every client, confirms every
+// JOIN and records the lines it receives.
+type ircStub struct {
+	listener net.Listener
+	conns    chan net.Conn
+	lines    chan string
+}
+
+func newIrcStub(t *testing.T) *ircStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &ircStub{listener: l, conns: make(chan net.Conn, 4), lines: make(chan string, 64)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			stub.conns <- conn
+			go stub.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return stub
+}
+
+func (stub *ircStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		stub.lines <- line
+
+		m := parseIrcMessage(line)
+		switch m.Command {
+		case "USER":
+			fmt.Fprintf(conn, ":srv 001 gopaste :Welcome\r\n")
+		case "JOIN":
+			fmt.Fprintf(conn, ":gopaste!u@h JOIN %s\r\n", m.Param(0))
+		}
+	}
+}
+
+// expect checks the next lines the stub receives.
+func (stub *ircStub) expect(t *testing.T, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-stub.lines:
+			if got != w {
+				t.Fatalf("server got %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+}
+
+func TestIrcNotifier(t *testing.T) {
+	stub := newIrcStub(t)
+	notifier, err := newIrcNotifier("irc://gopaste@" + stub.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(&Notification{Channel: "ops", Text: "hello\rQUIT :bye\x00x"}); err != nil {
+		t.Fatal(err)
+	}
+	stub.expect(t,
+		"NICK gopaste",
+		"USER gopaste 0 * :gopaste",
+		"JOIN #ops",
+		"PRIVMSG #ops :hello",
+		"PRIVMSG #ops :QUIT :byex",
+	)
+
+	for _, channel := range []string{"a b", "a,b", "a\x07", "ops\r\nQUIT"} {
+		if err := notifier.Notify(&Notification{Channel: channel, Text: "x"}); err == nil {
+			t.Errorf("notified invalid channel %q", channel)
+		}
+	}
+
+	// the client reconnects and rejoins when the connection is lost
+	(<-stub.conns).Close()
+	stub.expect(t,
+		"NICK gopaste",
+		"USER gopaste 0 * :gopaste",
+		"JOIN #ops",
+	)
+
+	if err := notifier.Notify(&Notification{Channel: "#ops", Text: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	stub.expect(t, "PRIVMSG #ops :again")
+}
+
+func TestIrcLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"one\ntwo", []string{"one", "two"}},
+		{"one\r\n\r\ntwo\r", []string{"one", "two"}},
+		{"a\rPRIVMSG #x :y", []string{"a", "PRIVMSG #x :y"}},
+		{"a\x00b\x01c\td", []string{"abc d"}},
+		{strings.Repeat("x", ircMaxText+1), []string{strings.Repeat("x", ircMaxText), "x"}},
+	}
+
+	for _, test := range tests {
+		if got := ircLines(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ircLines(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,660 @@
+package gopaste
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a PasteStore which keeps everything in memory.  Its contents
+// are lost when the server exits, so it is mostly useful for testing.
+type MemoryStore struct {
+	mutex         sync.RWMutex
+	pastes        map[int64]*Paste
+	revisions     map[int64][]*Revision
+	comments      map[int64]*Comment
+	lastPasteId   int64
+	lastCommentId int64
+
+	deliveries     map[int64]*Delivery
+	lastDeliveryId int64
+
+	attempts      []*DeliveryAttempt
+	lastAttemptId int64
+}
+
+// NewMemoryStore creates a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pastes:     make(map[int64]*Paste),
+		revisions:  make(map[int64][]*Revision),
+		comments:   make(map[int64]*Comment),
+		deliveries: make(map[int64]*Delivery),
+	}
+}
+
+// Close does nothing, since a MemoryStore holds no external resources.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// expired reports whether a paste has expired as of the given time.
+func expired(p *Paste, now int64) bool {
+	return p.Expires.Valid && p.Expires.Int64 <= now
+}
+
+func (m *MemoryStore) InsertPaste(paste *Paste) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if paste.Id == 0 {
+		if paste.Private {
+			if err := m.assignPrivateId(paste); err != nil {
+				return InvalidPasteId, err
+			}
+		} else {
+			// like SqlStore's counter, never reuse the IDs of deleted pastes
+			m.lastPasteId++
+			paste.Id = m.lastPasteId
+		}
+	} else if !paste.Private && paste.Id > m.lastPasteId {
+		m.lastPasteId = paste.Id
+	}
+
+	if paste.Revision == 0 {
+		paste.Revision = 1
+	}
+
+	for _, f := range paste.ExtraFiles {
+		f.PasteId = paste.Id
+	}
+
+	stored := copyPaste(paste)
+	stored.AnnotationNum = 0
+	stored.DeleteToken = ""
+	stored.RootSlug.Valid = false
+	m.pastes[paste.Id] = stored
+	m.revisions[paste.Id] = []*Revision{newRevision(paste, paste.Created)}
+	return paste.Id, nil
+}
+
+// copyPaste returns a copy of a paste which shares none of its files, so that
+// changes made by callers never reach the stored pastes, or vice versa.
+func copyPaste(p *Paste) *Paste {
+	paste := *p
+	paste.ExtraFiles = nil
+	for _, f := range p.ExtraFiles {
+		file := *f
+		paste.ExtraFiles = append(paste.ExtraFiles, &file)
+	}
+	return &paste
+}
+
+// assignPrivateId gives a new private paste a random ID and slug which are not
+// already in use.  The caller must hold the mutex.
+func (m *MemoryStore) assignPrivateId(paste *Paste) error {
+	for i := 0; i < maxPrivateIdAttempts; i++ {
+		id, err := privateId()
+		if err != nil {
+			return err
+		}
+
+		slug, err := newSlug()
+		if err != nil {
+			return err
+		}
+
+		if m.pastes[id] == nil && m.slugPaste(slug) == nil {
+			paste.Id = id
+			paste.Slug.Valid = true
+			paste.Slug.String = slug
+			return nil
+		}
+	}
+	return ErrNoPrivateId
+}
+
+// slugPaste returns the paste with the given slug, or nil if there is none.
+// The caller must hold the mutex.
+func (m *MemoryStore) slugPaste(slug string) *Paste {
+	for _, p := range m.pastes {
+		if p.Slug.Valid && p.Slug.String == slug {
+			return p
+		}
+	}
+	return nil
+}
+
+// rootSlug returns the slug of the paste which p annotates.  The caller must
+// hold the mutex.
+func (m *MemoryStore) rootSlug(p *Paste) sql.NullString {
+	if root := m.pastes[p.Annotates.Int64]; p.Annotates.Valid && root != nil {
+		return root.Slug
+	}
+	return sql.NullString{}
+}
+
+func (m *MemoryStore) ResolvePasteRef(ref string) (int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if isSlug(ref) {
+		if p := m.slugPaste(ref); p != nil {
+			return p.Id, nil
+		}
+		return InvalidPasteId, nil
+	}
+
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if p := m.pastes[id]; err == nil && p != nil && !p.Slug.Valid {
+		return id, nil
+	}
+	return InvalidPasteId, nil
+}
+
+func (m *MemoryStore) UpdatePaste(paste *Paste) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p := m.pastes[paste.Id]
+	if p == nil || p.Revision != paste.Revision {
+		return ErrRevisionConflict
+	}
+	if p.MultiFile() {
+		return ErrMultiFileEdit
+	}
+
+	paste.Revision++
+	p.Title = paste.Title
+	p.Content = paste.Content
+	p.Language = paste.Language
+	p.LanguageGuessed = paste.LanguageGuessed
+	p.Revision = paste.Revision
+	m.revisions[p.Id] = append(m.revisions[p.Id], newRevision(p, time.Now().Unix()))
+	return nil
+}
+
+func (m *MemoryStore) DeletePaste(pasteId int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, c := range m.comments {
+		if c.PasteId == pasteId {
+			delete(m.comments, id)
+		}
+	}
+
+	delete(m.pastes, pasteId)
+	delete(m.revisions, pasteId)
+	return nil
+}
+
+func (m *MemoryStore) DeletePasteTree(pasteId int64) ([]*Paste, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var annotations []*Paste
+	for _, p := range m.pastes {
+		if p.Annotates.Valid && p.Annotates.Int64 == pasteId {
+			annotations = append(annotations, copyPaste(p))
+		}
+	}
+
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Id < annotations[j].Id
+	})
+	for i, ann := range annotations {
+		ann.AnnotationNum = i + 1
+		ann.RootSlug = m.rootSlug(ann)
+	}
+
+	m.deletePaste(pasteId)
+	return annotations, nil
+}
+
+func (m *MemoryStore) GetRevision(pasteId int64, num int) (*Revision, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	revisions := m.revisions[pasteId]
+	if num < 1 || num > len(revisions) {
+		return nil, nil
+	}
+
+	revision := *revisions[num-1]
+	return &revision, nil
+}
+
+func (m *MemoryStore) GetRevisions(pasteId int64) ([]*Revision, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var revisions []*Revision
+	for _, r := range m.revisions[pasteId] {
+		revision := *r
+		revisions = append(revisions, &revision)
+	}
+	return revisions, nil
+}
+
+func (m *MemoryStore) GetPaste(pasteId int64) (*Paste, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	p := m.pastes[pasteId]
+	if p == nil || p.Burn || expired(p, time.Now().Unix()) {
+		return nil, nil
+	}
+
+	paste := copyPaste(p)
+	paste.AnnotationNum = m.annotationOrdinal(p)
+	paste.RootSlug = m.rootSlug(p)
+	return paste, nil
+}
+
+func (m *MemoryStore) GetAnyPaste(pasteId int64) (*Paste, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	p := m.pastes[pasteId]
+	if p == nil {
+		return nil, nil
+	}
+
+	paste := copyPaste(p)
+	paste.AnnotationNum = m.annotationOrdinal(p)
+	paste.RootSlug = m.rootSlug(p)
+	return paste, nil
+}
+
+func (m *MemoryStore) BurnPaste(pasteId int64, view func(*Paste) error) (*Paste, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p := m.pastes[pasteId]
+	if p == nil || !p.Burn || expired(p, time.Now().Unix()) {
+		return nil, nil
+	}
+
+	paste := copyPaste(p)
+	if err := view(paste); err != nil {
+		return nil, err
+	}
+
+	delete(m.pastes, pasteId)
+	delete(m.revisions, pasteId)
+	return paste, nil
+}
+
+// annotations returns the unexpired annotations of a paste, sorted by ID.  The
+// caller must hold the mutex.
+func (m *MemoryStore) annotations(pasteId int64) []*Paste {
+	now := time.Now().Unix()
+	var annotations []*Paste
+	for _, p := range m.pastes {
+		if p.Annotates.Valid && p.Annotates.Int64 == pasteId && !expired(p, now) {
+			annotations = append(annotations, p)
+		}
+	}
+
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Id < annotations[j].Id
+	})
+	return annotations
+}
+
+func (m *MemoryStore) GetAnnotations(pasteId int64) ([]*Paste, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	annotations := []*Paste{}
+	for i, p := range m.annotations(pasteId) {
+		ann := copyPaste(p)
+		ann.AnnotationNum = i + 1
+		ann.RootSlug = m.rootSlug(p)
+		annotations = append(annotations, ann)
+	}
+
+	return annotations, nil
+}
+
+// annotationOrdinal returns N such that p is the Nth annotation of its parent.
+// The caller must hold the mutex.
+func (m *MemoryStore) annotationOrdinal(p *Paste) int {
+	if !p.Annotates.Valid {
+		return 0
+	}
+
+	num := 0
+	for _, o := range m.pastes {
+		if o.Annotates == p.Annotates && o.Id <= p.Id {
+			num++
+		}
+	}
+	return num
+}
+
+func (m *MemoryStore) AnnotationOrdinal(pasteId int64) (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	p := m.pastes[pasteId]
+	if p == nil {
+		return 0, nil
+	}
+	return m.annotationOrdinal(p), nil
+}
+
+func (m *MemoryStore) DeleteExpiredPastes(now int64) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var count int64
+	for id, p := range m.pastes {
+		if expired(p, now) {
+			m.deletePaste(id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// deletePaste removes a paste along with its annotations and all their
+// comments.  The caller must hold the mutex.
+func (m *MemoryStore) deletePaste(pasteId int64) {
+	for id, p := range m.pastes {
+		if p.Annotates.Valid && p.Annotates.Int64 == pasteId {
+			m.deletePaste(id)
+		}
+	}
+
+	for id, c := range m.comments {
+		if c.PasteId == pasteId {
+			delete(m.comments, id)
+		}
+	}
+
+	delete(m.pastes, pasteId)
+	delete(m.revisions, pasteId)
+}
+
+// searchScore returns how well a paste matches a list of lowercase search
+// terms, or 0 if it does not contain all of them.  Matches in the title count
+// for more than matches in the content.
+func searchScore(p *Paste, terms []string) int {
+	found := make(map[string]bool)
+	score := 0
+	for _, field := range []struct {
+		text   string
+		weight int
+	}{{p.Title.String, 10}, {p.Content, 1}} {
+		for _, r := range matchRanges(field.text, terms) {
+			found[strings.ToLower(field.text[r[0]:r[1]])] = true
+			score += field.weight
+		}
+	}
+
+	if len(found) < len(terms) {
+		return 0
+	}
+	return score
+}
+
+func (m *MemoryStore) TopLevelPastes(opts *BrowseOpts) (*PastePage, error) {
+	m.mutex.RLock()
+
+	now := time.Now().Unix()
+	filters := map[string]func(*Paste) string{
+		"author":   func(p *Paste) string { return p.Author.String },
+		"channel":  func(p *Paste) string { return p.Channel.String },
+		"language": func(p *Paste) string { return p.Language.String },
+	}
+
+	var terms []string
+	query, searching := opts.Search["q"]
+	if searching {
+		terms = searchTerms(query)
+	}
+
+	scores := make(map[int64]int)
+	var ids []int64
+	for id, p := range m.pastes {
+		if p.Private || p.Burn || p.Annotates.Valid || expired(p, now) {
+			continue
+		}
+
+		matches := true
+		for key, field := range filters {
+			if value, ok := opts.Search[key]; ok && field(p) != value {
+				matches = false
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		if searching {
+			best := searchScore(p, terms)
+			for _, ann := range m.annotations(id) {
+				if score := searchScore(ann, terms); score > best {
+					best = score
+				}
+			}
+			if best == 0 {
+				continue
+			}
+			scores[id] = best
+		}
+
+		ids = append(ids, id)
+	}
+
+	m.mutex.RUnlock()
+
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] > ids[j]
+	})
+
+	page := &PastePage{Total: len(ids)}
+	offset := (opts.Page - 1) * opts.PageSize
+	if offset < 0 || offset > len(ids) {
+		offset = len(ids)
+	}
+	end := offset + opts.PageSize
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	for _, id := range ids[offset:end] {
+		data, err := GetPasteData(m, id)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			page.Pastes = append(page.Pastes, data)
+		}
+	}
+
+	pageCount := len(page.Pastes)
+	if pageCount > 0 {
+		page.Start = offset + 1
+		page.End = offset + pageCount
+	}
+
+	return page, nil
+}
+
+func (m *MemoryStore) InsertComment(comment *Comment) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastCommentId++
+	comment.Id = m.lastCommentId
+	stored := *comment
+	stored.Replies = nil
+	m.comments[comment.Id] = &stored
+	return comment.Id, nil
+}
+
+func (m *MemoryStore) GetComment(commentId int64) (*Comment, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	c := m.comments[commentId]
+	if c == nil {
+		return nil, nil
+	}
+
+	comment := *c
+	return &comment, nil
+}
+
+func (m *MemoryStore) GetComments(rootId int64) (map[int64][]*Comment, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var all []*Comment
+	for _, c := range m.comments {
+		p := m.pastes[c.PasteId]
+		if p != nil && p.RootId() == rootId {
+			comment := *c
+			all = append(all, &comment)
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Id < all[j].Id
+	})
+	return nestComments(all), nil
+}
+
+func (m *MemoryStore) InsertDelivery(d *Delivery) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastDeliveryId++
+	d.Id = m.lastDeliveryId
+	stored := *d
+	m.deliveries[d.Id] = &stored
+	return d.Id, nil
+}
+
+func (m *MemoryStore) GetDelivery(deliveryId int64) (*Delivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	d := m.deliveries[deliveryId]
+	if d == nil {
+		return nil, nil
+	}
+
+	delivery := *d
+	return &delivery, nil
+}
+
+// selectDeliveries returns copies of the deliveries which satisfy a predicate,
+// oldest first.  The caller must hold the mutex.
+func (m *MemoryStore) selectDeliveries(pred func(*Delivery) bool) []*Delivery {
+	var all []*Delivery
+	for _, d := range m.deliveries {
+		if pred(d) {
+			delivery := *d
+			all = append(all, &delivery)
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Id < all[j].Id
+	})
+	return all
+}
+
+func (m *MemoryStore) GetDeliveries() ([]*Delivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.selectDeliveries(func(d *Delivery) bool { return true }), nil
+}
+
+func (m *MemoryStore) DueDeliveries(notifier string, now int64, limit int) ([]*Delivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	due := m.selectDeliveries(func(d *Delivery) bool {
+		return d.Notifier == notifier && d.Status == DeliveryPending && d.NextAttempt <= now
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (m *MemoryStore) UpdateDelivery(d *Delivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored := m.deliveries[d.Id]
+	if stored == nil {
+		return nil
+	}
+
+	stored.Status = d.Status
+	stored.Attempts = d.Attempts
+	stored.NextAttempt = d.NextAttempt
+	stored.LastError = d.LastError
+	return nil
+}
+
+func (m *MemoryStore) DeleteDelivery(deliveryId int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.deliveries, deliveryId)
+	return nil
+}
+
+func (m *MemoryStore) InsertDeliveryAttempt(a *DeliveryAttempt) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastAttemptId++
+	a.Id = m.lastAttemptId
+	stored := *a
+	m.attempts = append(m.attempts, &stored)
+	return a.Id, nil
+}
+
+func (m *MemoryStore) GetDeliveryAttempts(limit int) ([]*DeliveryAttempt, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var latest []*DeliveryAttempt
+	for i := len(m.attempts) - 1; i >= 0 && len(latest) < limit; i-- {
+		a := *m.attempts[i]
+		latest = append(latest, &a)
+	}
+	return latest, nil
+}
+
+func (m *MemoryStore) DeleteDeliveryAttempts(before int64) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var kept []*DeliveryAttempt
+	for _, a := range m.attempts {
+		if a.Created >= before {
+			kept = append(kept, a)
+		}
+	}
+
+	count := int64(len(m.attempts) - len(kept))
+	m.attempts = kept
+	return count, nil
+}
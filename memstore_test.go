@@ -0,0 +1,44 @@
+package gopaste
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreCopiesFiles(t *testing.T) {
+	m := NewMemoryStore()
+	paste := &Paste{
+		Content:    "first",
+		Filename:   sql.NullString{String: "a.txt", Valid: true},
+		ExtraFiles: []*PasteFile{{Num: 2, Filename: "b.txt", Content: "second"}},
+		Created:    time.Now().Unix(),
+	}
+	id, err := m.InsertPaste(paste)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changes to the inserted paste must not reach the store
+	paste.ExtraFiles[0].Content = "changed by the caller"
+
+	got, err := m.GetPaste(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ExtraFiles[0].Content != "second" {
+		t.Fatalf("stored file changed by the caller: %q", got.ExtraFiles[0].Content)
+	}
+
+	// nor must changes to a fetched one
+	got.ExtraFiles[0].Content = "changed again"
+	got.ExtraFiles = append(got.ExtraFiles, &PasteFile{Num: 3, Filename: "c.txt"})
+
+	again, err := m.GetPaste(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.ExtraFiles) != 1 || again.ExtraFiles[0].Content != "second" {
+		t.Errorf("stored files changed through GetPaste: %+v", again.ExtraFiles)
+	}
+}
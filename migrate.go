@@ -0,0 +1,577 @@
+package gopaste
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is a single versioned change to the database schema.  Migrations
+// are applied in order of version, and each is applied at most once.
+type Migration struct {
+	Version     int
+	Description string
+
+	// Sql holds the statements which make the change, keyed by dialect name.
+	Sql map[string]string
+}
+
+// Migrations lists every change to the database schema, oldest first.  New
+// migrations must be added to the end with the next version number; once
+// released, a migration must never be edited.
+var Migrations = []Migration{
+	{1, "create pastes table", map[string]string{
+		"sqlite3": `
+			CREATE TABLE IF NOT EXISTS pastes (
+				id         INTEGER NOT NULL PRIMARY KEY,
+				title      TEXT,
+				content    TEXT NOT NULL,
+				author     TEXT,
+				language   TEXT,
+				channel    TEXT,
+				annotates  INTEGER,
+				private    INTEGER NOT NULL,
+				created    INTEGER NOT NULL
+			);
+		`,
+		"postgres": `
+			CREATE TABLE IF NOT EXISTS pastes (
+				id         BIGINT NOT NULL PRIMARY KEY,
+				title      TEXT,
+				content    TEXT NOT NULL,
+				author     TEXT,
+				language   TEXT,
+				channel    TEXT,
+				annotates  BIGINT,
+				private    BOOLEAN NOT NULL,
+				created    BIGINT NOT NULL
+			);
+
+			CREATE INDEX pastes_annotates ON pastes (annotates);
+		`,
+		"mysql": `
+			CREATE TABLE IF NOT EXISTS pastes (
+				id         BIGINT NOT NULL PRIMARY KEY,
+				title      TEXT,
+				content    LONGTEXT NOT NULL,
+				author     VARCHAR(255),
+				language   VARCHAR(64),
+				channel    VARCHAR(255),
+				annotates  BIGINT,
+				private    BOOLEAN NOT NULL,
+				created    BIGINT NOT NULL,
+				INDEX pastes_annotates (annotates)
+			) DEFAULT CHARSET=utf8mb4;
+		`,
+	}},
+
+	// FTS5 is optional in SQLite (go-sqlite3 only includes it with the
+	// sqlite_fts5 build tag), so the SQLite index is set up by SetupSearch
+	// each time the store is opened instead, and searches fall back to
+	// substring matching without it.
+	{2, "add full-text search index", map[string]string{
+		"sqlite3": `
+			-- see sqliteDialect.SetupSearch
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN search tsvector
+				GENERATED ALWAYS AS (
+					setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+					setweight(to_tsvector('simple', content), 'D')
+				) STORED;
+
+			CREATE INDEX pastes_search ON pastes USING GIN (search);
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD FULLTEXT INDEX pastes_search (title, content);
+		`,
+	}},
+
+	{3, "add comments table", map[string]string{
+		"sqlite3": `
+			CREATE TABLE comments (
+				id         INTEGER NOT NULL PRIMARY KEY,
+				paste_id   INTEGER NOT NULL,
+				line       INTEGER NOT NULL,
+				reply_to   INTEGER,
+				author     TEXT,
+				content    TEXT NOT NULL,
+				created    INTEGER NOT NULL
+			);
+
+			CREATE INDEX comments_paste_id ON comments (paste_id);
+		`,
+		"postgres": `
+			CREATE TABLE comments (
+				id         BIGSERIAL NOT NULL PRIMARY KEY,
+				paste_id   BIGINT NOT NULL,
+				line       INTEGER NOT NULL,
+				reply_to   BIGINT,
+				author     TEXT,
+				content    TEXT NOT NULL,
+				created    BIGINT NOT NULL
+			);
+
+			CREATE INDEX comments_paste_id ON comments (paste_id);
+		`,
+		"mysql": `
+			CREATE TABLE comments (
+				id         BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				paste_id   BIGINT NOT NULL,
+				line       INT NOT NULL,
+				reply_to   BIGINT,
+				author     VARCHAR(255),
+				content    TEXT NOT NULL,
+				created    BIGINT NOT NULL,
+				INDEX comments_paste_id (paste_id)
+			) DEFAULT CHARSET=utf8mb4;
+		`,
+	}},
+
+	{4, "add paste expiry", map[string]string{
+		"sqlite3": `
+			ALTER TABLE pastes ADD COLUMN expires INTEGER;
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN expires BIGINT;
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD COLUMN expires BIGINT;
+		`,
+	}},
+
+	{5, "add burn-after-reading pastes", map[string]string{
+		"sqlite3": `
+			ALTER TABLE pastes ADD COLUMN burn INTEGER NOT NULL DEFAULT 0;
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN burn BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD COLUMN burn BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+	}},
+
+	// Public paste IDs come from a counter rather than MAX(id), which could
+	// hand out the same ID to two concurrent inserts.  The counter starts from
+	// the highest existing public ID.
+	{6, "add public paste ID counter", map[string]string{
+		"sqlite3": `
+			CREATE TABLE paste_ids (
+				id         INTEGER PRIMARY KEY AUTOINCREMENT
+			);
+
+			INSERT INTO paste_ids (id)
+			SELECT id FROM pastes WHERE NOT private ORDER BY id DESC LIMIT 1;
+		`,
+		"postgres": `
+			CREATE SEQUENCE paste_ids;
+
+			SELECT setval('paste_ids', COALESCE((SELECT MAX(id) FROM pastes WHERE NOT private), 0) + 1, false);
+		`,
+		"mysql": `
+			CREATE TABLE paste_ids (
+				id         BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY
+			);
+
+			INSERT INTO paste_ids (id)
+			SELECT id FROM pastes WHERE NOT private ORDER BY id DESC LIMIT 1;
+		`,
+	}},
+
+	// Every revision of a paste is kept, including the first, which for
+	// existing pastes is copied from the pastes table.
+	{7, "add paste revisions", map[string]string{
+		"sqlite3": `
+			ALTER TABLE pastes ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
+			ALTER TABLE pastes ADD COLUMN owner TEXT;
+
+			CREATE TABLE revisions (
+				paste_id   INTEGER NOT NULL,
+				num        INTEGER NOT NULL,
+				title      TEXT,
+				content    TEXT NOT NULL,
+				language   TEXT,
+				created    INTEGER NOT NULL,
+				PRIMARY KEY (paste_id, num)
+			);
+
+			INSERT INTO revisions (paste_id, num, title, content, language, created)
+			SELECT id, 1, title, content, language, created FROM pastes;
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
+			ALTER TABLE pastes ADD COLUMN owner TEXT;
+
+			CREATE TABLE revisions (
+				paste_id   BIGINT NOT NULL,
+				num        INTEGER NOT NULL,
+				title      TEXT,
+				content    TEXT NOT NULL,
+				language   TEXT,
+				created    BIGINT NOT NULL,
+				PRIMARY KEY (paste_id, num)
+			);
+
+			INSERT INTO revisions (paste_id, num, title, content, language, created)
+			SELECT id, 1, title, content, language, created FROM pastes;
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD COLUMN revision INT NOT NULL DEFAULT 1;
+			ALTER TABLE pastes ADD COLUMN owner VARCHAR(64);
+
+			CREATE TABLE revisions (
+				paste_id   BIGINT NOT NULL,
+				num        INT NOT NULL,
+				title      TEXT,
+				content    LONGTEXT NOT NULL,
+				language   VARCHAR(64),
+				created    BIGINT NOT NULL,
+				PRIMARY KEY (paste_id, num)
+			) DEFAULT CHARSET=utf8mb4;
+
+			INSERT INTO revisions (paste_id, num, title, content, language, created)
+			SELECT id, 1, title, content, language, created FROM pastes;
+		`,
+	}},
+
+	{8, "add paste delete tokens", map[string]string{
+		"sqlite3": `
+			ALTER TABLE pastes ADD COLUMN delete_hash TEXT;
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN delete_hash TEXT;
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD COLUMN delete_hash VARCHAR(64);
+		`,
+	}},
+
+	// New private pastes are identified in URLs by a random slug; older ones
+	// keep using their numeric IDs.  Slugs are case-sensitive, so MySQL needs
+	// a binary collation.
+	{9, "add private paste slugs", map[string]string{
+		"sqlite3": `
+			ALTER TABLE pastes ADD COLUMN slug TEXT;
+			CREATE UNIQUE INDEX pastes_slug ON pastes (slug);
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN slug TEXT;
+			CREATE UNIQUE INDEX pastes_slug ON pastes (slug);
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD COLUMN slug VARCHAR(22) CHARACTER SET ascii COLLATE ascii_bin;
+			CREATE UNIQUE INDEX pastes_slug ON pastes (slug);
+		`,
+	}},
+
+	{10, "add detected languages", map[string]string{
+		"sqlite3": `
+			ALTER TABLE pastes ADD COLUMN language_guessed INTEGER NOT NULL DEFAULT 0;
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN language_guessed BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD COLUMN language_guessed BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+	}},
+
+	// The first file of a paste stays in the pastes table, with an optional
+	// name; any more are numbered from 2 in paste_files.  Comments say which
+	// file their line is in.
+	{11, "add paste files", map[string]string{
+		"sqlite3": `
+			ALTER TABLE pastes ADD COLUMN filename TEXT;
+			ALTER TABLE comments ADD COLUMN file INTEGER NOT NULL DEFAULT 1;
+
+			CREATE TABLE paste_files (
+				paste_id         INTEGER NOT NULL,
+				num              INTEGER NOT NULL,
+				filename         TEXT NOT NULL,
+				language         TEXT,
+				language_guessed INTEGER NOT NULL DEFAULT 0,
+				content          TEXT NOT NULL,
+				PRIMARY KEY (paste_id, num)
+			);
+		`,
+		"postgres": `
+			ALTER TABLE pastes ADD COLUMN filename TEXT;
+			ALTER TABLE comments ADD COLUMN file INTEGER NOT NULL DEFAULT 1;
+
+			CREATE TABLE paste_files (
+				paste_id         BIGINT NOT NULL,
+				num              INTEGER NOT NULL,
+				filename         TEXT NOT NULL,
+				language         TEXT,
+				language_guessed BOOLEAN NOT NULL DEFAULT FALSE,
+				content          TEXT NOT NULL,
+				PRIMARY KEY (paste_id, num)
+			);
+		`,
+		"mysql": `
+			ALTER TABLE pastes ADD COLUMN filename VARCHAR(100);
+			ALTER TABLE comments ADD COLUMN file INT NOT NULL DEFAULT 1;
+
+			CREATE TABLE paste_files (
+				paste_id         BIGINT NOT NULL,
+				num              INT NOT NULL,
+				filename         VARCHAR(100) NOT NULL,
+				language         VARCHAR(64),
+				language_guessed BOOLEAN NOT NULL DEFAULT FALSE,
+				content          LONGTEXT NOT NULL,
+				PRIMARY KEY (paste_id, num)
+			) DEFAULT CHARSET=utf8mb4;
+		`,
+	}},
+
+	// Notifications waiting to be delivered, one row per notifier.
+	{12, "add notification outbox", map[string]string{
+		"sqlite3": `
+			CREATE TABLE outbox (
+				id           INTEGER NOT NULL PRIMARY KEY,
+				notifier     TEXT NOT NULL,
+				channel      TEXT NOT NULL,
+				payload      TEXT NOT NULL,
+				status       TEXT NOT NULL,
+				attempts     INTEGER NOT NULL,
+				next_attempt INTEGER NOT NULL,
+				last_error   TEXT,
+				created      INTEGER NOT NULL
+			);
+
+			CREATE INDEX outbox_next_attempt ON outbox (status, next_attempt);
+		`,
+		"postgres": `
+			CREATE TABLE outbox (
+				id           BIGSERIAL NOT NULL PRIMARY KEY,
+				notifier     TEXT NOT NULL,
+				channel      TEXT NOT NULL,
+				payload      TEXT NOT NULL,
+				status       TEXT NOT NULL,
+				attempts     INTEGER NOT NULL,
+				next_attempt BIGINT NOT NULL,
+				last_error   TEXT,
+				created      BIGINT NOT NULL
+			);
+
+			CREATE INDEX outbox_next_attempt ON outbox (status, next_attempt);
+		`,
+		"mysql": `
+			CREATE TABLE outbox (
+				id           BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				notifier     VARCHAR(1024) NOT NULL,
+				channel      VARCHAR(255) NOT NULL,
+				payload      TEXT NOT NULL,
+				status       VARCHAR(16) NOT NULL,
+				attempts     INT NOT NULL,
+				next_attempt BIGINT NOT NULL,
+				last_error   TEXT,
+				created      BIGINT NOT NULL,
+				INDEX outbox_next_attempt (status, next_attempt)
+			) DEFAULT CHARSET=utf8mb4;
+		`,
+	}},
+
+	// Deliveries get a random ID which webhook receivers can use to spot
+	// retries, and every attempt at a delivery is logged.
+	{13, "add webhooks and delivery log", map[string]string{
+		"sqlite3": `
+			ALTER TABLE outbox ADD COLUMN guid TEXT NOT NULL DEFAULT '';
+			ALTER TABLE outbox ADD COLUMN event TEXT NOT NULL DEFAULT '';
+
+			CREATE TABLE delivery_log (
+				id       INTEGER NOT NULL PRIMARY KEY,
+				guid     TEXT NOT NULL,
+				notifier TEXT NOT NULL,
+				event    TEXT NOT NULL,
+				attempt  INTEGER NOT NULL,
+				status   INTEGER NOT NULL,
+				error    TEXT,
+				duration INTEGER NOT NULL,
+				created  INTEGER NOT NULL
+			);
+
+			CREATE INDEX delivery_log_created ON delivery_log (created);
+		`,
+		"postgres": `
+			ALTER TABLE outbox ADD COLUMN guid TEXT NOT NULL DEFAULT '';
+			ALTER TABLE outbox ADD COLUMN event TEXT NOT NULL DEFAULT '';
+
+			CREATE TABLE delivery_log (
+				id       BIGSERIAL NOT NULL PRIMARY KEY,
+				guid     TEXT NOT NULL,
+				notifier TEXT NOT NULL,
+				event    TEXT NOT NULL,
+				attempt  INTEGER NOT NULL,
+				status   INTEGER NOT NULL,
+				error    TEXT,
+				duration BIGINT NOT NULL,
+				created  BIGINT NOT NULL
+			);
+
+			CREATE INDEX delivery_log_created ON delivery_log (created);
+		`,
+		"mysql": `
+			ALTER TABLE outbox ADD COLUMN guid VARCHAR(64) NOT NULL DEFAULT '';
+			ALTER TABLE outbox ADD COLUMN event VARCHAR(32) NOT NULL DEFAULT '';
+
+			CREATE TABLE delivery_log (
+				id       BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				guid     VARCHAR(64) NOT NULL,
+				notifier VARCHAR(1024) NOT NULL,
+				event    VARCHAR(32) NOT NULL,
+				attempt  INT NOT NULL,
+				status   INT NOT NULL,
+				error    TEXT,
+				duration BIGINT NOT NULL,
+				created  BIGINT NOT NULL,
+				INDEX delivery_log_created (created)
+			) DEFAULT CHARSET=utf8mb4;
+		`,
+	}},
+}
+
+const createMigrationsTableSql = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER NOT NULL PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied     BIGINT NOT NULL
+	);
+`
+
+// LatestSchemaVersion returns the schema version reached by applying every
+// known migration.
+func LatestSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// MigrationState describes whether a migration has been applied to a
+// database.
+type MigrationState struct {
+	Migration
+	Applied sql.NullInt64
+}
+
+// AppliedTime returns the time the migration was applied as a time.Time
+// object.
+func (m MigrationState) AppliedTime() time.Time {
+	return time.Unix(m.Applied.Int64, 0)
+}
+
+// MigrationStatus returns the state of every known migration in a database.
+func MigrationStatus(dbh *sql.DB) ([]MigrationState, error) {
+	if _, err := dbh.Exec(createMigrationsTableSql); err != nil {
+		return nil, err
+	}
+
+	rows, err := dbh.Query("SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	applied := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var when int64
+		if err = rows.Scan(&version, &when); err != nil {
+			return nil, err
+		}
+		applied[version] = when
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	for _, m := range Migrations {
+		state := MigrationState{Migration: m}
+		if when, ok := applied[m.Version]; ok {
+			state.Applied.Valid = true
+			state.Applied.Int64 = when
+			delete(applied, m.Version)
+		}
+		states = append(states, state)
+	}
+
+	if len(applied) > 0 {
+		// the database has been migrated by a newer version of gopaste
+		return nil, fmt.Errorf("database has unknown schema migrations applied; latest known version is %d", LatestSchemaVersion())
+	}
+
+	return states, nil
+}
+
+// PendingMigrations returns the migrations which have not yet been applied to
+// a database, in the order they should be applied.
+func PendingMigrations(dbh *sql.DB) ([]Migration, error) {
+	states, err := MigrationStatus(dbh)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, state := range states {
+		if !state.Applied.Valid {
+			pending = append(pending, state.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate applies all pending migrations to a database within a single
+// transaction, so that either all of them are applied or none are.  If dryRun
+// is true, the migrations are executed but the transaction is rolled back
+// afterwards.  It returns the migrations which were (or would have been)
+// applied.
+//
+// Note that MySQL cannot roll back schema changes, so on MySQL a failed or
+// dry-run migration may still leave some of its changes behind.
+func Migrate(dbh *sql.DB, dialect Dialect, dryRun bool) ([]Migration, error) {
+	pending, err := PendingMigrations(dbh)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	tx, err := dbh.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	for _, m := range pending {
+		script, ok := m.Sql[dialect.Name()]
+		if !ok {
+			tx.Rollback()
+			return nil, fmt.Errorf("migration %d (%s) is not available for %s", m.Version, m.Description, dialect.Name())
+		}
+
+		if err := dialect.ExecScript(tx, script); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+
+		_, err := tx.Exec(dialect.Rebind("INSERT INTO schema_migrations (version, description, applied) VALUES (?, ?, ?)"),
+			m.Version, m.Description, now)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		if err := tx.Rollback(); err != nil {
+			return nil, err
+		}
+		return pending, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
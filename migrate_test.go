@@ -0,0 +1,78 @@
+package gopaste
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	config := &Config{DbDriver: DefaultDriver, DbSource: filepath.Join(t.TempDir(), "gopaste.sqlite")}
+	dbh, dialect, err := OpenDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbh.Close()
+
+	countPending := func() int {
+		t.Helper()
+		pending, err := PendingMigrations(dbh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(pending)
+	}
+
+	steps := []struct {
+		dryRun  bool
+		applied int
+		pending int
+	}{
+		{true, len(Migrations), len(Migrations)},
+		{false, len(Migrations), 0},
+		{false, 0, 0},
+		{true, 0, 0},
+	}
+
+	if got := countPending(); got != len(Migrations) {
+		t.Fatalf("got %d pending migrations on a new database, want %d", got, len(Migrations))
+	}
+	for i, step := range steps {
+		applied, err := Migrate(dbh, dialect, step.dryRun)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if len(applied) != step.applied {
+			t.Errorf("step %d: applied %d migrations, want %d", i, len(applied), step.applied)
+		}
+		if got := countPending(); got != step.pending {
+			t.Errorf("step %d: got %d pending migrations, want %d", i, got, step.pending)
+		}
+	}
+
+	states, err := MigrationStatus(dbh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if !state.Applied.Valid {
+			t.Errorf("migration %d not recorded as applied", state.Version)
+		}
+	}
+
+	// a database migrated by a newer version must not be touched
+	_, err = dbh.Exec("INSERT INTO schema_migrations (version, description, applied) VALUES (?, 'from the future', 0)", LatestSchemaVersion()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(dbh, dialect, false); err == nil {
+		t.Error("migrated a database with an unknown migration applied")
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d (%s) has version %d", i+1, m.Description, m.Version)
+		}
+	}
+}
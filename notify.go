@@ -0,0 +1,385 @@
+package gopaste
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Kinds of event which send notifications.
+const (
+	EventPaste      = "paste"
+	EventAnnotation = "annotation"
+	EventComment    = "comment"
+)
+
+// DefaultNotifyTemplate is the default template for notification messages.
+const DefaultNotifyTemplate = `{{if eq .Event "comment"}}{{.Comment.AuthorDef}} commented on line {{.Line}} of paste #{{.Paste.RootRef}}` +
+	`{{else if eq .Event "annotation"}}{{.Paste.AuthorDef}} annotated paste #{{.Paste.RootRef}} with "{{.Paste.TitleDef}}"` +
+	`{{else}}{{.Paste.AuthorDef}} pasted "{{.Paste.TitleDef}}"{{end}} at {{.Url}}`
+
+// NotifyEvent is a new paste, annotation or comment to be announced in a chat
+// channel.  It is the input to the notification template.
+type NotifyEvent struct {
+	Event   string
+	Channel string
+	Paste   *Paste
+	Comment *Comment
+	Line    string
+	Url     string
+}
+
+// Notification is the message sent to notifiers about an event.  It is a
+// snapshot of the event, so that it can be queued and delivered later even if
+// the paste has since changed or gone.  Text is the message itself, made by the
+// notification template.
+type Notification struct {
+	Event    string `json:"event"`
+	Channel  string `json:"channel"`
+	Text     string `json:"text"`
+	Url      string `json:"url"`
+	Ref      string `json:"ref"`
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	Language string `json:"language,omitempty"`
+	Line     string `json:"line,omitempty"`
+
+	// Guid identifies the delivery of the notification to one notifier, and
+	// is the same for every attempt.  It is set when the notification is
+	// sent, not queued.
+	Guid string `json:"-"`
+}
+
+// Notifier sends notifications to one destination, such as a chat bot or a
+// webhook.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// NotifierFactory creates a notifier from the target part of a --notify
+// option, e.g. a webhook URL.
+type NotifierFactory func(target string) (Notifier, error)
+
+var notifierFactories = make(map[string]NotifierFactory)
+
+// RegisterNotifier makes a kind of notifier available to the --notify option.
+func RegisterNotifier(kind string, factory NotifierFactory) {
+	notifierFactories[kind] = factory
+}
+
+// NotifierKinds returns the names of the registered kinds of notifier.
+func NotifierKinds() []string {
+	var kinds []string
+	for kind := range notifierFactories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func init() {
+	RegisterNotifier("hubot", newHubotNotifier)
+	RegisterNotifier("webhook", newWebhookNotifier)
+	RegisterNotifier("slack", newSlackNotifier)
+	RegisterNotifier("matrix", newMatrixNotifier)
+	RegisterNotifier("irc", newIrcNotifier)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// notifyRoute sends the notifications for channels starting with a prefix to
+// a notifier.  Its name identifies it in the outbox, so it includes neither
+// credentials nor anything else which might be secret.
+type notifyRoute struct {
+	name     string
+	prefix   string
+	kind     string
+	notifier Notifier
+}
+
+// Notifications routes notifications to the notifiers configured for their
+// channels.
+type Notifications struct {
+	routes []notifyRoute
+	tmpl   *template.Template
+}
+
+// NewNotifications sets up the notifiers given by the --notify options, each
+// of the form PREFIX=KIND:TARGET, and the --hubot-host option, which sends
+// notifications for every channel to Hubot.
+func NewNotifications(config *Config) (*Notifications, error) {
+	text := config.NotifyTemplate
+	if text == "" {
+		text = DefaultNotifyTemplate
+	}
+
+	tmpl, err := template.New("notify").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification template: %v", err)
+	}
+
+	n := &Notifications{tmpl: tmpl}
+
+	specs := config.Notify
+	if config.HubotHost != "" {
+		specs = append([]string{"=hubot:" + config.HubotHost}, specs...)
+	}
+
+	for _, spec := range specs {
+		route, err := parseNotifyRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		if n.route(route.name) != nil {
+			return nil, fmt.Errorf("duplicate notifier '%s'", route.name)
+		}
+		n.routes = append(n.routes, route)
+	}
+
+	return n, nil
+}
+
+// parseNotifyRoute parses a --notify option of the form PREFIX=KIND:TARGET.
+func parseNotifyRoute(spec string) (notifyRoute, error) {
+	eq := strings.Index(spec, "=")
+	colon := strings.Index(spec[eq+1:], ":")
+	if eq == -1 || colon == -1 {
+		return notifyRoute{}, fmt.Errorf("invalid notifier '%s'; expected PREFIX=KIND:TARGET", spec)
+	}
+
+	prefix, kind, target := spec[:eq], spec[eq+1:eq+1+colon], spec[eq+2+colon:]
+	factory := notifierFactories[kind]
+	if factory == nil {
+		return notifyRoute{}, fmt.Errorf("unknown notifier kind '%s' in '%s'; known kinds are %s", kind, spec, strings.Join(NotifierKinds(), ", "))
+	}
+
+	notifier, err := factory(target)
+	if err != nil {
+		return notifyRoute{}, fmt.Errorf("invalid notifier '%s': %v", spec, err)
+	}
+
+	name := fmt.Sprintf("%s=%s:%s", prefix, kind, redactTarget(target))
+	return notifyRoute{name: name, prefix: prefix, kind: kind, notifier: notifier}, nil
+}
+
+// redactTarget removes any password and query parameters from a notifier
+// target which is a URL.
+func redactTarget(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return target
+	}
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// route returns the route with the given name, or nil if there is none.
+func (n *Notifications) route(name string) *notifyRoute {
+	if n == nil {
+		return nil
+	}
+	for i := range n.routes {
+		if n.routes[i].name == name {
+			return &n.routes[i]
+		}
+	}
+	return nil
+}
+
+// routesFor returns the routes for a channel: those with the longest prefix
+// which matches it.
+func (n *Notifications) routesFor(channel string) (routes []notifyRoute) {
+	if n == nil {
+		return nil
+	}
+
+	best := -1
+	for _, r := range n.routes {
+		if !strings.HasPrefix(channel, r.prefix) || len(r.prefix) < best {
+			continue
+		}
+		if len(r.prefix) > best {
+			best = len(r.prefix)
+			routes = nil
+		}
+		routes = append(routes, r)
+	}
+	return routes
+}
+
+// render makes the notification for an event, using the template for its
+// message.
+func (n *Notifications) render(e *NotifyEvent) (*Notification, error) {
+	var text bytes.Buffer
+	if err := n.tmpl.Execute(&text, e); err != nil {
+		return nil, fmt.Errorf("error rendering notification: %v", err)
+	}
+
+	note := &Notification{
+		Event:    e.Event,
+		Channel:  e.Channel,
+		Text:     text.String(),
+		Url:      e.Url,
+		Ref:      e.Paste.Ref(),
+		Title:    e.Paste.TitleDef(),
+		Author:   e.Paste.AuthorDef(),
+		Language: e.Paste.Language.String,
+		Line:     e.Line,
+	}
+	if e.Comment != nil {
+		note.Author = e.Comment.AuthorDef()
+	}
+	return note, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// notifyTimeout is how long a notifier waits for its destination to respond.
+const notifyTimeout = 10 * time.Second
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// checkNotifyResponse returns an error if a notification was not accepted.
+func checkNotifyResponse(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// postJson sends a value as JSON in a POST request.
+func postJson(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return checkNotifyResponse(notifyClient.Post(url, "application/json", bytes.NewReader(body)))
+}
+
+// checkUrl returns an error unless a notifier target is an HTTP(S) URL.
+func checkUrl(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("'%s' is not an http or https URL", target)
+	}
+	return nil
+}
+
+// hubotNotifier asks Hubot to say the message in the paste's channel.  Its
+// target is the host (and port) Hubot listens on.
+type hubotNotifier struct {
+	host string
+}
+
+func newHubotNotifier(target string) (Notifier, error) {
+	if target == "" {
+		return nil, fmt.Errorf("missing Hubot host")
+	}
+	return &hubotNotifier{target}, nil
+}
+
+func (h *hubotNotifier) Notify(n *Notification) error {
+	hubotUrl := fmt.Sprintf("http://%s/hubot/say", h.host)
+	return checkNotifyResponse(notifyClient.PostForm(hubotUrl, url.Values{
+		"room":    {n.Channel},
+		"message": {n.Text},
+	}))
+}
+
+// webhookNotifier posts the notification as JSON to a URL.
+type webhookNotifier struct {
+	url string
+}
+
+func newWebhookNotifier(target string) (Notifier, error) {
+	if err := checkUrl(target); err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{target}, nil
+}
+
+func (w *webhookNotifier) Notify(n *Notification) error {
+	return postJson(w.url, n)
+}
+
+// slackNotifier posts the message to a Slack-style incoming webhook URL.
+type slackNotifier struct {
+	url string
+}
+
+func newSlackNotifier(target string) (Notifier, error) {
+	if err := checkUrl(target); err != nil {
+		return nil, err
+	}
+	return &slackNotifier{target}, nil
+}
+
+func (s *slackNotifier) Notify(n *Notification) error {
+	return postJson(s.url, map[string]string{"text": n.Text})
+}
+
+// matrixNotifier sends the message to a Matrix room as a notice.  Its target is
+// the room's URL on the homeserver with an access token, e.g.
+// https://matrix.example.com/!room:example.com?access_token=...
+type matrixNotifier struct {
+	homeserver string
+	room       string
+	token      string
+}
+
+func newMatrixNotifier(target string) (Notifier, error) {
+	if err := checkUrl(target); err != nil {
+		return nil, err
+	}
+
+	u, _ := url.Parse(target)
+	m := &matrixNotifier{
+		homeserver: u.Scheme + "://" + u.Host,
+		room:       strings.TrimPrefix(u.Path, "/"),
+		token:      u.Query().Get("access_token"),
+	}
+	if m.room == "" || m.token == "" {
+		return nil, fmt.Errorf("expected https://HOMESERVER/ROOM?access_token=TOKEN")
+	}
+	return m, nil
+}
+
+func (m *matrixNotifier) Notify(n *Notification) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.notice", "body": n.Text})
+	if err != nil {
+		return err
+	}
+
+	// retries reuse the transaction ID, so that the homeserver posts the
+	// message only once even if an earlier attempt got through
+	txn := "gopaste-" + n.Guid
+	sendUrl := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		m.homeserver, url.PathEscape(m.room), url.PathEscape(txn))
+
+	req, err := http.NewRequest("PUT", sendUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	req.Header.Set("Content-Type", "application/json")
+	return checkNotifyResponse(notifyClient.Do(req))
+}
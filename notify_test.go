@@ -0,0 +1,51 @@
+package gopaste
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatrixRetryKeepsTxnId(t *testing.T) {
+	var paths []string
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		if len(paths) == 1 {
+			http.Error(w, "try again", http.StatusBadGateway)
+		}
+	}))
+	defer homeserver.Close()
+
+	config := &Config{
+		ExternalHost: "paste.example.com",
+		Notify:       []string{"#=matrix:" + homeserver.URL + "/!room:example.com?access_token=secret"},
+	}
+	s := New(config, NewMemoryStore())
+
+	paste := &Paste{Id: 1, Content: "x", Channel: nullString("#ops"), Created: time.Now().Unix()}
+	s.notify(&NotifyEvent{Event: EventPaste, Channel: "#ops", Paste: paste, Url: s.externalUrl("/view/1")})
+
+	deliveries, err := s.Store.GetDeliveries()
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, %v; want 1", len(deliveries), err)
+	}
+	d := deliveries[0]
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.deliver(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(paths) != 2 {
+		t.Fatalf("got %d requests, want 2", len(paths))
+	}
+	if paths[0] != paths[1] {
+		t.Errorf("retry used a different transaction: %s, then %s", paths[0], paths[1])
+	}
+	if !strings.HasSuffix(paths[0], "/send/m.room.message/gopaste-"+d.Guid) {
+		t.Errorf("transaction ID is not the delivery's: %s", paths[0])
+	}
+}
@@ -0,0 +1,352 @@
+package gopaste
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// States of a queued delivery.
+const (
+	DeliveryPending = "pending"
+	DeliveryDead    = "dead"
+)
+
+const (
+	// DeliveryInterval is how often the outbox is checked for deliveries which
+	// are due to be retried.
+	DeliveryInterval = 5 * time.Second
+
+	// DeliveryRetryBase is how long to wait before retrying a failed delivery
+	// for the first time.  The wait doubles with each further failure, up to
+	// DeliveryRetryMax.
+	DeliveryRetryBase = 30 * time.Second
+	DeliveryRetryMax  = 2 * time.Hour
+
+	// MaxDeliveryAttempts is how many times a delivery is tried before it is
+	// given up on as a dead letter.
+	MaxDeliveryAttempts = 10
+
+	// deliveryBatch is how many due deliveries are fetched at a time.
+	deliveryBatch = 100
+)
+
+// Delivery is a notification or webhook event queued in the outbox for one
+// notifier or webhook, which Notifier names.  Pending deliveries are retried
+// until they succeed, when they are removed, or until they have failed
+// MaxDeliveryAttempts times, when they become dead letters and stay in the
+// outbox until they are retried or discarded by hand.  Guid is a random ID
+// which identifies the delivery across attempts, for the delivery log and
+// webhook receivers.
+type Delivery struct {
+	Id          int64          `sql:"id"`
+	Guid        string         `sql:"guid"`
+	Notifier    string         `sql:"notifier"`
+	Event       string         `sql:"event"`
+	Channel     string         `sql:"channel"`
+	Payload     string         `sql:"payload"`
+	Status      string         `sql:"status"`
+	Attempts    int            `sql:"attempts"`
+	NextAttempt int64          `sql:"next_attempt"`
+	LastError   sql.NullString `sql:"last_error"`
+	Created     int64          `sql:"created"`
+}
+
+// Notification decodes the notification to be delivered.
+func (d Delivery) Notification() (*Notification, error) {
+	note := &Notification{}
+	if err := json.Unmarshal([]byte(d.Payload), note); err != nil {
+		return nil, fmt.Errorf("invalid notification in delivery %d: %v", d.Id, err)
+	}
+	return note, nil
+}
+
+// Text returns the message being delivered, or the URL of the paste for a
+// webhook event, which has no message.
+func (d Delivery) Text() string {
+	// webhook payloads share the notification's "url" field
+	note, err := d.Notification()
+	if err != nil {
+		return d.Payload
+	}
+	if note.Text == "" {
+		return note.Url
+	}
+	return note.Text
+}
+
+// Dead reports whether the delivery has been given up on.
+func (d Delivery) Dead() bool {
+	return d.Status == DeliveryDead
+}
+
+// CreatedTime returns the time the delivery was queued as a time.Time object.
+func (d Delivery) CreatedTime() time.Time {
+	return time.Unix(d.Created, 0)
+}
+
+// CreatedDisplay returns the time the delivery was queued in a human-readable
+// format.
+func (d Delivery) CreatedDisplay() string {
+	return d.CreatedTime().Format(TimeFormat)
+}
+
+// CreatedRel returns a string describing how long ago the delivery was queued.
+func (d Delivery) CreatedRel() string {
+	return relativeTime(d.CreatedTime())
+}
+
+// NextAttemptRel returns a string describing how long until the delivery is
+// next tried.
+func (d Delivery) NextAttemptRel() string {
+	return relativeTime(time.Unix(d.NextAttempt, 0))
+}
+
+// retryDelay returns how long to wait before the next attempt at a delivery
+// which has failed the given number of times.
+func retryDelay(attempts int) time.Duration {
+	delay := DeliveryRetryBase
+	for i := 1; i < attempts && delay < DeliveryRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > DeliveryRetryMax {
+		delay = DeliveryRetryMax
+	}
+	return delay
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// notify queues the notification for an event in the outbox for each notifier
+// configured for its channel, and wakes their delivery workers.
+func (s *Server) notify(e *NotifyEvent) {
+	routes := s.Notify.routesFor(e.Channel)
+	if len(routes) == 0 {
+		return
+	}
+
+	note, err := s.Notify.render(e)
+	if err != nil {
+		log.Printf("[notify] %v", err)
+		return
+	}
+
+	payload, err := json.Marshal(note)
+	if err != nil {
+		log.Printf("[notify] error encoding notification: %v", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, r := range routes {
+		guid, err := newToken()
+		if err != nil {
+			log.Printf("[notify] error creating delivery ID: %v", err)
+			return
+		}
+
+		d := &Delivery{
+			Guid:        guid,
+			Notifier:    r.name,
+			Event:       note.Event,
+			Channel:     note.Channel,
+			Payload:     string(payload),
+			Status:      DeliveryPending,
+			NextAttempt: now,
+			Created:     now,
+		}
+		if _, err := s.Store.InsertDelivery(d); err != nil {
+			log.Printf("[notify] error queueing notification for %s: %v", r.name, err)
+			continue
+		}
+		s.wakeDeliveries(r.name)
+	}
+}
+
+// wakeDeliveries tells the worker for a notifier to check the outbox now rather
+// than waiting for its next scheduled check.
+func (s *Server) wakeDeliveries(notifier string) {
+	select {
+	case s.deliveryWake[notifier] <- struct{}{}:
+	default:
+	}
+}
+
+// deliverNotifications starts a worker for each notifier, which periodically
+// delivers its notifications in the outbox which are due, and whenever it is
+// woken by wakeDeliveries.  Each notifier has its own worker so that one which
+// is slow or unreachable does not hold up the others.
+func (s *Server) deliverNotifications(interval time.Duration) {
+	for notifier, wake := range s.deliveryWake {
+		go func(notifier string, wake chan struct{}) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				s.deliverDue(notifier)
+
+				select {
+				case <-ticker.C:
+				case <-wake:
+				}
+			}
+		}(notifier, wake)
+	}
+}
+
+// deliverDue makes one attempt at each delivery for a notifier which is due, in
+// the order they were queued.  It stops at the first which fails, leaving the
+// rest until the next check, rather than trying a notifier which is down again
+// and again.
+func (s *Server) deliverDue(notifier string) {
+	for {
+		due, err := s.Store.DueDeliveries(notifier, time.Now().Unix(), deliveryBatch)
+		if err != nil {
+			log.Printf("[notify] error fetching due deliveries for %s: %v", notifier, err)
+			return
+		}
+
+		for _, d := range due {
+			sent, err := s.deliver(d)
+			if err != nil {
+				log.Printf("[notify] error updating delivery %d: %v", d.Id, err)
+				return
+			}
+			if !sent {
+				return
+			}
+		}
+
+		if len(due) < deliveryBatch {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt at a delivery and records it in the delivery log,
+// then removes the delivery from the outbox if it succeeded or schedules the
+// next attempt if it failed.  It reports whether the delivery was sent.
+func (s *Server) deliver(d *Delivery) (bool, error) {
+	start := time.Now()
+	status, err := s.send(d)
+
+	d.Attempts++
+	attempt := &DeliveryAttempt{
+		Guid:     d.Guid,
+		Notifier: d.Notifier,
+		Event:    d.Event,
+		Attempt:  d.Attempts,
+		Status:   status,
+		Duration: time.Since(start).Milliseconds(),
+		Created:  start.Unix(),
+	}
+	if err != nil {
+		attempt.Error = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if _, logErr := s.Store.InsertDeliveryAttempt(attempt); logErr != nil {
+		log.Printf("[notify] error logging delivery %s: %v", d.Guid, logErr)
+	}
+
+	if err == nil {
+		log.Printf("[notify] %s %s: delivered %s", d.Notifier, d.Event, d.Guid)
+		return true, s.Store.DeleteDelivery(d.Id)
+	}
+
+	d.LastError = attempt.Error
+	if d.Attempts >= MaxDeliveryAttempts {
+		d.Status = DeliveryDead
+		log.Printf("[notify] %s %s: giving up on %s after %d attempts: %v", d.Notifier, d.Event, d.Guid, d.Attempts, err)
+	} else {
+		d.NextAttempt = time.Now().Add(retryDelay(d.Attempts)).Unix()
+		log.Printf("[notify] %s %s: attempt %d at %s failed, retrying in %s: %v", d.Notifier, d.Event, d.Attempts, d.Guid, retryDelay(d.Attempts), err)
+	}
+	return false, s.Store.UpdateDelivery(d)
+}
+
+// send passes a delivery to its webhook or notifier, returning the HTTP status
+// of the webhook's response, or 0 for a notifier.
+func (s *Server) send(d *Delivery) (int, error) {
+	if hook := s.webhook(d.Notifier); hook != nil {
+		return hook.send(d, s.Config.WebhookSecret)
+	}
+
+	note, err := d.Notification()
+	if err != nil {
+		return 0, err
+	}
+	note.Guid = d.Guid
+
+	route := s.Notify.route(d.Notifier)
+	if route == nil {
+		return 0, fmt.Errorf("notifier is not configured")
+	}
+	return 0, route.notifier.Notify(note)
+}
+
+// retryDelivery queues a pending or dead delivery to be tried again at once,
+// with a fresh set of attempts.
+func (s *Server) retryDelivery(d *Delivery) error {
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.NextAttempt = time.Now().Unix()
+	if err := s.Store.UpdateDelivery(d); err != nil {
+		return err
+	}
+
+	s.wakeDeliveries(d.Notifier)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DeliveryLogAge is how long attempts stay in the delivery log.
+const DeliveryLogAge = 30 * Day
+
+// DeliveryAttempt is an entry in the delivery log: one attempt at a delivery,
+// successful or not.  Status is the HTTP status of a webhook's response, or 0
+// if there was none; Duration is in milliseconds.
+type DeliveryAttempt struct {
+	Id       int64          `sql:"id"`
+	Guid     string         `sql:"guid"`
+	Notifier string         `sql:"notifier"`
+	Event    string         `sql:"event"`
+	Attempt  int            `sql:"attempt"`
+	Status   int            `sql:"status"`
+	Error    sql.NullString `sql:"error"`
+	Duration int64          `sql:"duration"`
+	Created  int64          `sql:"created"`
+}
+
+// Succeeded reports whether the attempt delivered its notification or event.
+func (a DeliveryAttempt) Succeeded() bool {
+	return !a.Error.Valid
+}
+
+// CreatedTime returns the time of the attempt as a time.Time object.
+func (a DeliveryAttempt) CreatedTime() time.Time {
+	return time.Unix(a.Created, 0)
+}
+
+// CreatedDisplay returns the time of the attempt in a human-readable format.
+func (a DeliveryAttempt) CreatedDisplay() string {
+	return a.CreatedTime().Format(TimeFormat)
+}
+
+// CreatedRel returns a string describing how long ago the attempt was made.
+func (a DeliveryAttempt) CreatedRel() string {
+	return relativeTime(a.CreatedTime())
+}
+
+// pruneDeliveryLog removes attempts older than DeliveryLogAge from the
+// delivery log.
+func (s *Server) pruneDeliveryLog() {
+	before := time.Now().Unix() - DeliveryLogAge
+	count, err := s.Store.DeleteDeliveryAttempts(before)
+	if err != nil {
+		log.Printf("[notify] error pruning delivery log: %v", err)
+	} else if count > 0 {
+		log.Printf("[notify] pruned %d entries from the delivery log", count)
+	}
+}
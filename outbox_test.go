@@ -0,0 +1,65 @@
+package gopaste
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingNotifier counts the notifications it is sent, and fails to send
+// them if err is set.
+type countingNotifier struct {
+	sent int
+	err  error
+}
+
+func (n *countingNotifier) Notify(note *Notification) error {
+	n.sent++
+	return n.err
+}
+
+func TestDeliverDue(t *testing.T) {
+	s := newTestServer(t)
+	down := &countingNotifier{err: errors.New("connection refused")}
+	up := &countingNotifier{}
+	s.Notify = &Notifications{routes: []notifyRoute{
+		{name: "down", prefix: "#", notifier: down},
+		{name: "up", prefix: "#", notifier: up},
+	}}
+
+	now := time.Now().Unix()
+	for i := 0; i < 3; i++ {
+		for _, name := range []string{"down", "up"} {
+			d := &Delivery{Notifier: name, Channel: "#a", Payload: `{"text":"hi"}`, Status: DeliveryPending, NextAttempt: now, Created: now}
+			if _, err := s.Store.InsertDelivery(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// a notifier's run stops at its first failure, and leaves the others alone
+	s.deliverDue("down")
+	if down.sent != 1 || up.sent != 0 {
+		t.Errorf("after delivering to down: sent %d to down and %d to up, want 1 and 0", down.sent, up.sent)
+	}
+
+	s.deliverDue("up")
+	if up.sent != 3 {
+		t.Errorf("after delivering to up: sent %d, want 3", up.sent)
+	}
+
+	deliveries, err := s.Store.GetDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	for _, d := range deliveries {
+		if d.Notifier != "down" {
+			t.Errorf("delivery %d to %s is still queued", d.Id, d.Notifier)
+		}
+		attempts += d.Attempts
+	}
+	if len(deliveries) != 3 || attempts != 1 {
+		t.Errorf("got %d deliveries with %d attempts, want 3 with 1", len(deliveries), attempts)
+	}
+}
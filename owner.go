@@ -0,0 +1,59 @@
+package gopaste
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// OwnerCookie is the name of the cookie which identifies the person who
+// created a paste, so that they can edit it later.  Only a hash of the
+// cookie's value is stored with the paste.
+const OwnerCookie = "gopaste_owner"
+
+// ownerCookieAge is how long an owner cookie lasts, in seconds.
+const ownerCookieAge = 10 * Year
+
+// ownerHash returns the hashed owner token sent with a request, or the empty
+// string if there is none.
+func ownerHash(req *http.Request) string {
+	cookie, err := req.Cookie(OwnerCookie)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	return hashToken(cookie.Value)
+}
+
+// setOwner marks a paste as belonging to the client making a request, first
+// giving the client an owner cookie if it does not already have one.
+func setOwner(q *Query, paste *Paste) error {
+	hash := ownerHash(q.Request)
+	if hash == "" {
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+
+		http.SetCookie(q.Response, &http.Cookie{
+			Name:     OwnerCookie,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   ownerCookieAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		hash = hashToken(token)
+	}
+
+	paste.Owner.Valid = true
+	paste.Owner.String = hash
+	return nil
+}
+
+// OwnedBy reports whether a paste belongs to the owner with the given hashed
+// token.
+func (p Paste) OwnedBy(hash string) bool {
+	if !p.Owner.Valid || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(p.Owner.String), []byte(hash)) == 1
+}
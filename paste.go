@@ -3,10 +3,10 @@ package gopaste
 import (
 	"database/sql"
 	"fmt"
-	"github.com/kisielk/sqlstruct"
+	"html/template"
 	"math"
-	"math/rand"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,16 +18,63 @@ const (
 
 // Paste represents an individual paste.
 type Paste struct {
-	Id            int64          `sql:"id"`
-	Title         sql.NullString `sql:"title"`
-	Content       string         `sql:"content"`
-	Author        sql.NullString `sql:"author"`
-	Language      sql.NullString `sql:"language"`
-	Channel       sql.NullString `sql:"channel"`
-	Annotates     sql.NullInt64  `sql:"annotates"`
-	Private       bool           `sql:"private"`
-	Created       int64          `sql:"created"`
-	AnnotationNum int            `sql:"-"`
+	Id              int64          `sql:"id"`
+	Title           sql.NullString `sql:"title"`
+	Content         string         `sql:"content"`
+	Author          sql.NullString `sql:"author"`
+	Language        sql.NullString `sql:"language"`
+	Channel         sql.NullString `sql:"channel"`
+	Annotates       sql.NullInt64  `sql:"annotates"`
+	Private         bool           `sql:"private"`
+	Created         int64          `sql:"created"`
+	Expires         sql.NullInt64  `sql:"expires"`
+	Burn            bool           `sql:"burn"`
+	Revision        int            `sql:"revision"`
+	Owner           sql.NullString `sql:"owner"`
+	DeleteHash      sql.NullString `sql:"delete_hash"`
+	Slug            sql.NullString `sql:"slug"`
+	LanguageGuessed bool           `sql:"language_guessed"`
+	Filename        sql.NullString `sql:"filename"`
+	AnnotationNum   int            `sql:"-"`
+
+	// ExtraFiles holds the files of the paste after the first, which is
+	// stored in the paste itself.
+	ExtraFiles []*PasteFile `sql:"-"`
+
+	// RootSlug is the slug of the paste this one annotates, if it has one.
+	RootSlug sql.NullString `sql:"-"`
+
+	// DeleteToken is the secret token which allows the paste to be deleted.
+	// Only its hash is stored, so it is only known just after the paste is
+	// created.
+	DeleteToken string `sql:"-"`
+}
+
+type expiryOption struct {
+	Code    string
+	Name    string
+	Seconds int64
+}
+
+// ExpiryOptions lists the lifetimes which can be chosen for a new paste.
+var ExpiryOptions = []expiryOption{
+	{"", "never", 0},
+	{"10m", "10 minutes", 10 * Minute},
+	{"1h", "1 hour", Hour},
+	{"1d", "1 day", Day},
+	{"1w", "1 week", Week},
+	{"1M", "1 month", Month},
+}
+
+// expirySeconds returns the lifetime in seconds for an expiry option code, or 0
+// if pastes with that code never expire.
+func expirySeconds(code string) int64 {
+	for _, opt := range ExpiryOptions {
+		if opt.Code == code {
+			return opt.Seconds
+		}
+	}
+	return 0
 }
 
 // NewPaste creates a new paste from a submitted web form.
@@ -35,9 +82,15 @@ func NewPaste(v url.Values) *Paste {
 	paste := &Paste{
 		Content: v.Get("Content"),
 		Private: (v.Get("Private") == "on"),
+		Burn:    (v.Get("Burn") == "on"),
 		Created: time.Now().Unix(),
 	}
 
+	if paste.Burn {
+		// burned pastes must not be guessable from their IDs
+		paste.Private = true
+	}
+
 	if s := strings.TrimSpace(v.Get("Title")); s != "" {
 		paste.Title.Valid = true
 		paste.Title.String = s
@@ -48,10 +101,17 @@ func NewPaste(v url.Values) *Paste {
 		paste.Author.String = s
 	}
 
-	if s := v.Get("Language"); s != "" {
-		paste.Language.Valid = true
-		paste.Language.String = s
+	if s := strings.TrimSpace(v.Get("Filename")); s != "" {
+		paste.Filename.Valid = true
+		paste.Filename.String = s
+	}
+
+	name := paste.Title.String
+	if paste.Filename.Valid {
+		name = paste.Filename.String
 	}
+	paste.Language, paste.LanguageGuessed = chooseLanguage(v.Get("Language"), name, paste.Content)
+	paste.ExtraFiles = newPasteFiles(v)
 
 	if s := v.Get("Channel"); s != "" {
 		if !strings.ContainsAny(s[0:1], "&#+!") {
@@ -61,6 +121,11 @@ func NewPaste(v url.Values) *Paste {
 		paste.Channel.String = s
 	}
 
+	if secs := expirySeconds(v.Get("Expires")); secs > 0 {
+		paste.Expires.Valid = true
+		paste.Expires.Int64 = paste.Created + secs
+	}
+
 	return paste
 }
 
@@ -120,6 +185,27 @@ func (p Paste) RootId() int64 {
 	}
 }
 
+// Ref returns the string which identifies the paste in URLs: its slug if it
+// has one, or its numeric ID otherwise.
+func (p Paste) Ref() string {
+	if p.Slug.Valid {
+		return p.Slug.String
+	}
+	return strconv.FormatInt(p.Id, 10)
+}
+
+// RootRef returns the string which identifies the paste this one annotates in
+// URLs, or this paste's own Ref if this is a top-level paste.
+func (p Paste) RootRef() string {
+	if !p.Annotates.Valid {
+		return p.Ref()
+	}
+	if p.RootSlug.Valid {
+		return p.RootSlug.String
+	}
+	return strconv.FormatInt(p.Annotates.Int64, 10)
+}
+
 const (
 	Minute = 60
 	Hour   = 60 * Minute
@@ -144,6 +230,22 @@ var timeThresholds = []timeThreshold{
 	{Year, "year"},
 }
 
+// ExpiresTime returns the paste's expiration time as a time.Time object.
+func (p Paste) ExpiresTime() time.Time {
+	return time.Unix(p.Expires.Int64, 0)
+}
+
+// ExpiresDisplay returns the paste expiration date in a human-readable format.
+func (p Paste) ExpiresDisplay() string {
+	return p.ExpiresTime().Format(TimeFormat)
+}
+
+// ExpiresRel returns a string describing how long until the paste expires
+// (e.g. "3 days from now").
+func (p Paste) ExpiresRel() string {
+	return relativeTime(p.ExpiresTime())
+}
+
 // CreatedTime returns the paste's creation time as a time.Time object.
 func (p Paste) CreatedTime() time.Time {
 	return time.Unix(p.Created, 0)
@@ -157,8 +259,14 @@ func (p Paste) CreatedDisplay() string {
 // CreatedRel returns a string describing how long ago the paste was created, in
 // a human-friendly format (e.g. "3 days ago").
 func (p Paste) CreatedRel() string {
+	return relativeTime(p.CreatedTime())
+}
+
+// relativeTime returns a string describing how long ago a time was, in a
+// human-friendly format.
+func relativeTime(t time.Time) string {
 	relString := "ago"
-	secondsAgo := time.Since(p.CreatedTime()).Seconds()
+	secondsAgo := time.Since(t).Seconds()
 	if secondsAgo < 0 {
 		secondsAgo *= -1
 		relString = "from now"
@@ -191,151 +299,22 @@ func (p Paste) CreatedRel() string {
 	return fmt.Sprintf("%s %s %s", article, unit, relString)
 }
 
-//
+// LineNumber identifies a single line of a file of a paste or annotation.
+// Anchor is the line's fragment identifier within the view of its paste thread
+// (e.g. "14", "2.14" for line 14 of the second annotation, or "main.go:14" in
+// a named file), and RootRef identifies the top-level paste of the thread in
+// URLs.
 type LineNumber struct {
-	Num    int
-	Anchor string
-}
-
-// LineNumbers returns a list of LineNumber objects for a paste.
-func (p Paste) LineNumbers() (ns []LineNumber) {
-	for i := 0; i <= strings.Count(strings.TrimSuffix(p.Content, "\n"), "\n"); i++ {
-		n := i + 1
-		var s string
-		if p.AnnotationNum > 0 {
-			s = fmt.Sprintf("%d.%d", p.AnnotationNum, n)
-		} else {
-			s = fmt.Sprint(n)
-		}
-		ns = append(ns, LineNumber{Num: n, Anchor: s})
-	}
-	return ns
+	Num     int
+	File    int
+	Anchor  string
+	RootRef string
 }
 
-// publicId returns the next available public paste ID.
-func publicId(dbh *sql.DB) (int64, error) {
-	var num int64
-	err := dbh.QueryRow("SELECT COALESCE(MAX(id), 0) FROM pastes WHERE NOT private").Scan(&num)
-	if err != nil {
-		return InvalidPasteId, err
-	}
-	return num + 1, nil
-}
-
-// privateIdBase is the smallest private paste ID.
-const privateIdBase = 1 << 62
-
-var privateIdRand = rand.New(rand.NewSource(time.Now().UnixNano()))
-
-// privateId returns a random number in the range [1<<62, 1<<63).
-func privateId() int64 {
-	return privateIdBase + privateIdRand.Int63n(privateIdBase)
-}
-
-func InsertPaste(dbh *sql.DB, paste *Paste) (int64, error) {
-	tx, err := dbh.Begin()
-	if err != nil {
-		return InvalidPasteId, err
-	}
-
-	if paste.Id == 0 {
-		if paste.Private {
-			paste.Id = privateId()
-		} else {
-			id, err := publicId(dbh)
-			if err != nil {
-				return InvalidPasteId, err
-			}
-			paste.Id = id
-		}
-	}
-
-	query := `
-		INSERT INTO pastes (id, title, content, author, language,
-		                    channel, annotates, private, created)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-    `
-	_, err = tx.Exec(query,
-		paste.Id, paste.Title, paste.Content, paste.Author, paste.Language,
-		paste.Channel, paste.Annotates, paste.Private, paste.Created,
-	)
-
-	if err != nil {
-		tx.Rollback()
-		return InvalidPasteId, err
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		return InvalidPasteId, err
-	}
-
-	return paste.Id, nil
-}
-
-// GetPaste fetches a single paste from its ID.
-func GetPaste(dbh *sql.DB, pasteId int64) (*Paste, error) {
-	query := fmt.Sprintf("SELECT %s FROM pastes WHERE id = ?", sqlstruct.Columns(Paste{}))
-	rows, err := dbh.Query(query, pasteId)
-	if err != nil || !rows.Next() {
-		return nil, err
-	}
-
-	defer rows.Close()
-	paste := &Paste{}
-	if err = sqlstruct.Scan(paste, rows); err != nil {
-		return nil, err
-	}
-
-	annotation, err := AnnotationOrdinal(dbh, pasteId)
-	if err != nil {
-		return nil, err
-	}
-
-	paste.AnnotationNum = annotation
-	return paste, nil
-}
-
-// GetAnnotations fetches all annotations of the paste with the given ID.
-func GetAnnotations(dbh *sql.DB, pasteId int64) ([]*Paste, error) {
-	query := fmt.Sprintf("SELECT %s FROM pastes WHERE annotates = ? ORDER BY id", sqlstruct.Columns(Paste{}))
-	rows, err := dbh.Query(query, pasteId)
-	if err != nil {
-		return nil, err
-	}
-
-	annotations := []*Paste{}
-	for rows.Next() {
-		paste := &Paste{}
-		if err = sqlstruct.Scan(paste, rows); err != nil {
-			return nil, err
-		}
-		annotations = append(annotations, paste)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
-
-	for i := range annotations {
-		annotations[i].AnnotationNum = i + 1
-	}
-
-	return annotations, nil
-}
-
-// AnnotationOrdinal returns N such that the paste is the Nth annotation of its parent.
-func AnnotationOrdinal(dbh *sql.DB, pasteId int64) (int, error) {
-	query := `
-		SELECT COUNT(*)
-		FROM pastes p
-		    LEFT JOIN pastes o ON o.annotates = p.annotates
-		                      AND o.id <= p.id
-		WHERE p.id = ? AND p.annotates IS NOT NULL
-	`
-	var num int
-	err := dbh.QueryRow(query, pasteId).Scan(&num)
-	return num, err
+// LineNumbers returns a list of LineNumber objects for the first file of a
+// paste.
+func (p Paste) LineNumbers() []LineNumber {
+	return p.Files()[0].LineNumbers(p)
 }
 
 type PastePage struct {
@@ -353,110 +332,147 @@ func (p *PastePage) PageCount(pageSize int) int {
 	return cdiv(p.Total, pageSize)
 }
 
-// TopLevelPastes fetches the paste IDs for all pastes which are not private or
-// annotations
-func TopLevelPastes(dbh *sql.DB, opts *BrowseOpts) (*PastePage, error) {
-	commonSql := "FROM pastes WHERE NOT private AND annotates IS NULL"
-
-	var parameters []interface{}
-	if author, ok := opts.Search["author"]; ok {
-		commonSql += " AND author = ?"
-		parameters = append(parameters, author)
-	}
+// PasteData represents a paste and all of its annotations.
+type PasteData struct {
+	Paste       *Paste
+	Annotations []*Paste
+	Comments    map[int64][]*Comment
 
-	if channel, ok := opts.Search["channel"]; ok {
-		commonSql += " AND channel = ?"
-		parameters = append(parameters, channel)
-	}
+	// Viewer is the hashed owner token of the person viewing the pastes, used
+	// to decide which of them they may edit.
+	Viewer string
 
-	if language, ok := opts.Search["language"]; ok {
-		commonSql += " AND language = ?"
-		parameters = append(parameters, language)
-	}
+	// Highlight is whether the pastes are highlighted on the server.
+	Highlight bool
+}
 
-	page := &PastePage{}
+type PasteView struct {
+	*Paste
+	Top        *Paste
+	Prev       *Paste
+	Comments   []*Comment
+	Editable   bool
+	HasHistory bool
+	Highlight  bool
+}
 
-	countSql := "SELECT COUNT(*) " + commonSql
-	countRow := dbh.QueryRow(countSql, parameters...)
-	err := countRow.Scan(&page.Total)
-	if err != nil {
-		return nil, err
+func (d PasteData) PasteView() *PasteView {
+	return &PasteView{
+		Paste:      d.Paste,
+		Comments:   d.Comments[d.Paste.Id],
+		Editable:   d.Paste.OwnedBy(d.Viewer) && !d.Paste.MultiFile(),
+		HasHistory: len(d.Annotations) > 0,
+		Highlight:  d.Highlight,
 	}
+}
 
-	offset := (opts.Page - 1) * opts.PageSize
-	querySql := "SELECT id " + commonSql + " ORDER BY id DESC"
-	querySql += fmt.Sprintf(" LIMIT %d OFFSET %d", opts.PageSize, offset)
-
-	rows, err := dbh.Query(querySql, parameters...)
-	if err != nil {
-		return nil, err
+func (d PasteData) AnnotationsView() (view []PasteView) {
+	var prev *Paste
+	for _, ann := range d.Annotations {
+		view = append(view, PasteView{
+			Paste:      ann,
+			Top:        d.Paste,
+			Prev:       prev,
+			Comments:   d.Comments[ann.Id],
+			Editable:   ann.OwnedBy(d.Viewer) && !ann.MultiFile(),
+			HasHistory: true,
+			Highlight:  d.Highlight,
+		})
+		prev = ann
 	}
+	return
+}
 
-	for rows.Next() {
-		var pasteId int64
-		if err = rows.Scan(&pasteId); err != nil {
-			return nil, err
-		}
-
-		data, err := GetPasteData(dbh, pasteId)
-		if err != nil {
-			return nil, err
-		}
-
-		page.Pastes = append(page.Pastes, data)
-	}
+// HistoryEntry is one step in the history of a paste: the paste itself or one
+// of its annotations, with the number of lines added and removed since the
+// step before.
+type HistoryEntry struct {
+	*Paste
+	Prev    *Paste
+	Added   int
+	Removed int
+}
 
-	pageCount := len(page.Pastes)
-	if pageCount > 0 {
-		page.Start = offset + 1
-		page.End = offset + pageCount
+// History returns the paste followed by each of its annotations in order.  The
+// paste itself counts all of its lines as added.
+func (d PasteData) History() []HistoryEntry {
+	history := make([]HistoryEntry, 0, len(d.Annotations)+1)
+	var prev *Paste
+	for _, p := range append([]*Paste{d.Paste}, d.Annotations...) {
+		added, removed := DiffStat(prev, p)
+		history = append(history, HistoryEntry{p, prev, added, removed})
+		prev = p
 	}
+	return history
+}
 
-	return page, nil
+// PasteSegment is a run of consecutive lines of a paste, followed by the
+// comments on its last line.  Html holds the lines with syntax highlighting, if
+// the paste was highlighted on the server.
+type PasteSegment struct {
+	Lines    []LineNumber
+	Content  string
+	Html     template.HTML
+	Comments []*Comment
 }
 
-// PasteData represents a paste and all of its annotations.
-type PasteData struct {
-	Paste       *Paste
-	Annotations []*Paste
+// FileView is a file of a paste, split into segments for display.
+type FileView struct {
+	*PasteFile
+	Segments []PasteSegment
 }
 
-// GetPasteData fetches a paste and its annotations from the given paste ID.
-func GetPasteData(dbh *sql.DB, pasteId int64) (*PasteData, error) {
-	paste, err := GetPaste(dbh, pasteId)
-	if err != nil || paste == nil {
-		return nil, err
+// FileViews returns each file of the paste, split into segments.
+func (v PasteView) FileViews() (views []FileView) {
+	for _, f := range v.Files() {
+		views = append(views, FileView{f, v.segments(f)})
 	}
+	return views
+}
 
-	data := &PasteData{Paste: paste}
-	annotations, err := GetAnnotations(dbh, pasteId)
-	if err != nil {
-		return nil, err
+// segments splits the content of a file after each line which has comments,
+// so that the comments can be displayed directly beneath their lines.
+func (v PasteView) segments(f *PasteFile) (segments []PasteSegment) {
+	var highlighted []template.HTML
+	if v.Highlight {
+		highlighted = f.HighlightedLines()
 	}
+	numbers := f.LineNumbers(*v.Paste)
 
-	data.Annotations = annotations
-	return data, nil
-}
+	byLine := make(map[int][]*Comment)
+	for _, c := range v.Comments {
+		if c.File == f.Num {
+			byLine[c.Line] = append(byLine[c.Line], c)
+		}
+	}
 
-type PasteView struct {
-	*Paste
-	Top  *Paste
-	Prev *Paste
-}
+	if len(byLine) == 0 {
+		return []PasteSegment{{
+			Lines:   numbers,
+			Content: f.Content,
+			Html:    joinLines(highlighted, 0, len(highlighted)),
+		}}
+	}
 
-func (d PasteData) PasteView() *PasteView {
-	return &PasteView{Paste: d.Paste}
-}
+	lines := strings.SplitAfter(f.Content, "\n")
+	start := 0
+	for i, num := range numbers {
+		comments := byLine[num.Num]
+		for _, c := range comments {
+			c.setLocation(num)
+		}
+		if comments == nil && i+1 < len(numbers) {
+			continue
+		}
 
-func (d PasteData) AnnotationsView() (view []PasteView) {
-	var prev *Paste
-	for _, ann := range d.Annotations {
-		view = append(view, PasteView{
-			Paste: ann,
-			Top:   d.Paste,
-			Prev:  prev,
+		segments = append(segments, PasteSegment{
+			Lines:    numbers[start : i+1],
+			Content:  strings.TrimSuffix(strings.Join(lines[start:i+1], ""), "\n"),
+			Html:     joinLines(highlighted, start, i+1),
+			Comments: comments,
 		})
-		prev = ann
+		start = i + 1
 	}
-	return
+
+	return segments
 }
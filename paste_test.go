@@ -0,0 +1,110 @@
+package gopaste
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewPasteExpiry(t *testing.T) {
+	tests := []struct {
+		code    string
+		seconds int64
+	}{
+		{"", 0},
+		{"10m", 10 * Minute},
+		{"1h", Hour},
+		{"1d", Day},
+		{"1w", Week},
+		{"1M", Month},
+		{"forever", 0},
+	}
+
+	for _, test := range tests {
+		paste := NewPaste(url.Values{"Content": {"x"}, "Expires": {test.code}})
+		if test.seconds == 0 {
+			if paste.Expires.Valid {
+				t.Errorf("expiry %q: paste expires at %d, want never", test.code, paste.Expires.Int64)
+			}
+		} else if !paste.Expires.Valid || paste.Expires.Int64 != paste.Created+test.seconds {
+			t.Errorf("expiry %q: paste expires at %v, want %d", test.code, paste.Expires, paste.Created+test.seconds)
+		}
+	}
+}
+
+func TestNewPasteLanguage(t *testing.T) {
+	tests := []struct {
+		title    string
+		language string
+		want     string
+		guessed  bool
+	}{
+		{"", "", "", false},
+		{"main.go", "", "go", true},
+		{"main.go", NoLanguage, "", false},
+		{"main.go", "python", "python", false},
+	}
+
+	for _, test := range tests {
+		paste := NewPaste(url.Values{"Title": {test.title}, "Content": {"x"}, "Language": {test.language}})
+		if paste.Language.String != test.want || paste.Language.Valid != (test.want != "") || paste.LanguageGuessed != test.guessed {
+			t.Errorf("title %q, language %q: got %v, guessed %v; want %q, guessed %v",
+				test.title, test.language, paste.Language, paste.LanguageGuessed, test.want, test.guessed)
+		}
+	}
+}
+
+func TestExpiredPastes(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().Unix()
+
+	// an expired paste with an annotation and a comment, which the reaper
+	// has not yet deleted
+	expired := &Paste{Content: "old", Created: now - Hour, Expires: sql.NullInt64{Int64: now - 1, Valid: true}}
+	if _, err := s.Store.InsertPaste(expired); err != nil {
+		t.Fatal(err)
+	}
+	annotation := &Paste{Content: "old too", Created: now - Hour, Expires: expired.Expires, Annotates: sql.NullInt64{Int64: expired.Id, Valid: true}}
+	if _, err := s.Store.InsertPaste(annotation); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Store.InsertComment(&Comment{PasteId: expired.Id, Line: 1, Content: "hm", Created: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := apiRequest(s, "POST", "/api/v1/pastes", "application/json", `{"content": "new", "expires": "1h"}`)
+	var fresh ApiPaste
+	decodeJson(t, w, &fresh)
+	if fresh.Expires == nil || fresh.Expires.Unix() < now+Hour {
+		t.Errorf("new paste expires at %v, want an hour from now", fresh.Expires)
+	}
+
+	if paste, err := s.Store.GetPaste(expired.Id); paste != nil || err != nil {
+		t.Errorf("GetPaste of an expired paste: got %v, %v", paste, err)
+	}
+	if w := request(s, "GET", "/view/1", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET /view/1: got status %d, want 404", w.Code)
+	}
+	page, err := s.Store.TopLevelPastes(NewBrowseOpts())
+	if err != nil || page.Total != 1 || page.Pastes[0].Paste.Id != fresh.Id {
+		t.Errorf("got %+v, %v listed, want only paste %d", page, err, fresh.Id)
+	}
+
+	count, err := s.Store.DeleteExpiredPastes(now)
+	if err != nil || count != 1 {
+		t.Errorf("DeleteExpiredPastes: got %d, %v; want 1", count, err)
+	}
+
+	db := s.Store.(*SqlStore).db
+	var rows int
+	db.QueryRow("SELECT COUNT(*) FROM pastes").Scan(&rows)
+	if rows != 1 {
+		t.Errorf("got %d pastes after reaping, want 1", rows)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM comments").Scan(&rows)
+	if rows != 0 {
+		t.Errorf("got %d comments after reaping, want 0", rows)
+	}
+}
@@ -0,0 +1,69 @@
+package gopaste
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Revision is one version of the editable parts of a paste.  Revision 1 is
+// the paste as it was first submitted; each edit adds a new revision.
+type Revision struct {
+	PasteId  int64          `sql:"paste_id"`
+	Num      int            `sql:"num"`
+	Title    sql.NullString `sql:"title"`
+	Content  string         `sql:"content"`
+	Language sql.NullString `sql:"language"`
+	Created  int64          `sql:"created"`
+}
+
+// newRevision returns a revision holding the current state of a paste.
+func newRevision(p *Paste, created int64) *Revision {
+	return &Revision{
+		PasteId:  p.Id,
+		Num:      p.Revision,
+		Title:    p.Title,
+		Content:  p.Content,
+		Language: p.Language,
+		Created:  created,
+	}
+}
+
+// TitleDef returns the revision title if set, or "untitled" otherwise.
+func (r Revision) TitleDef() string {
+	if r.Title.Valid {
+		return r.Title.String
+	}
+	return "untitled"
+}
+
+// Prev returns the number of the revision before this one.
+func (r Revision) Prev() int {
+	return r.Num - 1
+}
+
+// CreatedTime returns the time the revision was made as a time.Time object.
+func (r Revision) CreatedTime() time.Time {
+	return time.Unix(r.Created, 0)
+}
+
+// CreatedDisplay returns the time the revision was made in a human-readable
+// format.
+func (r Revision) CreatedDisplay() string {
+	return r.CreatedTime().Format(TimeFormat)
+}
+
+// CreatedRel returns a string describing how long ago the revision was made.
+func (r Revision) CreatedRel() string {
+	return relativeTime(r.CreatedTime())
+}
+
+// AtRevision returns a copy of the paste as it was at the given revision.
+// Only the first file is kept in revisions; pastes with other files can't be
+// edited, so those are the same in every revision.
+func (p Paste) AtRevision(r *Revision) *Paste {
+	p.Title = r.Title
+	p.Content = r.Content
+	p.Language = r.Language
+	p.Revision = r.Num
+	return &p
+}
@@ -0,0 +1,129 @@
+package gopaste
+
+import (
+	"html/template"
+	"strings"
+	"unicode"
+)
+
+// maxSnippetLines is the maximum number of matching lines shown for each
+// search result.
+const maxSnippetLines = 5
+
+// isWordRune reports whether r is part of a word, as far as search terms are
+// concerned.  This matches the default FTS5 tokenizer, which splits words on
+// anything other than letters and digits.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// searchTerms splits a user-supplied search query into lowercase words.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// ftsQuery converts a user-supplied search query into an FTS5 query string
+// which matches pastes containing all of its words.  Each word is quoted so
+// that punctuation in the query can never be interpreted as FTS5 syntax.
+func ftsQuery(query string) string {
+	var phrases []string
+	for _, term := range searchTerms(query) {
+		phrases = append(phrases, `"`+term+`"`)
+	}
+	if len(phrases) == 0 {
+		return `""`
+	}
+	return strings.Join(phrases, " ")
+}
+
+// SearchLine is a single line of a paste which matched a search query.
+type SearchLine struct {
+	LineNumber
+	Text template.HTML
+}
+
+// SearchResult is a paste thread which matched a search query, along with
+// snippets of its matching lines.
+type SearchResult struct {
+	*PasteData
+	Lines []SearchLine
+}
+
+// NewSearchResult finds the lines of a paste thread which match the words of
+// a search query, and highlights the matching words.
+func NewSearchResult(data *PasteData, query string) *SearchResult {
+	result := &SearchResult{PasteData: data}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return result
+	}
+
+	pastes := append([]*Paste{data.Paste}, data.Annotations...)
+	for _, paste := range pastes {
+		for _, file := range paste.Files() {
+			lines := strings.Split(file.Content, "\n")
+			for _, num := range file.LineNumbers(*paste) {
+				line := lines[num.Num-1]
+				ranges := matchRanges(line, terms)
+				if len(ranges) == 0 {
+					continue
+				}
+
+				result.Lines = append(result.Lines, SearchLine{
+					LineNumber: num,
+					Text:       highlightRanges(line, ranges),
+				})
+
+				if len(result.Lines) >= maxSnippetLines {
+					return result
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// matchRanges returns the byte ranges of whole words in s which match any of
+// the given lowercase terms.
+func matchRanges(s string, terms []string) (ranges [][2]int) {
+	start := -1
+	for i, r := range s + " " {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			word := strings.ToLower(s[start:i])
+			for _, term := range terms {
+				if word == term {
+					ranges = append(ranges, [2]int{start, i})
+					break
+				}
+			}
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// highlightRanges returns s as HTML with the given byte ranges wrapped in
+// <mark> elements.
+func highlightRanges(s string, ranges [][2]int) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, r := range ranges {
+		b.WriteString(template.HTMLEscapeString(s[last:r[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(s[r[0]:r[1]]))
+		b.WriteString("</mark>")
+		last = r[1]
+	}
+	b.WriteString(template.HTMLEscapeString(s[last:]))
+	return template.HTML(b.String())
+}
@@ -0,0 +1,97 @@
+package gopaste
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		terms []string
+		fts   string
+	}{
+		{"", nil, `""`},
+		{"Hello World", []string{"hello", "world"}, `"hello" "world"`},
+		{`foo AND "bar" OR baz*`, []string{"foo", "and", "bar", "or", "baz"}, `"foo" "and" "bar" "or" "baz"`},
+		{"x.y-z", []string{"x", "y", "z"}, `"x" "y" "z"`},
+		{"Ünïcode 42", []string{"ünïcode", "42"}, `"ünïcode" "42"`},
+	}
+
+	for _, test := range tests {
+		if got := searchTerms(test.query); fmt.Sprint(got) != fmt.Sprint(test.terms) {
+			t.Errorf("searchTerms(%q) = %q, want %q", test.query, got, test.terms)
+		}
+		if got := ftsQuery(test.query); got != test.fts {
+			t.Errorf("ftsQuery(%q) = %s, want %s", test.query, got, test.fts)
+		}
+	}
+}
+
+func TestHighlightMatches(t *testing.T) {
+	tests := []struct {
+		line  string
+		terms []string
+		want  string
+	}{
+		{"no match here", []string{"foo"}, "no match here"},
+		{"Foo bar foo", []string{"foo"}, "<mark>Foo</mark> bar <mark>foo</mark>"},
+		{"food is not foo", []string{"foo"}, "food is not <mark>foo</mark>"},
+		{"a<b> & c", []string{"b", "c"}, "a&lt;<mark>b</mark>&gt; &amp; <mark>c</mark>"},
+	}
+
+	for _, test := range tests {
+		got := highlightRanges(test.line, matchRanges(test.line, test.terms))
+		if string(got) != test.want {
+			t.Errorf("highlighting %q in %q: got %q, want %q", test.terms, test.line, got, test.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(t)
+	for _, form := range []url.Values{
+		{"Title": {"quick fox"}, "Content": {"the quick brown fox"}},
+		{"Title": {"dog"}, "Content": {"jumps over the lazy dog"}},
+		{"Content": {"secret fox"}, "Private": {"on"}},
+	} {
+		postPaste(t, s, "", form)
+	}
+	postPaste(t, s, "2", url.Values{"Content": {"a brown dog"}})
+
+	tests := []struct {
+		query string
+		ids   []int64
+	}{
+		{"fox", []int64{1}},
+		{"BROWN", []int64{1, 2}},
+		{"brown dog", []int64{2}},
+		{"lazy", []int64{2}},
+		{"cat", nil},
+		{"secret", nil},
+		{`"`, nil},
+	}
+
+	for _, test := range tests {
+		w := apiRequest(s, "GET", "/api/v1/pastes?q="+url.QueryEscape(test.query), "", "")
+		var list ApiPasteList
+		decodeJson(t, w, &list)
+
+		var ids []int64
+		for _, p := range list.Pastes {
+			ids = append(ids, p.Id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("search for %q: got %v, want %v", test.query, ids, test.ids)
+		}
+	}
+
+	w := request(s, "GET", "/search?q=lazy", nil)
+	if !strings.Contains(w.Body.String(), "<mark>lazy</mark>") {
+		t.Errorf("GET /search?q=lazy: matching line not highlighted in %s", w.Body)
+	}
+}
@@ -0,0 +1,1022 @@
+package gopaste
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/kisielk/sqlstruct"
+	"log"
+	"strconv"
+	"time"
+)
+
+// SqlStore is a PasteStore backed by an SQL database.  Queries are written
+// with ? placeholders and rebound for the database's dialect.
+type SqlStore struct {
+	db      *sql.DB
+	dialect Dialect
+
+	// fullText is whether the database has a full-text index for searches.
+	fullText bool
+}
+
+// OpenDatabase opens the database described by a Config without applying any
+// migrations, and returns it along with its dialect.
+func OpenDatabase(config *Config) (*sql.DB, Dialect, error) {
+	dialect, err := GetDialect(config.DbDriver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dbh, err := sql.Open(config.DbDriver, dialect.DataSource(config.DbSource))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening %s %s: %v\n", config.DbDriver, config.DbSource, err)
+	}
+	return dbh, dialect, nil
+}
+
+// NewSqlStore opens an SQL database and brings its schema up to date.
+func NewSqlStore(driver, source string) (*SqlStore, error) {
+	dbh, dialect, err := OpenDatabase(&Config{DbDriver: driver, DbSource: source})
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := Migrate(dbh, dialect, false)
+	if err != nil {
+		dbh.Close()
+		return nil, err
+	}
+
+	for _, m := range applied {
+		log.Printf("[db] applied migration %d: %s", m.Version, m.Description)
+	}
+
+	fullText, err := dialect.SetupSearch(dbh)
+	if err != nil {
+		dbh.Close()
+		return nil, fmt.Errorf("error setting up full-text search: %v", err)
+	}
+	if !fullText {
+		log.Printf("[db] no full-text search index; searches will use slower substring matching")
+	}
+
+	return &SqlStore{db: dbh, dialect: dialect, fullText: fullText}, nil
+}
+
+// Close closes the underlying database connection.
+func (s *SqlStore) Close() error {
+	return s.db.Close()
+}
+
+// query runs a query which returns rows, after rebinding its placeholders.
+func (s *SqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.Rebind(query), args...)
+}
+
+// queryRow runs a query which returns at most one row, after rebinding its
+// placeholders.
+func (s *SqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.Rebind(query), args...)
+}
+
+// exec runs a query which returns no rows, after rebinding its placeholders.
+func (s *SqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.Rebind(query), args...)
+}
+
+// maxInsertAttempts is how many times InsertPaste runs its transaction before
+// giving up, when it keeps conflicting with other transactions.
+const maxInsertAttempts = 5
+
+func (s *SqlStore) InsertPaste(paste *Paste) (int64, error) {
+	id, slug := paste.Id, paste.Slug
+	for attempt := 1; ; attempt++ {
+		err := s.insertPaste(paste)
+		if err == nil {
+			return paste.Id, nil
+		}
+		if attempt == maxInsertAttempts || !s.dialect.Retryable(err) {
+			return InvalidPasteId, err
+		}
+
+		// discard the IDs allocated by the failed attempt
+		paste.Id, paste.Slug = id, slug
+	}
+}
+
+// insertPaste adds a new paste in a single transaction, allocating its ID in
+// the same transaction so that concurrent inserts never get the same one.
+func (s *SqlStore) insertPaste(paste *Paste) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if paste.Id == 0 {
+		if paste.Private {
+			if err = s.assignPrivateId(tx, paste); err != nil {
+				tx.Rollback()
+				return err
+			}
+		} else {
+			id, err := s.dialect.NextPublicId(tx)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			paste.Id = id
+		}
+	}
+
+	if paste.Revision == 0 {
+		paste.Revision = 1
+	}
+
+	query := `
+		INSERT INTO pastes (id, title, content, author, language,
+		                    channel, annotates, private, created, expires,
+		                    burn, revision, owner, delete_hash, slug,
+		                    language_guessed, filename)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+	_, err = tx.Exec(s.dialect.Rebind(query),
+		paste.Id, paste.Title, paste.Content, paste.Author, paste.Language,
+		paste.Channel, paste.Annotates, paste.Private, paste.Created,
+		paste.Expires, paste.Burn, paste.Revision, paste.Owner,
+		paste.DeleteHash, paste.Slug, paste.LanguageGuessed, paste.Filename,
+	)
+
+	if err == nil {
+		err = s.insertRevision(tx, newRevision(paste, paste.Created))
+	}
+
+	if err == nil {
+		err = s.insertFiles(tx, paste)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// assignPrivateId gives a new private paste a random ID and slug which are not
+// already in use.
+func (s *SqlStore) assignPrivateId(tx *sql.Tx, paste *Paste) error {
+	for i := 0; i < maxPrivateIdAttempts; i++ {
+		id, err := privateId()
+		if err != nil {
+			return err
+		}
+
+		slug, err := newSlug()
+		if err != nil {
+			return err
+		}
+
+		var count int
+		query := s.dialect.Rebind("SELECT COUNT(*) FROM pastes WHERE id = ? OR slug = ?")
+		if err = tx.QueryRow(query, id, slug).Scan(&count); err != nil {
+			return err
+		}
+
+		if count == 0 {
+			paste.Id = id
+			paste.Slug.Valid = true
+			paste.Slug.String = slug
+			return nil
+		}
+	}
+	return ErrNoPrivateId
+}
+
+// insertFiles adds the files of a paste after the first to the paste_files
+// table.
+func (s *SqlStore) insertFiles(tx *sql.Tx, paste *Paste) error {
+	query := s.dialect.Rebind(`
+		INSERT INTO paste_files (paste_id, num, filename, language, language_guessed, content)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	for _, f := range paste.ExtraFiles {
+		f.PasteId = paste.Id
+		if _, err := tx.Exec(query, f.PasteId, f.Num, f.Filename, f.Language, f.LanguageGuessed, f.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFiles fetches the files of each paste after the first.
+func (s *SqlStore) loadFiles(e execer, pastes ...*Paste) error {
+	query := s.dialect.Rebind(fmt.Sprintf("SELECT %s FROM paste_files WHERE paste_id = ? ORDER BY num", sqlstruct.Columns(PasteFile{})))
+	for _, paste := range pastes {
+		rows, err := e.Query(query, paste.Id)
+		if err != nil {
+			return err
+		}
+
+		paste.ExtraFiles = nil
+		for rows.Next() {
+			f := &PasteFile{}
+			if err = sqlstruct.Scan(f, rows); err != nil {
+				rows.Close()
+				return err
+			}
+			paste.ExtraFiles = append(paste.ExtraFiles, f)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertRevision adds a revision of a paste to the revisions table.
+func (s *SqlStore) insertRevision(tx *sql.Tx, r *Revision) error {
+	query := `
+		INSERT INTO revisions (paste_id, num, title, content, language, created)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := tx.Exec(s.dialect.Rebind(query),
+		r.PasteId, r.Num, r.Title, r.Content, r.Language, r.Created,
+	)
+	return err
+}
+
+// UpdatePaste stores the title, content and language of a paste as a new
+// revision.
+func (s *SqlStore) UpdatePaste(paste *Paste) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE pastes
+		SET title = ?, content = ?, language = ?, language_guessed = ?,
+		    revision = revision + 1
+		WHERE id = ? AND revision = ?
+	`
+	result, err := tx.Exec(s.dialect.Rebind(query),
+		paste.Title, paste.Content, paste.Language, paste.LanguageGuessed, paste.Id,
+		paste.Revision,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		return ErrRevisionConflict
+	}
+
+	// checked after the update, which has locked the paste row
+	var files int
+	err = tx.QueryRow(s.dialect.Rebind("SELECT COUNT(*) FROM paste_files WHERE paste_id = ?"), paste.Id).Scan(&files)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if files > 0 {
+		tx.Rollback()
+		return ErrMultiFileEdit
+	}
+
+	paste.Revision++
+	if err = s.insertRevision(tx, newRevision(paste, time.Now().Unix())); err != nil {
+		tx.Rollback()
+		paste.Revision--
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		paste.Revision--
+		return err
+	}
+
+	return nil
+}
+
+// DeletePaste removes a single paste along with its files, revisions and
+// comments.
+func (s *SqlStore) DeletePaste(pasteId int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = s.deletePaste(tx, pasteId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePasteTree removes a paste and its annotations, along with all their
+// files, revisions and comments, in a single transaction.  It returns the
+// annotations which were removed.
+func (s *SqlStore) DeletePasteTree(pasteId int64) ([]*Paste, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := s.deletePasteTree(tx, pasteId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+func (s *SqlStore) deletePasteTree(tx *sql.Tx, pasteId int64) ([]*Paste, error) {
+	query := fmt.Sprintf("SELECT %s FROM pastes WHERE annotates = ? ORDER BY id", sqlstruct.Columns(Paste{}))
+	rows, err := tx.Query(s.dialect.Rebind(query), pasteId)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := []*Paste{}
+	for rows.Next() {
+		paste := &Paste{}
+		if err = sqlstruct.Scan(paste, rows); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		annotations = append(annotations, paste)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rootSlug, err := s.slug(tx, pasteId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ann := range annotations {
+		ann.AnnotationNum = i + 1
+		ann.RootSlug = rootSlug
+		if err = s.deletePaste(tx, ann.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.deletePaste(tx, pasteId); err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// deletePaste removes a single paste and everything stored with it.
+func (s *SqlStore) deletePaste(tx *sql.Tx, pasteId int64) error {
+	for _, query := range []string{
+		"DELETE FROM comments WHERE paste_id = ?",
+		"DELETE FROM revisions WHERE paste_id = ?",
+		"DELETE FROM paste_files WHERE paste_id = ?",
+		"DELETE FROM pastes WHERE id = ?",
+	} {
+		if _, err := tx.Exec(s.dialect.Rebind(query), pasteId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRevision fetches a single revision of a paste.
+func (s *SqlStore) GetRevision(pasteId int64, num int) (*Revision, error) {
+	query := fmt.Sprintf("SELECT %s FROM revisions WHERE paste_id = ? AND num = ?", sqlstruct.Columns(Revision{}))
+	rows, err := s.query(query, pasteId, num)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	revision := &Revision{}
+	if err = sqlstruct.Scan(revision, rows); err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+// GetRevisions fetches every revision of a paste, oldest first.
+func (s *SqlStore) GetRevisions(pasteId int64) ([]*Revision, error) {
+	query := fmt.Sprintf("SELECT %s FROM revisions WHERE paste_id = ? ORDER BY num", sqlstruct.Columns(Revision{}))
+	rows, err := s.query(query, pasteId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var revisions []*Revision
+	for rows.Next() {
+		revision := &Revision{}
+		if err = sqlstruct.Scan(revision, rows); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// notExpiredSql is a condition which excludes pastes that have expired but have
+// not yet been deleted.  It takes the current time as a parameter.
+const notExpiredSql = "(expires IS NULL OR expires > ?)"
+
+// selectPaste fetches the first paste matching an SQL condition, or nil if
+// there are none.
+func (s *SqlStore) selectPaste(e execer, where string, args ...interface{}) (*Paste, error) {
+	query := fmt.Sprintf("SELECT %s FROM pastes WHERE %s", sqlstruct.Columns(Paste{}), where)
+	rows, err := e.Query(s.dialect.Rebind(query), args...)
+	if err != nil || !rows.Next() {
+		return nil, err
+	}
+
+	defer rows.Close()
+	paste := &Paste{}
+	if err = sqlstruct.Scan(paste, rows); err != nil {
+		return nil, err
+	}
+
+	return paste, nil
+}
+
+// GetPaste fetches a single paste from its ID.  Expired pastes are treated as
+// nonexistent, as are burn-after-reading pastes, which can only be fetched once
+// with BurnPaste.
+func (s *SqlStore) GetPaste(pasteId int64) (*Paste, error) {
+	return s.getPaste("id = ? AND NOT burn AND "+notExpiredSql, pasteId, time.Now().Unix())
+}
+
+// GetAnyPaste fetches a single paste from its ID, including expired and
+// burn-after-reading pastes.
+func (s *SqlStore) GetAnyPaste(pasteId int64) (*Paste, error) {
+	return s.getPaste("id = ?", pasteId)
+}
+
+// getPaste fetches the paste selected by a condition, along with its
+// annotation number, root slug and files.
+func (s *SqlStore) getPaste(where string, args ...interface{}) (*Paste, error) {
+	paste, err := s.selectPaste(s.db, where, args...)
+	if err != nil || paste == nil {
+		return nil, err
+	}
+
+	annotation, err := s.AnnotationOrdinal(paste.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	paste.AnnotationNum = annotation
+	if paste.Annotates.Valid {
+		if paste.RootSlug, err = s.slug(s.db, paste.Annotates.Int64); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.loadFiles(s.db, paste); err != nil {
+		return nil, err
+	}
+	return paste, nil
+}
+
+// slug fetches the slug of the paste with the given ID, which is null if the
+// paste has no slug or does not exist.
+func (s *SqlStore) slug(e execer, pasteId int64) (slug sql.NullString, err error) {
+	err = e.QueryRow(s.dialect.Rebind("SELECT slug FROM pastes WHERE id = ?"), pasteId).Scan(&slug)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return slug, err
+}
+
+// ResolvePasteRef returns the ID of the paste identified by a slug, or by a
+// numeric ID if the paste has no slug.
+func (s *SqlStore) ResolvePasteRef(ref string) (int64, error) {
+	var row *sql.Row
+	if isSlug(ref) {
+		row = s.queryRow("SELECT id FROM pastes WHERE slug = ?", ref)
+	} else {
+		id, err := strconv.ParseInt(ref, 10, 64)
+		if err != nil {
+			return InvalidPasteId, nil
+		}
+		row = s.queryRow("SELECT id FROM pastes WHERE id = ? AND slug IS NULL", id)
+	}
+
+	var id int64
+	err := row.Scan(&id)
+	if err == sql.ErrNoRows {
+		return InvalidPasteId, nil
+	} else if err != nil {
+		return InvalidPasteId, err
+	}
+	return id, nil
+}
+
+// BurnPaste fetches a burn-after-reading paste, deletes it from the database
+// and passes it to view, all in one transaction, which is rolled back if view
+// fails.  If several requests try to burn the same paste at once, only one of
+// them gets the paste; the others get nil as if it had never existed.
+func (s *SqlStore) BurnPaste(pasteId int64, view func(*Paste) error) (*Paste, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	paste, err := s.burnPaste(tx, pasteId)
+	if err == nil && paste != nil {
+		err = view(paste)
+	}
+	if err != nil || paste == nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return paste, nil
+}
+
+// burnPaste fetches a burn-after-reading paste and deletes it within a
+// transaction, or returns nil if it has already been burned.
+func (s *SqlStore) burnPaste(tx *sql.Tx, pasteId int64) (*Paste, error) {
+	paste, err := s.selectPaste(tx, "id = ? AND burn AND "+notExpiredSql, pasteId, time.Now().Unix())
+	if err != nil || paste == nil {
+		return nil, err
+	}
+
+	if err = s.loadFiles(tx, paste); err != nil {
+		return nil, err
+	}
+
+	// only one of several concurrent transactions can delete the paste
+	result, err := tx.Exec(s.dialect.Rebind("DELETE FROM pastes WHERE id = ? AND burn"), pasteId)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil || deleted == 0 {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM revisions WHERE paste_id = ?",
+		"DELETE FROM paste_files WHERE paste_id = ?",
+	} {
+		if _, err = tx.Exec(s.dialect.Rebind(query), pasteId); err != nil {
+			return nil, err
+		}
+	}
+
+	return paste, nil
+}
+
+// GetAnnotations fetches all annotations of the paste with the given ID.
+func (s *SqlStore) GetAnnotations(pasteId int64) ([]*Paste, error) {
+	query := fmt.Sprintf("SELECT %s FROM pastes WHERE annotates = ? AND %s ORDER BY id", sqlstruct.Columns(Paste{}), notExpiredSql)
+	rows, err := s.query(query, pasteId, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := []*Paste{}
+	for rows.Next() {
+		paste := &Paste{}
+		if err = sqlstruct.Scan(paste, rows); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, paste)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rootSlug, err := s.slug(s.db, pasteId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range annotations {
+		annotations[i].AnnotationNum = i + 1
+		annotations[i].RootSlug = rootSlug
+	}
+
+	if err = s.loadFiles(s.db, annotations...); err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// AnnotationOrdinal returns N such that the paste is the Nth annotation of its parent.
+func (s *SqlStore) AnnotationOrdinal(pasteId int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM pastes p
+		    LEFT JOIN pastes o ON o.annotates = p.annotates
+		                      AND o.id <= p.id
+		WHERE p.id = ? AND p.annotates IS NOT NULL
+	`
+	var num int
+	err := s.queryRow(query, pasteId).Scan(&num)
+	return num, err
+}
+
+// DeleteExpiredPastes removes all pastes which expired before the given time,
+// along with their annotations and comments.  It returns the number of pastes
+// deleted.
+func (s *SqlStore) DeleteExpiredPastes(now int64) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	commentsSql := `
+		DELETE FROM comments
+		WHERE paste_id IN (SELECT id FROM pastes
+		                   WHERE expires <= ?
+		                      OR annotates IN (SELECT id FROM pastes WHERE expires <= ?))
+	`
+	if _, err := tx.Exec(s.dialect.Rebind(commentsSql), now, now); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	revisionsSql := `
+		DELETE FROM revisions
+		WHERE paste_id IN (SELECT id FROM pastes
+		                   WHERE expires <= ?
+		                      OR annotates IN (SELECT id FROM pastes WHERE expires <= ?))
+	`
+	if _, err := tx.Exec(s.dialect.Rebind(revisionsSql), now, now); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	filesSql := `
+		DELETE FROM paste_files
+		WHERE paste_id IN (SELECT id FROM pastes
+		                   WHERE expires <= ?
+		                      OR annotates IN (SELECT id FROM pastes WHERE expires <= ?))
+	`
+	if _, err := tx.Exec(s.dialect.Rebind(filesSql), now, now); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// MySQL does not allow deleting from a table while selecting from it in a
+	// subquery, so look up the expired pastes first
+	expired, err := selectIds(tx, s.dialect.Rebind("SELECT id FROM pastes WHERE expires <= ?"), now)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, id := range expired {
+		if _, err := tx.Exec(s.dialect.Rebind("DELETE FROM pastes WHERE annotates = ?"), id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec(s.dialect.Rebind("DELETE FROM pastes WHERE expires <= ?"), now)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// selectIds runs a query which returns a single column of IDs.
+func selectIds(e execer, query string, args ...interface{}) ([]int64, error) {
+	rows, err := e.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// searchSql returns the dialect's full-text search subquery, or a substring
+// search if the database has no full-text index.
+func (s *SqlStore) searchSql(query string) (string, []interface{}) {
+	if s.fullText {
+		return s.dialect.SearchSql(query)
+	}
+	return likeSearchSql(query)
+}
+
+// TopLevelPastes fetches the paste IDs for all pastes which are not private or
+// annotations.  If the search options include a "q" key, only pastes whose
+// thread matches that full-text query are included, ordered by relevance.
+func (s *SqlStore) TopLevelPastes(opts *BrowseOpts) (*PastePage, error) {
+	fromSql := "FROM pastes"
+	whereSql := " WHERE NOT private AND NOT burn AND annotates IS NULL AND " + notExpiredSql
+	orderSql := " ORDER BY id DESC"
+
+	var parameters []interface{}
+	if query, ok := opts.Search["q"]; ok {
+		// rank each thread by its best-matching paste
+		searchSql, searchParameters := s.searchSql(query)
+		fromSql += " JOIN (" + searchSql + ") matches ON matches.root = pastes.id"
+		parameters = append(parameters, searchParameters...)
+		orderSql = " ORDER BY matches.score, id DESC"
+	}
+	parameters = append(parameters, time.Now().Unix())
+
+	if author, ok := opts.Search["author"]; ok {
+		whereSql += " AND author = ?"
+		parameters = append(parameters, author)
+	}
+
+	if channel, ok := opts.Search["channel"]; ok {
+		whereSql += " AND channel = ?"
+		parameters = append(parameters, channel)
+	}
+
+	if language, ok := opts.Search["language"]; ok {
+		whereSql += " AND language = ?"
+		parameters = append(parameters, language)
+	}
+
+	commonSql := fromSql + whereSql
+
+	page := &PastePage{}
+
+	countSql := "SELECT COUNT(*) " + commonSql
+	countRow := s.queryRow(countSql, parameters...)
+	err := countRow.Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := (opts.Page - 1) * opts.PageSize
+	querySql := "SELECT id " + commonSql + orderSql
+	querySql += fmt.Sprintf(" LIMIT %d OFFSET %d", opts.PageSize, offset)
+
+	rows, err := s.query(querySql, parameters...)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var pasteId int64
+		if err = rows.Scan(&pasteId); err != nil {
+			return nil, err
+		}
+
+		data, err := GetPasteData(s, pasteId)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Pastes = append(page.Pastes, data)
+	}
+
+	pageCount := len(page.Pastes)
+	if pageCount > 0 {
+		page.Start = offset + 1
+		page.End = offset + pageCount
+	}
+
+	return page, nil
+}
+
+// InsertComment adds a new comment to the database.
+func (s *SqlStore) InsertComment(comment *Comment) (int64, error) {
+	query := `
+		INSERT INTO comments (paste_id, file, line, reply_to, author, content, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	id, err := s.dialect.InsertId(s.db, query,
+		comment.PasteId, comment.File, comment.Line, comment.ReplyTo, comment.Author,
+		comment.Content, comment.Created,
+	)
+	if err != nil {
+		return InvalidPasteId, err
+	}
+
+	comment.Id = id
+	return comment.Id, nil
+}
+
+// GetComment fetches a single comment from its ID.
+func (s *SqlStore) GetComment(commentId int64) (*Comment, error) {
+	query := fmt.Sprintf("SELECT %s FROM comments WHERE id = ?", sqlstruct.Columns(Comment{}))
+	rows, err := s.query(query, commentId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	comment := &Comment{}
+	if err = sqlstruct.Scan(comment, rows); err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+// GetComments fetches the comments on a paste and all of its annotations,
+// keyed by the ID of the paste they belong to.  Replies are nested beneath the
+// comments they reply to, and only the top-level comments of each thread are
+// included directly in the result.
+func (s *SqlStore) GetComments(rootId int64) (map[int64][]*Comment, error) {
+	query := fmt.Sprintf(`
+		SELECT %s FROM comments
+		WHERE paste_id = ?
+		   OR paste_id IN (SELECT id FROM pastes WHERE annotates = ?)
+		ORDER BY id
+	`, sqlstruct.Columns(Comment{}))
+	rows, err := s.query(query, rootId, rootId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var all []*Comment
+	for rows.Next() {
+		comment := &Comment{}
+		if err = sqlstruct.Scan(comment, rows); err != nil {
+			return nil, err
+		}
+		all = append(all, comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nestComments(all), nil
+}
+
+// InsertDelivery adds a delivery to the outbox and returns its ID.
+func (s *SqlStore) InsertDelivery(d *Delivery) (int64, error) {
+	query := `
+		INSERT INTO outbox (guid, notifier, event, channel, payload, status, attempts, next_attempt, last_error, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	id, err := s.dialect.InsertId(s.db, query,
+		d.Guid, d.Notifier, d.Event, d.Channel, d.Payload, d.Status, d.Attempts, d.NextAttempt, d.LastError, d.Created,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	d.Id = id
+	return d.Id, nil
+}
+
+// GetDelivery fetches a single delivery from its ID.
+func (s *SqlStore) GetDelivery(deliveryId int64) (*Delivery, error) {
+	deliveries, err := s.selectDeliveries("WHERE id = ?", deliveryId)
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+	return deliveries[0], nil
+}
+
+// GetDeliveries fetches every delivery in the outbox, oldest first.
+func (s *SqlStore) GetDeliveries() ([]*Delivery, error) {
+	return s.selectDeliveries("ORDER BY id")
+}
+
+// DueDeliveries fetches up to limit pending deliveries for a notifier which are
+// due by the given time, oldest first.
+func (s *SqlStore) DueDeliveries(notifier string, now int64, limit int) ([]*Delivery, error) {
+	rest := fmt.Sprintf("WHERE notifier = ? AND status = ? AND next_attempt <= ? ORDER BY id LIMIT %d", limit)
+	return s.selectDeliveries(rest, notifier, DeliveryPending, now)
+}
+
+// selectDeliveries fetches the deliveries selected by the rest of a query.
+func (s *SqlStore) selectDeliveries(rest string, args ...interface{}) ([]*Delivery, error) {
+	query := fmt.Sprintf("SELECT %s FROM outbox %s", sqlstruct.Columns(Delivery{}), rest)
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var deliveries []*Delivery
+	for rows.Next() {
+		d := &Delivery{}
+		if err = sqlstruct.Scan(d, rows); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// UpdateDelivery stores the status, attempt count, next attempt time and last
+// error of a delivery.
+func (s *SqlStore) UpdateDelivery(d *Delivery) error {
+	query := "UPDATE outbox SET status = ?, attempts = ?, next_attempt = ?, last_error = ? WHERE id = ?"
+	_, err := s.exec(query, d.Status, d.Attempts, d.NextAttempt, d.LastError, d.Id)
+	return err
+}
+
+// DeleteDelivery removes a delivery from the outbox.
+func (s *SqlStore) DeleteDelivery(deliveryId int64) error {
+	_, err := s.exec("DELETE FROM outbox WHERE id = ?", deliveryId)
+	return err
+}
+
+// InsertDeliveryAttempt adds an attempt to the delivery log and returns its ID.
+func (s *SqlStore) InsertDeliveryAttempt(a *DeliveryAttempt) (int64, error) {
+	query := `
+		INSERT INTO delivery_log (guid, notifier, event, attempt, status, error, duration, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	id, err := s.dialect.InsertId(s.db, query,
+		a.Guid, a.Notifier, a.Event, a.Attempt, a.Status, a.Error, a.Duration, a.Created,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	a.Id = id
+	return a.Id, nil
+}
+
+// GetDeliveryAttempts fetches up to limit of the latest attempts in the
+// delivery log, newest first.
+func (s *SqlStore) GetDeliveryAttempts(limit int) ([]*DeliveryAttempt, error) {
+	query := fmt.Sprintf("SELECT %s FROM delivery_log ORDER BY id DESC LIMIT %d", sqlstruct.Columns(DeliveryAttempt{}), limit)
+	rows, err := s.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var attempts []*DeliveryAttempt
+	for rows.Next() {
+		a := &DeliveryAttempt{}
+		if err = sqlstruct.Scan(a, rows); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+// DeleteDeliveryAttempts removes the attempts made before the given time from
+// the delivery log.
+func (s *SqlStore) DeleteDeliveryAttempts(before int64) (int64, error) {
+	result, err := s.exec("DELETE FROM delivery_log WHERE created < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,195 @@
+package gopaste
+
+import (
+	"errors"
+)
+
+// ErrRevisionConflict is returned by UpdatePaste when the paste has been
+// edited since the revision the update was based on.
+var ErrRevisionConflict = errors.New("paste has been edited by someone else")
+
+// ErrMultiFileEdit is returned by UpdatePaste for a paste with more than one
+// file.  Revisions only hold the first file, so such pastes can't be edited.
+var ErrMultiFileEdit = errors.New("pastes with several files cannot be edited")
+
+// ErrNoPrivateId is returned by InsertPaste when it cannot find an unused ID
+// and slug for a private paste.
+var ErrNoPrivateId = errors.New("could not find an unused private paste ID")
+
+// maxPrivateIdAttempts is how many random IDs and slugs InsertPaste tries for
+// a private paste before giving up.
+const maxPrivateIdAttempts = 5
+
+// PasteStore is the storage backend for pastes and their comments, and for the
+// outbox of notifications waiting to be delivered and the log of attempts to
+// deliver them.
+type PasteStore interface {
+	// InsertPaste adds a new paste, assigning it an ID if it does not already
+	// have one, and returns that ID.  New private pastes are given a random
+	// ID and slug.
+	InsertPaste(paste *Paste) (int64, error)
+
+	// ResolvePasteRef returns the ID of the paste identified by a string from
+	// a URL, which is either the paste's slug or, for pastes without a slug,
+	// its numeric ID.  It returns InvalidPasteId if there is no such paste.
+	ResolvePasteRef(ref string) (int64, error)
+
+	// GetPaste fetches a single paste from its ID, or returns nil if there is
+	// no such paste.  Expired and burn-after-reading pastes are treated as
+	// nonexistent.
+	GetPaste(pasteId int64) (*Paste, error)
+
+	// GetAnyPaste fetches a single paste from its ID like GetPaste, but
+	// including expired and burn-after-reading pastes, for deleting them.
+	GetAnyPaste(pasteId int64) (*Paste, error)
+
+	// BurnPaste fetches a burn-after-reading paste, passes it to view and
+	// deletes it, atomically.  If view returns an error, the paste is kept and
+	// the error is returned, so view should do anything which might fail,
+	// such as rendering the page showing the paste, before the paste is gone;
+	// it must not use the store.  If several callers try to burn the same
+	// paste at once, only one of them gets the paste; the others get nil.
+	BurnPaste(pasteId int64, view func(*Paste) error) (*Paste, error)
+
+	// UpdatePaste stores the title, content and language (including whether it
+	// was guessed) of an existing paste as a new revision.  paste.Revision
+	// must be the paste's latest revision number, and is incremented on
+	// success; if another revision has been added in the meantime,
+	// ErrRevisionConflict is returned.  Pastes with several files can't be
+	// updated, and give ErrMultiFileEdit.
+	UpdatePaste(paste *Paste) error
+
+	// DeletePaste removes a single paste along with its revisions and
+	// comments.  Annotations of the paste are not removed.
+	DeletePaste(pasteId int64) error
+
+	// DeletePasteTree removes a paste along with its annotations and all of
+	// their revisions and comments, atomically.  It returns the annotations
+	// which were removed.
+	DeletePasteTree(pasteId int64) ([]*Paste, error)
+
+	// GetRevision fetches a single revision of a paste, or returns nil if
+	// there is no such revision.
+	GetRevision(pasteId int64, num int) (*Revision, error)
+
+	// GetRevisions fetches every revision of a paste, oldest first.
+	GetRevisions(pasteId int64) ([]*Revision, error)
+
+	// GetAnnotations fetches all annotations of the paste with the given ID,
+	// in the order they were created.
+	GetAnnotations(pasteId int64) ([]*Paste, error)
+
+	// AnnotationOrdinal returns N such that the paste is the Nth annotation of
+	// its parent, or 0 if it is not an annotation.
+	AnnotationOrdinal(pasteId int64) (int, error)
+
+	// TopLevelPastes fetches a page of pastes which are not private or
+	// annotations, filtered and ordered according to opts.
+	TopLevelPastes(opts *BrowseOpts) (*PastePage, error)
+
+	// DeleteExpiredPastes removes all pastes which expired before the given
+	// time, along with their annotations and comments, and returns the number
+	// of pastes deleted.
+	DeleteExpiredPastes(now int64) (int64, error)
+
+	// InsertComment adds a new comment and returns its ID.
+	InsertComment(comment *Comment) (int64, error)
+
+	// GetComment fetches a single comment from its ID, or returns nil if there
+	// is no such comment.
+	GetComment(commentId int64) (*Comment, error)
+
+	// GetComments fetches the comments on a paste and all of its annotations,
+	// keyed by the ID of the paste they belong to.  Replies are nested beneath
+	// the comments they reply to.
+	GetComments(rootId int64) (map[int64][]*Comment, error)
+
+	// InsertDelivery adds a delivery to the outbox and returns its ID.
+	InsertDelivery(d *Delivery) (int64, error)
+
+	// GetDelivery fetches a single delivery from its ID, or returns nil if
+	// there is no such delivery.
+	GetDelivery(deliveryId int64) (*Delivery, error)
+
+	// GetDeliveries fetches every delivery in the outbox, pending and dead,
+	// oldest first.
+	GetDeliveries() ([]*Delivery, error)
+
+	// DueDeliveries fetches up to limit pending deliveries for a notifier
+	// whose next attempt is due by the given time, oldest first.
+	DueDeliveries(notifier string, now int64, limit int) ([]*Delivery, error)
+
+	// UpdateDelivery stores the status, attempt count, next attempt time and
+	// last error of a delivery.
+	UpdateDelivery(d *Delivery) error
+
+	// DeleteDelivery removes a delivery from the outbox.
+	DeleteDelivery(deliveryId int64) error
+
+	// InsertDeliveryAttempt adds an attempt to the delivery log and returns
+	// its ID.
+	InsertDeliveryAttempt(a *DeliveryAttempt) (int64, error)
+
+	// GetDeliveryAttempts fetches up to limit of the latest attempts in the
+	// delivery log, newest first.
+	GetDeliveryAttempts(limit int) ([]*DeliveryAttempt, error)
+
+	// DeleteDeliveryAttempts removes the attempts made before the given time
+	// from the delivery log, and returns the number removed.
+	DeleteDeliveryAttempts(before int64) (int64, error)
+
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// MemoryDriver is the --db-driver value which selects the in-memory store.
+const MemoryDriver = "memory"
+
+// OpenStore creates the PasteStore described by a Config.
+func OpenStore(config *Config) (PasteStore, error) {
+	if config.DbDriver == MemoryDriver {
+		return NewMemoryStore(), nil
+	}
+	return NewSqlStore(config.DbDriver, config.DbSource)
+}
+
+// GetPasteData fetches a paste and its annotations from the given paste ID.
+func GetPasteData(store PasteStore, pasteId int64) (*PasteData, error) {
+	paste, err := store.GetPaste(pasteId)
+	if err != nil || paste == nil {
+		return nil, err
+	}
+
+	data := &PasteData{Paste: paste}
+	annotations, err := store.GetAnnotations(pasteId)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Annotations = annotations
+	return data, nil
+}
+
+// nestComments arranges a list of comments into threads, keyed by the ID of the
+// paste they belong to.  Replies are nested beneath the comments they reply
+// to, and only the top-level comments of each thread are included directly in
+// the result.
+func nestComments(all []*Comment) map[int64][]*Comment {
+	byId := make(map[int64]*Comment)
+	for _, comment := range all {
+		byId[comment.Id] = comment
+	}
+
+	comments := make(map[int64][]*Comment)
+	for _, comment := range all {
+		if comment.ReplyTo.Valid {
+			if parent, ok := byId[comment.ReplyTo.Int64]; ok {
+				parent.Replies = append(parent.Replies, comment)
+				continue
+			}
+		}
+		comments[comment.PasteId] = append(comments[comment.PasteId], comment)
+	}
+
+	return comments
+}
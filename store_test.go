@@ -0,0 +1,637 @@
+package gopaste
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The PasteStore tests run every store behaviour against each backend.
+// MemoryStore and SQLite always run; PostgreSQL and MySQL need a database
+// server, and run with the integration build tag (see integration_test.go).
+
+// openStore opens an empty store for a single test.
+type openStore func(t *testing.T) PasteStore
+
+var storeTests = []struct {
+	name string
+	test func(t *testing.T, store PasteStore)
+}{
+	{"InsertGet", testInsertGet},
+	{"PrivateIds", testPrivateIds},
+	{"PublicIds", testPublicIds},
+	{"Annotations", testAnnotations},
+	{"DeleteTree", testDeleteTree},
+	{"Search", testSearch},
+	{"Expiry", testExpiry},
+	{"Revisions", testRevisions},
+	{"Burn", testBurn},
+	{"Comments", testComments},
+	{"Outbox", testOutbox},
+	{"DeliveryLog", testDeliveryLog},
+}
+
+// runStoreTests runs every store test against the stores opened by open.
+func runStoreTests(t *testing.T, open openStore) {
+	for _, test := range storeTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+			test.test(t, store)
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	runStoreTests(t, func(t *testing.T) PasteStore {
+		return NewMemoryStore()
+	})
+}
+
+func TestSqliteStore(t *testing.T) {
+	runStoreTests(t, func(t *testing.T) PasteStore {
+		store, err := NewSqlStore("sqlite3", filepath.Join(t.TempDir(), "gopaste.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: true}
+}
+
+// insertPaste stores a new paste with the given content, after letting change
+// set any other fields, and returns it.
+func insertPaste(t *testing.T, store PasteStore, content string, change func(*Paste)) *Paste {
+	t.Helper()
+	paste := &Paste{Content: content, Created: time.Now().Unix()}
+	if change != nil {
+		change(paste)
+	}
+
+	if _, err := store.InsertPaste(paste); err != nil {
+		t.Fatalf("InsertPaste: %v", err)
+	}
+	return paste
+}
+
+// annotates makes a paste an annotation of root.
+func annotates(root *Paste) func(*Paste) {
+	return func(p *Paste) {
+		p.Annotates = sql.NullInt64{Int64: root.Id, Valid: true}
+		p.Private = root.Private
+	}
+}
+
+// getPaste fetches a paste which must exist.
+func getPaste(t *testing.T, store PasteStore, id int64) *Paste {
+	t.Helper()
+	paste, err := store.GetPaste(id)
+	if err != nil {
+		t.Fatalf("GetPaste(%d): %v", id, err)
+	}
+	if paste == nil {
+		t.Fatalf("GetPaste(%d): paste not found", id)
+	}
+	return paste
+}
+
+// browse returns the IDs of the top-level pastes matching a set of filters.
+func browse(t *testing.T, store PasteStore, search map[string]string) []int64 {
+	t.Helper()
+	opts := NewBrowseOpts()
+	for k, v := range search {
+		opts.Search[k] = v
+	}
+
+	page, err := store.TopLevelPastes(opts)
+	if err != nil {
+		t.Fatalf("TopLevelPastes(%v): %v", search, err)
+	}
+
+	ids := []int64{}
+	for _, data := range page.Pastes {
+		ids = append(ids, data.Paste.Id)
+	}
+	if page.Total != len(ids) {
+		t.Errorf("TopLevelPastes(%v): got total %d for %d pastes", search, page.Total, len(ids))
+	}
+	return ids
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func testInsertGet(t *testing.T, store PasteStore) {
+	paste := insertPaste(t, store, "package main\n", func(p *Paste) {
+		p.Title = nullString("main.go")
+		p.Author = nullString("alice")
+		p.Language = nullString("go")
+		p.Channel = nullString("#ops")
+		p.LanguageGuessed = true
+		p.Filename = nullString("main.go")
+		p.ExtraFiles = []*PasteFile{
+			{Num: 2, Filename: "go.mod", Content: "module example\n"},
+			{Num: 3, Filename: "README", Language: nullString("markdown"), LanguageGuessed: true, Content: "# hi\n"},
+		}
+	})
+	if paste.Id != 1 {
+		t.Errorf("got ID %d for the first paste, want 1", paste.Id)
+	}
+
+	got := getPaste(t, store, paste.Id)
+	if got.Title != paste.Title || got.Content != paste.Content || got.Author != paste.Author ||
+		got.Language != paste.Language || got.LanguageGuessed != paste.LanguageGuessed || got.Channel != paste.Channel ||
+		got.Filename != paste.Filename || got.Created != paste.Created {
+		t.Errorf("GetPaste: got %+v, want %+v", got, paste)
+	}
+	if got.Revision != 1 || got.Private || got.Burn || got.Slug.Valid {
+		t.Errorf("GetPaste: got revision %d, private %v, burn %v, slug %v", got.Revision, got.Private, got.Burn, got.Slug)
+	}
+
+	if len(got.ExtraFiles) != 2 {
+		t.Fatalf("GetPaste: got %d extra files, want 2", len(got.ExtraFiles))
+	}
+	for i, f := range got.ExtraFiles {
+		want := paste.ExtraFiles[i]
+		if *f != *want {
+			t.Errorf("GetPaste: got file %+v, want %+v", f, want)
+		}
+	}
+
+	if id, err := store.ResolvePasteRef("1"); err != nil || id != paste.Id {
+		t.Errorf("ResolvePasteRef(\"1\"): got %d, %v", id, err)
+	}
+
+	if missing, err := store.GetPaste(paste.Id + 1); err != nil || missing != nil {
+		t.Errorf("GetPaste of a missing paste: got %v, %v", missing, err)
+	}
+}
+
+func testPrivateIds(t *testing.T, store PasteStore) {
+	public := insertPaste(t, store, "public", nil)
+	paste := insertPaste(t, store, "secret", func(p *Paste) {
+		p.Private = true
+	})
+	if !paste.Slug.Valid || !isSlug(paste.Slug.String) {
+		t.Fatalf("private paste got slug %v", paste.Slug)
+	}
+
+	slug := paste.Slug.String
+	for _, test := range []struct {
+		ref  string
+		want int64
+	}{
+		{"1", public.Id},
+		{slug, paste.Id},
+		// private pastes can't be found by their numeric IDs
+		{strconv.FormatInt(paste.Id, 10), InvalidPasteId},
+		{strings.ToLower(slug), InvalidPasteId},
+		{slug[1:], InvalidPasteId},
+		{"2", InvalidPasteId},
+		{"-1", InvalidPasteId},
+		{"abc", InvalidPasteId},
+		{"", InvalidPasteId},
+	} {
+		if id, err := store.ResolvePasteRef(test.ref); err != nil || id != test.want {
+			t.Errorf("ResolvePasteRef(%q): got %d, %v; want %d", test.ref, id, err, test.want)
+		}
+	}
+
+	if ids := browse(t, store, nil); !equalIds(ids, []int64{public.Id}) {
+		t.Errorf("TopLevelPastes: got %v, want no private pastes", ids)
+	}
+}
+
+func testPublicIds(t *testing.T, store PasteStore) {
+	for i := int64(1); i <= 3; i++ {
+		if paste := insertPaste(t, store, "x", nil); paste.Id != i {
+			t.Fatalf("got ID %d, want %d", paste.Id, i)
+		}
+	}
+
+	// private pastes don't use up public IDs
+	private := insertPaste(t, store, "secret", func(p *Paste) {
+		p.Private = true
+	})
+	if private.Id <= 3 {
+		t.Errorf("got ID %d for a private paste, want a random one", private.Id)
+	}
+	if paste := insertPaste(t, store, "y", nil); paste.Id != 4 {
+		t.Errorf("got ID %d after a private paste, want 4", paste.Id)
+	}
+	if ids := browse(t, store, nil); !equalIds(ids, []int64{4, 3, 2, 1}) {
+		t.Errorf("TopLevelPastes: got %v, want no private pastes", ids)
+	}
+
+	// nor are the IDs of deleted pastes used again
+	if err := store.DeletePaste(4); err != nil {
+		t.Fatal(err)
+	}
+	if paste := insertPaste(t, store, "z", nil); paste.Id != 5 {
+		t.Errorf("got ID %d after deleting the newest paste, want 5", paste.Id)
+	}
+}
+
+func testAnnotations(t *testing.T, store PasteStore) {
+	root := insertPaste(t, store, "root", nil)
+	first := insertPaste(t, store, "first", annotates(root))
+	other := insertPaste(t, store, "other", nil)
+	second := insertPaste(t, store, "second", annotates(root))
+
+	annotations, err := store.GetAnnotations(root.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 2 {
+		t.Fatalf("GetAnnotations: got %d annotations, want 2", len(annotations))
+	}
+	for i, want := range []*Paste{first, second} {
+		if annotations[i].Id != want.Id || annotations[i].AnnotationNum != i+1 {
+			t.Errorf("annotation %d: got ID %d number %d, want ID %d", i+1, annotations[i].Id, annotations[i].AnnotationNum, want.Id)
+		}
+	}
+
+	if num, err := store.AnnotationOrdinal(second.Id); err != nil || num != 2 {
+		t.Errorf("AnnotationOrdinal: got %d, %v; want 2", num, err)
+	}
+	if num, err := store.AnnotationOrdinal(root.Id); err != nil || num != 0 {
+		t.Errorf("AnnotationOrdinal of a top-level paste: got %d, %v; want 0", num, err)
+	}
+	if got := getPaste(t, store, second.Id); got.AnnotationNum != 2 || got.RootId() != root.Id {
+		t.Errorf("GetPaste of an annotation: got number %d, root %d", got.AnnotationNum, got.RootId())
+	}
+
+	if ids := browse(t, store, nil); !equalIds(ids, []int64{other.Id, root.Id}) {
+		t.Errorf("TopLevelPastes: got %v, want %v", ids, []int64{other.Id, root.Id})
+	}
+
+}
+
+func testDeleteTree(t *testing.T, store PasteStore) {
+	root := insertPaste(t, store, "root", nil)
+	first := insertPaste(t, store, "first", annotates(root))
+	second := insertPaste(t, store, "second", annotates(root))
+	other := insertPaste(t, store, "other", nil)
+	insertPaste(t, store, "other annotation", annotates(other))
+
+	comment := &Comment{PasteId: first.Id, File: 1, Line: 1, Content: "hm", Created: time.Now().Unix()}
+	if _, err := store.InsertComment(comment); err != nil {
+		t.Fatal(err)
+	}
+
+	annotations, err := store.DeletePasteTree(root.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 2 || annotations[0].Id != first.Id || annotations[1].Id != second.Id ||
+		annotations[1].AnnotationNum != 2 || annotations[1].Content != "second" {
+		t.Errorf("DeletePasteTree: got annotations %+v", annotations)
+	}
+
+	for _, id := range []int64{root.Id, first.Id, second.Id} {
+		if paste, err := store.GetPaste(id); err != nil || paste != nil {
+			t.Errorf("GetPaste(%d) after DeletePasteTree: got %v, %v", id, paste, err)
+		}
+	}
+	if c, err := store.GetComment(comment.Id); err != nil || c != nil {
+		t.Errorf("comment on a deleted annotation remains: %+v, %v", c, err)
+	}
+
+	// other threads are untouched
+	if annotations, err := store.GetAnnotations(other.Id); err != nil || len(annotations) != 1 {
+		t.Errorf("GetAnnotations of another paste: got %d, %v", len(annotations), err)
+	}
+
+	// deleting an annotation's tree deletes just the annotation
+	ann := insertPaste(t, store, "annotation", annotates(other))
+	if annotations, err := store.DeletePasteTree(ann.Id); err != nil || len(annotations) != 0 {
+		t.Errorf("DeletePasteTree of an annotation: got %+v, %v", annotations, err)
+	}
+	getPaste(t, store, other.Id)
+}
+
+func testSearch(t *testing.T, store PasteStore) {
+	alpha := insertPaste(t, store, "the quick brown fox", func(p *Paste) {
+		p.Author = nullString("alice")
+	})
+	beta := insertPaste(t, store, "lorem ipsum", func(p *Paste) {
+		p.Title = nullString("Fox facts")
+		p.Author = nullString("bob")
+	})
+	insertPaste(t, store, "a fox in an annotation", annotates(beta))
+	insertPaste(t, store, "a private fox", func(p *Paste) {
+		p.Private = true
+	})
+	gamma := insertPaste(t, store, "nothing to see", nil)
+	insertPaste(t, store, "the lazy dog", annotates(gamma))
+
+	for _, test := range []struct {
+		search map[string]string
+		want   []int64
+	}{
+		{map[string]string{"q": "fox"}, []int64{beta.Id, alpha.Id}},
+		{map[string]string{"q": "QUICK fox"}, []int64{alpha.Id}},
+		{map[string]string{"q": "annotation"}, []int64{beta.Id}},
+		{map[string]string{"q": "fox", "author": "alice"}, []int64{alpha.Id}},
+		{map[string]string{"q": "lazy"}, []int64{gamma.Id}},
+		{map[string]string{"q": "private"}, []int64{}},
+		{map[string]string{"q": "zebra"}, []int64{}},
+		{map[string]string{"author": "bob"}, []int64{beta.Id}},
+	} {
+		ids := browse(t, store, test.search)
+
+		// rankings differ between backends, so only compare the pastes found
+		if len(ids) != len(test.want) {
+			t.Errorf("TopLevelPastes(%v): got %v, want %v", test.search, ids, test.want)
+			continue
+		}
+		found := make(map[int64]bool)
+		for _, id := range ids {
+			found[id] = true
+		}
+		for _, id := range test.want {
+			if !found[id] {
+				t.Errorf("TopLevelPastes(%v): got %v, want %v", test.search, ids, test.want)
+				break
+			}
+		}
+	}
+}
+
+func testExpiry(t *testing.T, store PasteStore) {
+	now := time.Now().Unix()
+	expired := insertPaste(t, store, "old", func(p *Paste) {
+		p.Expires = sql.NullInt64{Int64: now - 10, Valid: true}
+	})
+	annotation := insertPaste(t, store, "old annotation", annotates(expired))
+	live := insertPaste(t, store, "new", func(p *Paste) {
+		p.Expires = sql.NullInt64{Int64: now + Hour, Valid: true}
+	})
+
+	if paste, err := store.GetPaste(expired.Id); err != nil || paste != nil {
+		t.Errorf("GetPaste of an expired paste: got %v, %v", paste, err)
+	}
+	if ids := browse(t, store, nil); !equalIds(ids, []int64{live.Id}) {
+		t.Errorf("TopLevelPastes: got %v, want %v", ids, []int64{live.Id})
+	}
+
+	count, err := store.DeleteExpiredPastes(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("DeleteExpiredPastes: got %d, want 1", count)
+	}
+
+	// the annotation goes with its paste
+	if num, err := store.AnnotationOrdinal(annotation.Id); err != nil || num != 0 {
+		t.Errorf("annotation of an expired paste still present: %d, %v", num, err)
+	}
+	getPaste(t, store, live.Id)
+}
+
+func testRevisions(t *testing.T, store PasteStore) {
+	paste := insertPaste(t, store, "v1", func(p *Paste) {
+		p.Title = nullString("first")
+	})
+
+	stale := *paste
+	paste.Title = nullString("second")
+	paste.Content = "v2"
+	paste.Language = nullString("go")
+	if err := store.UpdatePaste(paste); err != nil {
+		t.Fatal(err)
+	}
+	if paste.Revision != 2 {
+		t.Errorf("UpdatePaste: got revision %d, want 2", paste.Revision)
+	}
+
+	stale.Content = "conflicting"
+	if err := store.UpdatePaste(&stale); !errors.Is(err, ErrRevisionConflict) {
+		t.Errorf("UpdatePaste of a stale revision: got %v, want ErrRevisionConflict", err)
+	}
+
+	got := getPaste(t, store, paste.Id)
+	if got.Content != "v2" || got.Title.String != "second" || got.Revision != 2 {
+		t.Errorf("GetPaste after update: got %+v", got)
+	}
+
+	revisions, err := store.GetRevisions(paste.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Content != "v1" || revisions[1].Content != "v2" {
+		t.Fatalf("GetRevisions: got %+v", revisions)
+	}
+
+	first, err := store.GetRevision(paste.Id, 1)
+	if err != nil || first == nil || first.Title.String != "first" {
+		t.Errorf("GetRevision(1): got %+v, %v", first, err)
+	}
+	if missing, err := store.GetRevision(paste.Id, 3); err != nil || missing != nil {
+		t.Errorf("GetRevision(3): got %+v, %v", missing, err)
+	}
+
+	// revisions only hold the first file, so multi-file pastes can't be edited
+	multi := insertPaste(t, store, "a", func(p *Paste) {
+		p.Filename = nullString("a.txt")
+		p.ExtraFiles = []*PasteFile{{Num: 2, Filename: "b.txt", Content: "b"}}
+	})
+	multi.Content = "changed"
+	if err := store.UpdatePaste(multi); !errors.Is(err, ErrMultiFileEdit) {
+		t.Errorf("UpdatePaste of a multi-file paste: got %v, want ErrMultiFileEdit", err)
+	}
+	if got := getPaste(t, store, multi.Id); got.Content != "a" || got.Revision != 1 {
+		t.Errorf("multi-file paste changed by UpdatePaste: %+v", got)
+	}
+}
+
+func testBurn(t *testing.T, store PasteStore) {
+	paste := insertPaste(t, store, "read once", func(p *Paste) {
+		p.Burn = true
+		p.Private = true
+	})
+
+	if got, err := store.GetPaste(paste.Id); err != nil || got != nil {
+		t.Errorf("GetPaste of a burn paste: got %v, %v", got, err)
+	}
+	if got, err := store.GetAnyPaste(paste.Id); err != nil || got == nil || got.Content != "read once" {
+		t.Errorf("GetAnyPaste of a burn paste: got %+v, %v", got, err)
+	}
+
+	// a failed view keeps the paste
+	failed := errors.New("render failed")
+	if _, err := store.BurnPaste(paste.Id, func(*Paste) error { return failed }); err != failed {
+		t.Errorf("BurnPaste with a failing view: got %v", err)
+	}
+
+	var viewed *Paste
+	burned, err := store.BurnPaste(paste.Id, func(p *Paste) error {
+		viewed = p
+		return nil
+	})
+	if err != nil || burned == nil || viewed == nil || burned.Content != "read once" {
+		t.Fatalf("BurnPaste: got %+v, %v", burned, err)
+	}
+
+	again, err := store.BurnPaste(paste.Id, func(*Paste) error {
+		t.Error("view called for a burned paste")
+		return nil
+	})
+	if err != nil || again != nil {
+		t.Errorf("second BurnPaste: got %+v, %v", again, err)
+	}
+}
+
+func testComments(t *testing.T, store PasteStore) {
+	root := insertPaste(t, store, "line 1\nline 2\n", nil)
+	annotation := insertPaste(t, store, "other\n", annotates(root))
+
+	insert := func(comment *Comment) *Comment {
+		t.Helper()
+		comment.File = 1
+		comment.Created = time.Now().Unix()
+		if _, err := store.InsertComment(comment); err != nil {
+			t.Fatal(err)
+		}
+		return comment
+	}
+
+	first := insert(&Comment{PasteId: root.Id, Line: 2, Content: "why?"})
+	reply := insert(&Comment{PasteId: root.Id, Line: 2, Content: "because", ReplyTo: sql.NullInt64{Int64: first.Id, Valid: true}})
+	insert(&Comment{PasteId: annotation.Id, Line: 1, Content: "nice", Author: nullString("carol")})
+
+	got, err := store.GetComment(reply.Id)
+	if err != nil || got == nil || got.Content != "because" || got.ReplyTo.Int64 != first.Id {
+		t.Errorf("GetComment: got %+v, %v", got, err)
+	}
+
+	comments, err := store.GetComments(root.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments[root.Id]) != 1 || len(comments[root.Id][0].Replies) != 1 {
+		t.Errorf("GetComments: got %+v on the paste, want one thread with one reply", comments[root.Id])
+	}
+	if len(comments[annotation.Id]) != 1 || comments[annotation.Id][0].Author.String != "carol" {
+		t.Errorf("GetComments: got %+v on the annotation", comments[annotation.Id])
+	}
+
+}
+
+func testOutbox(t *testing.T, store PasteStore) {
+	now := time.Now().Unix()
+	insert := func(guid string, next int64) *Delivery {
+		t.Helper()
+		d := &Delivery{
+			Guid:        guid,
+			Notifier:    "#=webhook:http://example.com/",
+			Event:       EventPaste,
+			Channel:     "#ops",
+			Payload:     `{"text":"hi"}`,
+			Status:      DeliveryPending,
+			NextAttempt: next,
+			Created:     now,
+		}
+		if _, err := store.InsertDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	due := insert("a", now-1)
+	later := insert("b", now+Hour)
+	dead := insert("c", now-1)
+
+	dead.Status = DeliveryDead
+	dead.Attempts = MaxDeliveryAttempts
+	dead.LastError = nullString("connection refused")
+	if err := store.UpdateDelivery(dead); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.DueDeliveries("#=webhook:http://example.com/", now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Id != due.Id || got[0].Payload != due.Payload {
+		t.Errorf("DueDeliveries: got %+v, want only %d", got, due.Id)
+	}
+	if got, err := store.DueDeliveries("#=hubot:localhost", now, 10); err != nil || len(got) != 0 {
+		t.Errorf("DueDeliveries for another notifier: got %+v, %v", got, err)
+	}
+
+	all, err := store.GetDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Id != due.Id || all[1].Id != later.Id {
+		t.Fatalf("GetDeliveries: got %+v", all)
+	}
+	if all[2].Status != DeliveryDead || all[2].Attempts != MaxDeliveryAttempts || all[2].LastError.String != "connection refused" {
+		t.Errorf("GetDeliveries: got %+v for the dead delivery", all[2])
+	}
+
+	if err := store.DeleteDelivery(due.Id); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := store.GetDelivery(due.Id); err != nil || d != nil {
+		t.Errorf("GetDelivery after deleting: got %+v, %v", d, err)
+	}
+	if d, err := store.GetDelivery(later.Id); err != nil || d == nil || d.Guid != "b" {
+		t.Errorf("GetDelivery: got %+v, %v", d, err)
+	}
+}
+
+func testDeliveryLog(t *testing.T, store PasteStore) {
+	now := time.Now().Unix()
+	for i, created := range []int64{now - 2*DeliveryLogAge, now - 10, now} {
+		a := &DeliveryAttempt{
+			Guid:     "guid",
+			Notifier: "hook:http://example.com/",
+			Event:    HookPasteCreated,
+			Attempt:  i + 1,
+			Status:   500,
+			Error:    nullString("500 Internal Server Error"),
+			Duration: 12,
+			Created:  created,
+		}
+		if _, err := store.InsertDeliveryAttempt(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := store.DeleteDeliveryAttempts(now - DeliveryLogAge)
+	if err != nil || count != 1 {
+		t.Errorf("DeleteDeliveryAttempts: got %d, %v; want 1", count, err)
+	}
+
+	attempts, err := store.GetDeliveryAttempts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || attempts[0].Attempt != 3 || attempts[0].Succeeded() {
+		t.Errorf("GetDeliveryAttempts(1): got %+v, want the latest attempt", attempts)
+	}
+}
@@ -0,0 +1,85 @@
+package gopaste
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+)
+
+// newToken returns a random secret token, such as an owner or delete token.
+func newToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken returns the hash of a token which is stored in place of the token
+// itself.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenMatches reports whether a token matches a stored hash.
+func tokenMatches(token string, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) == 1
+}
+
+// SlugLength is the length of the slugs which identify private pastes.  22
+// base62 characters hold a little over 130 random bits.
+const SlugLength = 22
+
+const slugAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// newSlug returns a random slug for a private paste.
+func newSlug() (string, error) {
+	slug := make([]byte, 0, SlugLength)
+	buf := make([]byte, SlugLength)
+	for len(slug) < SlugLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// discard the bytes which would make some characters more likely
+			// than others
+			if int(b) < 4*len(slugAlphabet) && len(slug) < SlugLength {
+				slug = append(slug, slugAlphabet[int(b)%len(slugAlphabet)])
+			}
+		}
+	}
+	return string(slug), nil
+}
+
+// isSlug reports whether a string has the form of a private paste slug.
+func isSlug(s string) bool {
+	if len(s) != SlugLength {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(slugAlphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// privateIdBase is the smallest private paste ID.
+const privateIdBase = 1 << 62
+
+// privateId returns a random number in the range [1<<62, 1<<63), for use as
+// the internal ID of a private paste.
+func privateId() (int64, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return InvalidPasteId, err
+	}
+	return privateIdBase + int64(binary.BigEndian.Uint64(buf)%privateIdBase), nil
+}
@@ -0,0 +1,55 @@
+package gopaste
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsSlug(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"0123456789ABCDEFabcdef", true},
+		{"zzzzzzzzzzzzzzzzzzzzzz", true},
+		{"0123456789ABCDEFabcde", false},
+		{"0123456789ABCDEFabcdefg", false},
+		{"0123456789ABCDEF-bcdef", false},
+		{"0123456789ABCDEF_bcdef", false},
+		{"0123456789ABCDEFabcdé", false},
+		{"12", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := isSlug(test.s); got != test.want {
+			t.Errorf("isSlug(%q): got %v, want %v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestNewSlug(t *testing.T) {
+	seen := make(map[string]bool)
+	var all strings.Builder
+	for i := 0; i < 100; i++ {
+		slug, err := newSlug()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isSlug(slug) {
+			t.Errorf("newSlug: got %q, which is not a slug", slug)
+		}
+		if seen[slug] {
+			t.Errorf("newSlug: got %q twice", slug)
+		}
+		seen[slug] = true
+		all.WriteString(slug)
+	}
+
+	// every character should turn up in 2200 random ones
+	for _, r := range slugAlphabet {
+		if !strings.ContainsRune(all.String(), r) {
+			t.Errorf("newSlug: %q never used in 100 slugs", r)
+		}
+	}
+}
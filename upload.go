@@ -0,0 +1,174 @@
+package gopaste
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// uploadFields maps the metadata accepted by the upload endpoint to the web
+// form fields understood by NewPaste.
+var uploadFields = map[string]string{
+	"title":    "Title",
+	"author":   "Author",
+	"language": "Language",
+	"filename": "Filename",
+	"channel":  "Channel",
+	"expires":  "Expires",
+	"private":  "Private",
+	"burn":     "Burn",
+}
+
+// doUpload creates a paste from the body of a POST request, for use from the
+// command line:
+//
+//	cmd | curl --data-binary @- http://paste.example.com/
+//	curl -F file=@main.go -F file=@go.mod http://paste.example.com/
+//
+// The body is either the content of the paste, or a multipart form whose files
+// become the files of the paste.  Metadata is taken from query parameters
+// (e.g. ?title=...), multipart form fields, or X-Paste-* headers (e.g.
+// X-Paste-Title), in that order; "annotate" names a paste to annotate.  The
+// response is the URL of the new paste as plain text.
+func (s *Server) doUpload(q *Query) error {
+	req := q.Request
+	if req.Method != "POST" {
+		return HttpError{fmt.Sprintf("unsupported request method: %s", req.Method), http.StatusMethodNotAllowed}
+	}
+	req.Body = http.MaxBytesReader(q.Response, req.Body, maxApiBody)
+
+	// the body is never parsed as a URL-encoded form, since that is what curl
+	// sends by default with --data-binary
+	values := url.Values{}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := req.ParseMultipartForm(maxApiBody); err != nil {
+			return HttpError{fmt.Sprintf("error parsing form: %s", err.Error()), http.StatusBadRequest}
+		}
+		if err := addUploadedFiles(values, req); err != nil {
+			return HttpError{err.Error(), http.StatusBadRequest}
+		}
+	} else {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return HttpError{fmt.Sprintf("error reading request: %s", err.Error()), http.StatusBadRequest}
+		}
+		values.Set("Content", string(body))
+	}
+
+	if values.Get("Content") == "" {
+		return HttpError{"paste content is required", http.StatusBadRequest}
+	}
+
+	for name, field := range uploadFields {
+		value := uploadParam(req, name)
+		switch field {
+		case "Private", "Burn":
+			if isTrue(value) {
+				values.Set(field, "on")
+			}
+		case "Filename":
+			if value != "" {
+				values.Set(field, value)
+			}
+		default:
+			values.Set(field, value)
+		}
+	}
+
+	var parent *Paste
+	if ref := uploadParam(req, "annotate"); ref != "" {
+		id, err := s.parsePasteId(ref)
+		if err != nil {
+			return err
+		}
+
+		parent, err = s.Store.GetPaste(id)
+		if err != nil {
+			return HttpError{err.Error(), http.StatusInternalServerError}
+		}
+		if parent == nil {
+			return HttpError{fmt.Sprintf("paste %s not found", ref), http.StatusNotFound}
+		}
+	}
+
+	paste := NewPaste(values)
+	newPath, err := s.createPaste(paste, parent)
+	if err != nil {
+		return err
+	}
+
+	w := q.Response
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Location", s.externalUrl(newPath))
+	w.Header().Set("X-Delete-Url", s.deleteUrl(paste, paste.DeleteToken))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, s.externalUrl(newPath))
+
+	return nil
+}
+
+// addUploadedFiles adds every file uploaded in a multipart form to the form
+// values for a new paste, named after the uploaded files.  Files are taken in
+// the order they were sent within each form field, with the fields in
+// alphabetical order.
+func addUploadedFiles(values url.Values, req *http.Request) error {
+	var fields []string
+	for field := range req.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	first := true
+	for _, field := range fields {
+		for _, h := range req.MultipartForm.File[field] {
+			f, err := h.Open()
+			if err != nil {
+				return err
+			}
+			content, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+
+			if first {
+				values.Set("Content", string(content))
+				values.Set("Filename", h.Filename)
+				first = false
+			} else {
+				values.Add("FileName", h.Filename)
+				values.Add("FileContent", string(content))
+				values.Add("FileLanguage", "")
+			}
+		}
+	}
+	return nil
+}
+
+// uploadParam returns a piece of metadata for an upload from the query string,
+// a multipart form field or an X-Paste-* header.
+func uploadParam(req *http.Request, name string) string {
+	if v := req.URL.Query().Get(name); v != "" {
+		return v
+	}
+	if req.MultipartForm != nil {
+		if v := req.MultipartForm.Value[name]; len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+	return req.Header.Get("X-Paste-" + name)
+}
+
+// isTrue reports whether a metadata value means yes.
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "on", "true", "yes", "y":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package gopaste
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upload sends a request to the upload endpoint and returns the response.
+func upload(s *Server, method, path string, header http.Header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// uploadedPaste fetches the paste whose URL an upload returned.
+func uploadedPaste(t *testing.T, s *Server, w *httptest.ResponseRecorder) *Paste {
+	t.Helper()
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: got status %d: %s", w.Code, w.Body)
+	}
+
+	location := strings.TrimSpace(w.Body.String())
+	if w.Header().Get("Location") != location {
+		t.Errorf("upload: got Location %q for URL %q", w.Header().Get("Location"), location)
+	}
+	// annotations are linked to as /view/{root}#a{n}
+	ref := strings.TrimPrefix(location, "http://paste.example.com/view/")
+	ref, anchor, _ := strings.Cut(ref, "#a")
+	id, err := s.Store.ResolvePasteRef(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchor != "" {
+		annotations, err := s.Store.GetAnnotations(id)
+		if err != nil || len(annotations) == 0 {
+			t.Fatalf("uploaded annotation %q: got %v, %v", location, annotations, err)
+		}
+		return annotations[len(annotations)-1]
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err != nil || paste == nil {
+		t.Fatalf("uploaded paste %q: got %v, %v", location, paste, err)
+	}
+	return paste
+}
+
+func TestUpload(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		path   string
+		header http.Header
+		body   string
+		check  func(p *Paste) bool
+	}{
+		{"/", nil, "make: *** [all] Error 1\n", func(p *Paste) bool {
+			return p.Content == "make: *** [all] Error 1\n" && !p.Title.Valid && !p.Private
+		}},
+		// a URL-encoded body is kept as it is
+		{"/upload", http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, "a=1&b=2", func(p *Paste) bool {
+			return p.Content == "a=1&b=2"
+		}},
+		{"/?title=build+log&author=alice&channel=ops", nil, "x", func(p *Paste) bool {
+			return p.Title.String == "build log" && p.Author.String == "alice" && p.Channel.String == "#ops"
+		}},
+		{"/", http.Header{"X-Paste-Title": {"from a header"}, "X-Paste-Language": {"python"}}, "x", func(p *Paste) bool {
+			return p.Title.String == "from a header" && p.Language.String == "python" && !p.LanguageGuessed
+		}},
+		{"/?title=query", http.Header{"X-Paste-Title": {"header"}}, "x", func(p *Paste) bool {
+			return p.Title.String == "query"
+		}},
+		{"/?filename=main.go", nil, "x := 1\n", func(p *Paste) bool {
+			return p.Filename.String == "main.go" && p.Language.String == "go" && p.LanguageGuessed
+		}},
+		{"/?private=yes", nil, "secret", func(p *Paste) bool {
+			return p.Private && p.Slug.Valid
+		}},
+		{"/?private=no", nil, "public", func(p *Paste) bool {
+			return !p.Private
+		}},
+		{"/?expires=1h", nil, "soon", func(p *Paste) bool {
+			return p.Expires.Valid && p.Expires.Int64 == p.Created+Hour
+		}},
+		{"/?annotate=1", nil, "reply", func(p *Paste) bool {
+			return p.Annotates.Valid && p.Annotates.Int64 == 1
+		}},
+	}
+
+	for _, test := range tests {
+		w := upload(s, "POST", test.path, test.header, test.body)
+		if paste := uploadedPaste(t, s, w); !test.check(paste) {
+			t.Errorf("POST %s %v: got paste %+v", test.path, test.header, paste)
+		}
+		if !strings.HasPrefix(w.Header().Get("X-Delete-Url"), "http://paste.example.com/delete/") {
+			t.Errorf("POST %s: got X-Delete-Url %q", test.path, w.Header().Get("X-Delete-Url"))
+		}
+	}
+}
+
+func TestUploadMultipart(t *testing.T) {
+	s := newTestServer(t)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("title", "example")
+	for _, f := range []struct{ field, name, content string }{
+		{"file", "main.go", "package main\n"},
+		{"file", "go.mod", "module example\n"},
+		{"another", "README", "# hi\n"},
+	} {
+		w, err := form.CreateFormFile(f.field, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.content))
+	}
+	form.Close()
+
+	w := upload(s, "POST", "/", http.Header{"Content-Type": {form.FormDataContentType()}}, body.String())
+	paste := uploadedPaste(t, s, w)
+	if paste.Title.String != "example" {
+		t.Errorf("got title %v, want example", paste.Title)
+	}
+
+	// fields are taken in alphabetical order, and files in the order sent
+	var got []string
+	for _, f := range paste.Files() {
+		got = append(got, f.Filename+"="+f.Content)
+	}
+	want := []string{"README=# hi\n", "main.go=package main\n", "go.mod=module example\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	s := newTestServer(t)
+	upload(s, "POST", "/", nil, "x")
+
+	tests := []struct {
+		method string
+		path   string
+		header http.Header
+		body   string
+		code   int
+	}{
+		{"GET", "/upload", nil, "", http.StatusMethodNotAllowed},
+		{"POST", "/", nil, "", http.StatusBadRequest},
+		{"POST", "/", http.Header{"Content-Type": {"multipart/form-data; boundary=x"}}, "garbage", http.StatusBadRequest},
+		{"POST", "/?annotate=99", nil, "x", http.StatusNotFound},
+		{"POST", "/?annotate=x", nil, "x", http.StatusBadRequest},
+		{"POST", "/?filename=../etc/passwd", nil, "x", http.StatusBadRequest},
+		{"POST", "/", nil, strings.Repeat("x", maxApiBody+1), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if w := upload(s, test.method, test.path, test.header, test.body); w.Code != test.code {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.path, w.Code, test.code)
+		}
+	}
+}
@@ -1,15 +1,16 @@
 package gopaste
 
 import (
+	"archive/zip"
 	"bytes"
+	"database/sql"
 	"fmt"
-	"github.com/aryann/difflib"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -26,10 +27,34 @@ func trunc(s string, max int) string {
 	return s[:last] + "..."
 }
 
+// displayData is the input to the "display" template: a run of lines from a
+// paste, along with the language used to highlight them.
+type displayData struct {
+	PasteSegment
+	Language sql.NullString
+}
+
+func segment(seg PasteSegment, language sql.NullString) displayData {
+	return displayData{seg, language}
+}
+
+// fileWidgetData is the input to the "file-widget" template: an additional
+// file in the form for a new paste, which is nil for a blank one.
+type fileWidgetData struct {
+	File      *PasteFile
+	Languages []languageName
+}
+
+func fileWidget(file *PasteFile, languages []languageName) fileWidgetData {
+	return fileWidgetData{file, languages}
+}
+
 func init() {
 	tmpl = template.New("web")
 	tmpl.Funcs(template.FuncMap{
-		"trunc": trunc,
+		"fileWidget": fileWidget,
+		"segment":    segment,
+		"trunc":      trunc,
 	})
 	if _, err := tmpl.ParseGlob("*.template"); err != nil {
 		log.Fatalf("template parsing: %v\n", err)
@@ -97,14 +122,24 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 type ActionFunc func(*Server, *Query) error
 
 var handlers = map[string]ActionFunc{
-	"":         (*Server).doMain,
-	"annotate": (*Server).doAnnotate,
-	"browse":   (*Server).doBrowse,
-	"diff":     (*Server).doDiff,
-	"new":      (*Server).doNew,
-	"raw":      (*Server).doRaw,
-	"static":   (*Server).doStatic,
-	"view":     (*Server).doView,
+	"":          (*Server).doMain,
+	"admin":     (*Server).doAdmin,
+	"annotate":  (*Server).doAnnotate,
+	"api":       (*Server).doApi,
+	"browse":    (*Server).doBrowse,
+	"comment":   (*Server).doComment,
+	"delete":    (*Server).doDelete,
+	"diff":      (*Server).doDiff,
+	"edit":      (*Server).doEdit,
+	"feed":      (*Server).doFeed,
+	"history":   (*Server).doHistory,
+	"new":       (*Server).doNew,
+	"raw":       (*Server).doRaw,
+	"revisions": (*Server).doRevisions,
+	"search":    (*Server).doSearch,
+	"upload":    (*Server).doUpload,
+	"static":    (*Server).doStatic,
+	"view":      (*Server).doView,
 }
 
 func (s *Server) handle(d *Query) error {
@@ -116,39 +151,81 @@ func (s *Server) handle(d *Query) error {
 	return handler(s, d)
 }
 
+// storeError returns an error from a store method as an HttpError.  Errors
+// which are already HttpErrors, such as those from callbacks, are kept.
+func storeError(err error) error {
+	if _, ok := err.(HttpError); ok {
+		return err
+	}
+	return HttpError{err.Error(), http.StatusInternalServerError}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
-func parsePasteId(str string) (int64, error) {
-	id, err := strconv.ParseInt(str, 10, 64)
+// parsePasteId looks up the ID of the paste identified by a string from a URL,
+// which is either a slug or, for pastes without a slug, a numeric ID.  Pastes
+// with slugs can't be found by their numeric IDs, so private pastes can't be
+// found by guessing numbers.
+func (s *Server) parsePasteId(str string) (int64, error) {
+	if !isSlug(str) {
+		if _, err := strconv.ParseInt(str, 10, 64); err != nil {
+			return InvalidPasteId, HttpError{fmt.Sprintf("invalid paste id '%s'", str), http.StatusBadRequest}
+		}
+	}
+
+	id, err := s.Store.ResolvePasteRef(str)
 	if err != nil {
-		return InvalidPasteId, fmt.Errorf("invalid paste id '%s'", str)
+		return InvalidPasteId, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if id == InvalidPasteId {
+		return InvalidPasteId, HttpError{fmt.Sprintf("paste %s not found", str), http.StatusNotFound}
 	}
 	return id, nil
 }
 
 // runTemplate executes a template and writes the results as HTML if successful
 func runTemplate(w http.ResponseWriter, name string, data interface{}) error {
+	buf, err := renderTemplate(name, data)
+	if err != nil {
+		return err
+	}
+
+	writePage(w, buf)
+	return nil
+}
+
+// renderTemplate executes a template into a buffer, for pages which must be
+// rendered before they are sent.
+func renderTemplate(name string, data interface{}) (*bytes.Buffer, error) {
 	buf := new(bytes.Buffer)
 	err := tmpl.ExecuteTemplate(buf, name, data)
 	if err != nil {
-		return HttpError{fmt.Sprintf("error processing template %s: %v", name, err), http.StatusInternalServerError}
+		return nil, HttpError{fmt.Sprintf("error processing template %s: %v", name, err), http.StatusInternalServerError}
 	}
+	return buf, nil
+}
 
+// writePage sends a page rendered by renderTemplate.
+func writePage(w http.ResponseWriter, page *bytes.Buffer) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	buf.WriteTo(w)
-
-	return nil
+	page.WriteTo(w)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
 type AnyMap map[string]interface{}
 
+// doMain displays the front page.  POST requests to it create pastes from the
+// command line, as for /upload.
 func (s *Server) doMain(q *Query) error {
+	if q.Request.Method == "POST" {
+		return s.doUpload(q)
+	}
+
 	opts := NewBrowseOpts()
 	opts.PageSize = 10
 
-	page, err := TopLevelPastes(s.Database, opts)
+	page, err := s.Store.TopLevelPastes(opts)
 	if err != nil {
 		return HttpError{err.Error(), http.StatusInternalServerError}
 	}
@@ -156,8 +233,10 @@ func (s *Server) doMain(q *Query) error {
 	return runTemplate(q.Response, "main", AnyMap{
 		"MainPage":  true,
 		"Title":     "Home",
+		"Feed":      "/",
 		"Page":      page,
 		"Languages": LanguageNamesSorted,
+		"Expiry":    ExpiryOptions,
 		"User":      q.User,
 	})
 }
@@ -280,91 +359,187 @@ func (s *Server) doBrowse(q *Query) error {
 		return HttpError{err.Error(), http.StatusBadRequest}
 	}
 
-	page, err := TopLevelPastes(s.Database, opts)
+	page, err := s.Store.TopLevelPastes(opts)
 	if err != nil {
 		return HttpError{err.Error(), http.StatusInternalServerError}
 	}
 
 	return runTemplate(q.Response, "browse", AnyMap{
 		"Title": "Browse pastes",
+		"Base":  "browse",
 		"Page":  page,
 		"Opts":  opts,
+		"Feed":  "/" + opts.NewPage(1).String(),
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// doSearch displays the pastes matching a full-text search query.  The query
+// may be given either as a "q" form value or in the same path syntax as
+// /browse, e.g. /search/q/foo/page/2.
+func (s *Server) doSearch(q *Query) error {
+	opts := NewBrowseOpts()
+	opts.PageSize = 20
+	err := opts.Parse(q.Args)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusBadRequest}
+	}
+
+	if query := q.Request.FormValue("q"); query != "" {
+		opts.Search["q"] = query
+	}
+
+	query := strings.TrimSpace(opts.Search["q"])
+	if query == "" {
+		return runTemplate(q.Response, "search", AnyMap{
+			"Title": "Search pastes",
+		})
+	}
+	opts.Search["q"] = query
+
+	page, err := s.Store.TopLevelPastes(opts)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	var results []*SearchResult
+	for _, data := range page.Pastes {
+		results = append(results, NewSearchResult(data, query))
+	}
+
+	return runTemplate(q.Response, "search", AnyMap{
+		"Title":   fmt.Sprintf("Search results for \"%s\"", query),
+		"Base":    "search",
+		"Query":   query,
+		"Page":    page,
+		"Opts":    opts,
+		"Results": results,
+		"Feed":    "/" + opts.NewPage(1).String(),
 	})
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// doDiff displays the difference between two pastes.
+// doDiff displays the difference between two pastes.  Either side may name an
+// earlier revision of a paste as well as its ID, e.g. /diff/12@1/12@2, and the
+// "mode" query parameter chooses between unified, side-by-side (split) and
+// inline word diffs.  The "ignore" query parameter chooses which differences
+// to disregard.  /diff/{a}/{b}.patch returns a unified diff as plain text
+// instead, with the number of lines of context given by the "context" query
+// parameter.
 func (s *Server) doDiff(q *Query) error {
 	if len(q.Args) < 2 {
 		return HttpError{"invalid request", http.StatusBadRequest}
 	}
 
 	fromStr, toStr := q.Args[0], q.Args[1]
+	patch := strings.HasSuffix(toStr, ".patch")
+	toStr = strings.TrimSuffix(toStr, ".patch")
 
-	fromId, err := parsePasteId(fromStr)
+	from, fromRev, err := s.fetchDiffPaste(fromStr)
 	if err != nil {
-		return HttpError{err.Error(), http.StatusBadRequest}
+		return err
 	}
 
-	toId, err := parsePasteId(toStr)
+	to, toRev, err := s.fetchDiffPaste(toStr)
 	if err != nil {
-		return HttpError{err.Error(), http.StatusBadRequest}
+		return err
 	}
 
-	from, err := GetPaste(s.Database, fromId)
-	if err != nil {
-		return HttpError{err.Error(), http.StatusInternalServerError}
+	ignore := q.Request.FormValue("ignore")
+	if !validDiffIgnore(ignore) {
+		return HttpError{fmt.Sprintf("invalid ignore option '%s'", ignore), http.StatusBadRequest}
 	}
-	if from == nil {
-		return HttpError{fmt.Sprintf("paste %d not found", fromId), http.StatusNotFound}
+
+	if patch {
+		return s.writePatch(q, from, to, ignore)
 	}
 
-	to, err := GetPaste(s.Database, toId)
-	if err != nil {
-		return HttpError{err.Error(), http.StatusInternalServerError}
+	mode := q.Request.FormValue("mode")
+	if mode == "" {
+		mode = DiffModes[0]
+	} else if !validDiffMode(mode) {
+		return HttpError{fmt.Sprintf("invalid diff mode '%s'", mode), http.StatusBadRequest}
 	}
-	if to == nil {
-		return HttpError{fmt.Sprintf("paste %d not found", toId), http.StatusNotFound}
-	}
-
-	fromLines := strings.Split(from.Content, "\n")
-	toLines := strings.Split(to.Content, "\n")
-
-	// Ignore whitespace differences in the text being diffed
-	re := regexp.MustCompile(`[ \t]+`)
-	diff := difflib.Diff(
-		strings.Split(re.ReplaceAllString(from.Content, " "), "\n"),
-		strings.Split(re.ReplaceAllString(to.Content, " "), "\n"),
-	)
-
-	fromIndex := 0
-	toIndex := 0
-	diffText := ""
-	for _, line := range diff {
-		switch line.Delta {
-		case difflib.LeftOnly:
-			diffText += "-" + fromLines[fromIndex]
-			fromIndex++
-		case difflib.RightOnly:
-			diffText += "+" + toLines[toIndex]
-			toIndex++
-		default:
-			diffText += " " + fromLines[fromIndex]
-			fromIndex++
-			toIndex++
-		}
-		diffText += "\n"
+
+	patchUrl := fmt.Sprintf("/diff/%s/%s.patch", fromStr, toStr)
+	if ignore != "" {
+		patchUrl += "?ignore=" + url.QueryEscape(ignore)
 	}
 
 	return runTemplate(q.Response, "diff", AnyMap{
-		"Title":    fmt.Sprintf("Diff #%d / #%d", from.Id, to.Id),
+		"Title":    fmt.Sprintf("Diff #%s / #%s", fromStr, toStr),
 		"From":     from,
+		"FromRev":  fromRev,
 		"To":       to,
-		"DiffText": diffText,
+		"ToRev":    toRev,
+		"Mode":     mode,
+		"Modes":    DiffModes,
+		"Ignore":   ignore,
+		"Ignores":  DiffIgnores,
+		"PatchUrl": patchUrl,
+		"Files":    DiffFiles(from, fromRev, to, toRev, mode, ignore),
 	})
 }
 
+// writePatch writes a unified diff between two pastes as plain text.  The diff
+// applies to the raw files of the first paste.
+func (s *Server) writePatch(q *Query, from, to *Paste, ignore string) error {
+	context := 3
+	if str := q.Request.FormValue("context"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 0 {
+			return HttpError{fmt.Sprintf("invalid context '%s'", str), http.StatusBadRequest}
+		}
+		context = n
+	}
+
+	q.Response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(q.Response, Patch(from, to, context, ignore))
+
+	return nil
+}
+
+// fetchDiffPaste looks up one side of a diff, given either as a paste ID or as
+// a paste ID and revision number separated by "@".  It returns the paste as it
+// was at that revision, and the revision number if one was given.
+func (s *Server) fetchDiffPaste(str string) (*Paste, int, error) {
+	idStr, revStr := str, ""
+	if at := strings.Index(str, "@"); at != -1 {
+		idStr, revStr = str[:at], str[at+1:]
+	}
+
+	id, err := s.parsePasteId(idStr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err != nil {
+		return nil, 0, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if paste == nil {
+		return nil, 0, HttpError{fmt.Sprintf("paste %s not found", idStr), http.StatusNotFound}
+	}
+
+	if revStr == "" {
+		return paste, 0, nil
+	}
+
+	num, err := strconv.Atoi(revStr)
+	if err != nil {
+		return nil, 0, HttpError{fmt.Sprintf("invalid revision '%s'", revStr), http.StatusBadRequest}
+	}
+
+	paste, err = s.pasteRevision(paste, num)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return paste, num, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 // doNew adds a new top-level paste.
@@ -379,17 +554,17 @@ func (s *Server) doAnnotate(q *Query) error {
 	}
 
 	idStr := q.Args[0]
-	id, err := parsePasteId(idStr)
+	id, err := s.parsePasteId(idStr)
 	if err != nil {
-		return HttpError{err.Error(), http.StatusBadRequest}
+		return err
 	}
 
-	paste, err := GetPaste(s.Database, id)
+	paste, err := s.Store.GetPaste(id)
 	if err != nil {
 		return HttpError{err.Error(), http.StatusInternalServerError}
 	}
 	if paste == nil {
-		return HttpError{fmt.Sprintf("paste %d not found", id), http.StatusNotFound}
+		return HttpError{fmt.Sprintf("paste %s not found", q.Args[0]), http.StatusNotFound}
 	}
 
 	return s.handleNew(q, paste)
@@ -410,7 +585,7 @@ func (s *Server) handleNew(q *Query, parent *Paste) error {
 func (s *Server) displayNewPage(q *Query, parent *Paste) error {
 	var title string
 	if parent != nil {
-		title = fmt.Sprintf("Annotating #%d: %s", parent.Id, parent.TitleDef())
+		title = fmt.Sprintf("Annotating #%s: %s", parent.Ref(), parent.TitleDef())
 	} else {
 		title = "New paste"
 	}
@@ -419,6 +594,7 @@ func (s *Server) displayNewPage(q *Query, parent *Paste) error {
 		"Title":     title,
 		"Annotates": parent,
 		"Languages": LanguageNamesSorted,
+		"Expiry":    ExpiryOptions,
 		"User":      q.User,
 	})
 }
@@ -430,47 +606,535 @@ func (s *Server) insertNewPaste(q *Query, parent *Paste) error {
 	}
 
 	paste := NewPaste(q.Request.PostForm)
+	if err := setOwner(q, paste); err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	newPath, err := s.createPaste(paste, parent)
+	if err != nil {
+		return err
+	}
+
+	if paste.Burn {
+		// redirecting to the paste would burn it before it could be shared
+		return runTemplate(q.Response, "burn", AnyMap{
+			"Title":     "Paste created",
+			"Paste":     paste,
+			"ViewUrl":   s.externalUrl(newPath),
+			"RawUrl":    s.externalUrl("/raw/" + paste.Ref()),
+			"DeleteUrl": s.deleteUrl(paste, paste.DeleteToken),
+		})
+	}
+
+	setDeleteFlash(q, paste)
+	http.Redirect(q.Response, q.Request, newPath, http.StatusSeeOther)
+	return nil
+}
+
+// createPaste stores a new paste, optionally as an annotation of parent, and
+// sends any channel notification.  It returns the path at which the new paste
+// can be viewed.
+func (s *Server) createPaste(paste *Paste, parent *Paste) (string, error) {
 	if parent != nil {
 		paste.Annotates.Int64 = parent.RootId()
 		paste.Annotates.Valid = true
+		if parent.Annotates.Valid {
+			paste.RootSlug = parent.RootSlug
+		} else {
+			paste.RootSlug = parent.Slug
+		}
 		paste.Private = parent.Private
+		paste.Expires = parent.Expires
+		paste.Burn = false
 	}
 
-	pasteId, err := InsertPaste(s.Database, paste)
+	if err := paste.checkFiles(); err != nil {
+		return "", HttpError{err.Error(), http.StatusBadRequest}
+	}
+
+	if err := setDeleteToken(paste); err != nil {
+		return "", HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	pasteId, err := s.Store.InsertPaste(paste)
 	if err != nil {
-		return HttpError{fmt.Sprintf("error inserting new paste: %s", err.Error()), http.StatusInternalServerError}
+		return "", HttpError{fmt.Sprintf("error inserting new paste: %s", err.Error()), http.StatusInternalServerError}
 	}
 
-	var newPath string
 	if parent != nil {
-		annotation, err := AnnotationOrdinal(s.Database, pasteId)
+		annotation, err := s.Store.AnnotationOrdinal(pasteId)
 		if err != nil {
-			return HttpError{fmt.Sprintf("error fetching paste %d: %s", pasteId, err.Error()), http.StatusInternalServerError}
+			return "", HttpError{fmt.Sprintf("error fetching paste %d: %s", pasteId, err.Error()), http.StatusInternalServerError}
+		}
+
+		paste.AnnotationNum = annotation
+	}
+
+	newPath := viewPath(paste)
+
+	if paste.Channel.Valid && !paste.Burn {
+		event := EventPaste
+		if parent != nil {
+			event = EventAnnotation
 		}
 
-		newPath = fmt.Sprintf("/view/%d#a%d", paste.Annotates.Int64, annotation)
+		s.notify(&NotifyEvent{
+			Event:   event,
+			Channel: paste.Channel.String,
+			Paste:   paste,
+			Url:     s.externalUrl(newPath),
+		})
+	}
+
+	if parent == nil {
+		s.fireWebhooks(HookPasteCreated, paste)
 	} else {
-		newPath = fmt.Sprintf("/view/%d", pasteId)
+		s.fireWebhooks(HookAnnotationCreated, paste)
 	}
 
-	if s.Config.HubotHost != "" && paste.Channel.Valid {
-		// Tell hubot to post a paste notification to IRC
-		pasteUrl := "http://" + s.Config.ExternalHost + newPath
+	return newPath, nil
+}
 
-		var message string
-		if parent == nil {
-			message = fmt.Sprintf("%s pasted \"%s\" at %s", paste.AuthorDef(), paste.TitleDef(), pasteUrl)
-		} else {
-			message = fmt.Sprintf("%s annotated paste #%d with \"%s\" at %s", paste.AuthorDef(), paste.Annotates.Int64, paste.TitleDef(), pasteUrl)
+// viewPath returns the path at which a paste or annotation can be viewed.
+func viewPath(p *Paste) string {
+	if p.Annotates.Valid {
+		return fmt.Sprintf("/view/%s#a%d", p.RootRef(), p.AnnotationNum)
+	}
+	return "/view/" + p.Ref()
+}
+
+// externalUrl returns an absolute URL for the given path on this server.
+func (s *Server) externalUrl(path string) string {
+	return "http://" + s.Config.ExternalHost + path
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// doEdit lets the owner of a paste change its title, content and language.
+// Each edit is stored as a new revision, and earlier revisions remain
+// viewable.
+func (s *Server) doEdit(q *Query) error {
+	if len(q.Args) < 1 {
+		return HttpError{"invalid request", http.StatusBadRequest}
+	}
+
+	id, err := s.parsePasteId(q.Args[0])
+	if err != nil {
+		return err
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if paste == nil {
+		return HttpError{fmt.Sprintf("paste %s not found", q.Args[0]), http.StatusNotFound}
+	}
+
+	if !paste.OwnedBy(ownerHash(q.Request)) {
+		return HttpError{fmt.Sprintf("paste %s can only be edited by its creator", paste.Ref()), http.StatusForbidden}
+	}
+	if paste.MultiFile() {
+		return HttpError{fmt.Sprintf("paste %s has several files, and cannot be edited; annotate it instead", paste.Ref()), http.StatusConflict}
+	}
+
+	switch method := q.Request.Method; method {
+	case "GET", "HEAD":
+		return runTemplate(q.Response, "edit", AnyMap{
+			"Title":     fmt.Sprintf("Editing #%s: %s", paste.Ref(), paste.TitleDef()),
+			"Paste":     paste,
+			"Languages": LanguageNamesSorted,
+		})
+	case "POST":
+		return s.updatePaste(q, paste)
+	default:
+		return HttpError{fmt.Sprintf("unsupported request method: %s", method), http.StatusNotImplemented}
+	}
+}
+
+func (s *Server) updatePaste(q *Query, paste *Paste) error {
+	err := q.Request.ParseForm()
+	if err != nil {
+		return HttpError{fmt.Sprintf("error parsing form: %s", err.Error()), http.StatusInternalServerError}
+	}
+
+	v := q.Request.PostForm
+	revision, err := strconv.Atoi(v.Get("Revision"))
+	if err != nil {
+		return HttpError{fmt.Sprintf("invalid revision '%s'", v.Get("Revision")), http.StatusBadRequest}
+	}
+
+	edited := NewPaste(v)
+	if edited.Content == "" {
+		return HttpError{"paste content is required", http.StatusBadRequest}
+	}
+
+	paste.Title = edited.Title
+	paste.Content = edited.Content
+	paste.Language = edited.Language
+	paste.LanguageGuessed = edited.LanguageGuessed
+	paste.Revision = revision
+
+	err = s.Store.UpdatePaste(paste)
+	if err == ErrRevisionConflict {
+		return HttpError{fmt.Sprintf("paste %s has been edited since revision %d", paste.Ref(), revision), http.StatusConflict}
+	}
+	if err == ErrMultiFileEdit {
+		return HttpError{fmt.Sprintf("paste %s has several files, and cannot be edited; annotate it instead", paste.Ref()), http.StatusConflict}
+	}
+	if err != nil {
+		return HttpError{fmt.Sprintf("error updating paste %d: %s", paste.Id, err.Error()), http.StatusInternalServerError}
+	}
+
+	http.Redirect(q.Response, q.Request, viewPath(paste), http.StatusSeeOther)
+	return nil
+}
+
+// doRevisions lists every revision of a paste, with diffs between them.
+func (s *Server) doRevisions(q *Query) error {
+	if len(q.Args) < 1 {
+		return HttpError{"invalid request", http.StatusBadRequest}
+	}
+
+	id, err := s.parsePasteId(q.Args[0])
+	if err != nil {
+		return err
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if paste == nil {
+		return HttpError{fmt.Sprintf("paste %s not found", q.Args[0]), http.StatusNotFound}
+	}
+
+	revisions, err := s.Store.GetRevisions(id)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	return runTemplate(q.Response, "revisions", AnyMap{
+		"Title":     fmt.Sprintf("Revisions of #%s: %s", paste.Ref(), paste.TitleDef()),
+		"Paste":     paste,
+		"Revisions": revisions,
+	})
+}
+
+// pasteRevision returns a copy of a paste as it was at the given revision.
+func (s *Server) pasteRevision(paste *Paste, num int) (*Paste, error) {
+	revision, err := s.Store.GetRevision(paste.Id, num)
+	if err != nil {
+		return nil, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if revision == nil {
+		return nil, HttpError{fmt.Sprintf("paste %s has no revision %d", paste.Ref(), num), http.StatusNotFound}
+	}
+
+	return paste.AtRevision(revision), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// doHistory displays a paste and all of its annotations as a timeline, with the
+// lines changed at each step.  Picking two entries ("from" and "to" query
+// parameters) redirects to the diff between them.
+func (s *Server) doHistory(q *Query) error {
+	if len(q.Args) < 1 {
+		return HttpError{"invalid request", http.StatusBadRequest}
+	}
+
+	id, err := s.parsePasteId(q.Args[0])
+	if err != nil {
+		return err
+	}
+
+	data, err := GetPasteData(s.Store, id)
+	if err == nil && data != nil && data.Paste.Annotates.Valid {
+		data, err = GetPasteData(s.Store, data.Paste.RootId())
+	}
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if data == nil {
+		return HttpError{fmt.Sprintf("paste %s not found", q.Args[0]), http.StatusNotFound}
+	}
+
+	history := data.History()
+
+	from, to := q.Request.FormValue("from"), q.Request.FormValue("to")
+	if from != "" || to != "" {
+		if !inHistory(history, from) || !inHistory(history, to) {
+			return HttpError{"pick two pastes from the history to compare", http.StatusBadRequest}
 		}
+		http.Redirect(q.Response, q.Request, fmt.Sprintf("/diff/%s/%s", from, to), http.StatusSeeOther)
+		return nil
+	}
 
-		hubotUrl := fmt.Sprintf("http://%s/hubot/say", s.Config.HubotHost)
-		http.PostForm(hubotUrl, url.Values{
-			"room":    {paste.Channel.String},
-			"message": {message},
+	// compare the last two entries unless the user picks others
+	last := len(history) - 1
+	first := last - 1
+	if first < 0 {
+		first = 0
+	}
+
+	return runTemplate(q.Response, "history", AnyMap{
+		"Title":   fmt.Sprintf("History of #%s: %s", data.Paste.Ref(), data.Paste.TitleDef()),
+		"Paste":   data.Paste,
+		"History": history,
+		"From":    history[first].Ref(),
+		"To":      history[last].Ref(),
+	})
+}
+
+// inHistory reports whether a paste ref is one of the entries in a history.
+func inHistory(history []HistoryEntry, ref string) bool {
+	for _, h := range history {
+		if h.Ref() == ref {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// doDelete removes a paste, given the delete token issued when it was created.
+// GET requests ask for confirmation; the paste is only deleted by a POST.
+func (s *Server) doDelete(q *Query) error {
+	if len(q.Args) < 1 {
+		return HttpError{"invalid request", http.StatusBadRequest}
+	}
+
+	paste, err := s.deletablePaste(q.Args[0])
+	if err != nil {
+		return err
+	}
+
+	token := q.Request.FormValue("token")
+	if !paste.DeletableWith(token) {
+		return HttpError{fmt.Sprintf("invalid delete token for paste %s", paste.Ref()), http.StatusForbidden}
+	}
+
+	switch method := q.Request.Method; method {
+	case "GET", "HEAD":
+		annotations, err := s.Store.GetAnnotations(paste.Id)
+		if err != nil {
+			return HttpError{err.Error(), http.StatusInternalServerError}
+		}
+
+		return runTemplate(q.Response, "delete", AnyMap{
+			"Title":       fmt.Sprintf("Delete #%s: %s", paste.Ref(), paste.TitleDef()),
+			"Paste":       paste,
+			"Annotations": annotations,
+			"Token":       token,
 		})
 
-		log.Printf("[hubot] %s %s: %s", s.Config.HubotHost, paste.Channel.String, message)
+	case "POST":
+		if err := s.deletePaste(paste); err != nil {
+			return err
+		}
+
+		redirect := "/"
+		if paste.Annotates.Valid {
+			redirect = "/view/" + paste.RootRef()
+		}
+		http.Redirect(q.Response, q.Request, redirect, http.StatusSeeOther)
+		return nil
+
+	default:
+		return HttpError{fmt.Sprintf("unsupported request method: %s", method), http.StatusNotImplemented}
+	}
+}
+
+// deletablePaste looks up a paste to be deleted from an ID string.  Unlike
+// other lookups it finds burn-after-reading pastes, so that their creators can
+// delete them before they are read.
+func (s *Server) deletablePaste(idStr string) (*Paste, error) {
+	id, err := s.parsePasteId(idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	paste, err := s.Store.GetAnyPaste(id)
+	if err != nil {
+		return nil, HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if paste == nil {
+		return nil, HttpError{fmt.Sprintf("paste %s not found", idStr), http.StatusNotFound}
+	}
+	return paste, nil
+}
+
+// deletePaste removes a paste.  Deleting a top-level paste also deletes all of
+// its annotations, and a paste.deleted event is sent for each of them after the
+// paste's own.
+func (s *Server) deletePaste(paste *Paste) error {
+	annotations, err := s.Store.DeletePasteTree(paste.Id)
+	if err != nil {
+		return HttpError{fmt.Sprintf("error deleting paste %d: %s", paste.Id, err.Error()), http.StatusInternalServerError}
+	}
+
+	log.Printf("[web] deleted paste %d and %d annotations", paste.Id, len(annotations))
+	s.fireWebhooks(HookPasteDeleted, paste)
+	for _, ann := range annotations {
+		s.fireWebhooks(HookPasteDeleted, ann)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// commentContext is the number of lines shown on either side of the line being
+// commented on.
+const commentContext = 3
+
+// doComment adds a comment to a single line of a paste, identified by the ID
+// of the top-level paste and the line's anchor (e.g. /comment/12/2.14, or
+// /comment/12/main.go:14 for a line of a named file).
+func (s *Server) doComment(q *Query) error {
+	if len(q.Args) < 2 {
+		return HttpError{"invalid request", http.StatusBadRequest}
+	}
+
+	id, err := s.parsePasteId(q.Args[0])
+	if err != nil {
+		return err
+	}
+
+	annotation, name, line, err := ParseAnchor(q.Args[1])
+	if err != nil {
+		return HttpError{err.Error(), http.StatusBadRequest}
+	}
+
+	data, err := GetPasteData(s.Store, id)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if data == nil || data.Paste.Annotates.Valid {
+		return HttpError{fmt.Sprintf("paste %s not found", q.Args[0]), http.StatusNotFound}
+	}
+
+	paste := data.Paste
+	if annotation > 0 {
+		if annotation > len(data.Annotations) {
+			return HttpError{fmt.Sprintf("paste %s has no annotation %d", q.Args[0], annotation), http.StatusNotFound}
+		}
+		paste = data.Annotations[annotation-1]
+	}
+
+	file := paste.Files()[0]
+	if name != "" {
+		if file = paste.File(name); file == nil {
+			return HttpError{fmt.Sprintf("paste %s has no file %s", paste.Ref(), name), http.StatusNotFound}
+		}
+	}
+
+	numbers := file.LineNumbers(*paste)
+	if line > len(numbers) {
+		return HttpError{fmt.Sprintf("paste %s has no line %s", paste.Ref(), q.Args[1]), http.StatusNotFound}
+	}
+
+	switch method := q.Request.Method; method {
+	case "GET", "HEAD":
+		return s.displayCommentPage(q, data, paste, file, line)
+	case "POST":
+		return s.insertComment(q, data.Paste, paste, numbers[line-1])
+	default:
+		return HttpError{fmt.Sprintf("unsupported request method: %s", method), http.StatusNotImplemented}
+	}
+}
+
+func (s *Server) displayCommentPage(q *Query, data *PasteData, paste *Paste, file *PasteFile, line int) error {
+	comments, err := s.Store.GetComments(data.Paste.Id)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	numbers := file.LineNumbers(*paste)
+	lines := strings.Split(file.Content, "\n")
+	low, high := line-1-commentContext, line+commentContext
+	if low < 0 {
+		low = 0
+	}
+	if high > len(numbers) {
+		high = len(numbers)
+	}
+
+	var thread []*Comment
+	for _, c := range comments[paste.Id] {
+		if c.File == file.Num && c.Line == line {
+			c.setLocation(numbers[line-1])
+			thread = append(thread, c)
+		}
+	}
+
+	var replyTo *Comment
+	if idStr := q.Request.FormValue("reply"); idStr != "" {
+		if replyId, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			replyTo, err = s.Store.GetComment(replyId)
+			if err != nil {
+				return HttpError{err.Error(), http.StatusInternalServerError}
+			}
+		}
+	}
+	if replyTo != nil && (replyTo.PasteId != paste.Id || replyTo.File != file.Num || replyTo.Line != line) {
+		replyTo = nil
+	}
+
+	return runTemplate(q.Response, "comment-page", AnyMap{
+		"Title":  fmt.Sprintf("Comment on line %s of paste #%s", numbers[line-1].Anchor, data.Paste.Ref()),
+		"Paste":  paste,
+		"File":   file,
+		"Anchor": numbers[line-1].Anchor,
+		"Context": PasteSegment{
+			Lines:   numbers[low:high],
+			Content: strings.Join(lines[low:high], "\n"),
+			Html:    joinLines(s.highlightedLines(file), low, high),
+		},
+		"Comments": thread,
+		"ReplyTo":  replyTo,
+		"User":     q.User,
+	})
+}
+
+func (s *Server) insertComment(q *Query, root *Paste, paste *Paste, num LineNumber) error {
+	err := q.Request.ParseForm()
+	if err != nil {
+		return HttpError{fmt.Sprintf("error parsing form: %s", err.Error()), http.StatusInternalServerError}
+	}
+
+	comment := NewComment(paste, num, q.Request.PostForm)
+	if comment.Content == "" {
+		return HttpError{"comment must not be empty", http.StatusBadRequest}
+	}
+
+	if comment.ReplyTo.Valid {
+		parent, err := s.Store.GetComment(comment.ReplyTo.Int64)
+		if err != nil {
+			return HttpError{err.Error(), http.StatusInternalServerError}
+		}
+		if parent == nil || parent.PasteId != paste.Id || parent.File != num.File || parent.Line != num.Num {
+			return HttpError{fmt.Sprintf("comment %d not found on line %s", comment.ReplyTo.Int64, num.Anchor), http.StatusBadRequest}
+		}
+	}
+
+	commentId, err := s.Store.InsertComment(comment)
+	if err != nil {
+		return HttpError{fmt.Sprintf("error inserting comment: %s", err.Error()), http.StatusInternalServerError}
+	}
+
+	newPath := fmt.Sprintf("/view/%s#c%d", root.Ref(), commentId)
+	if root.Channel.Valid {
+		comment.Id = commentId
+		s.notify(&NotifyEvent{
+			Event:   EventComment,
+			Channel: root.Channel.String,
+			Paste:   paste,
+			Comment: comment,
+			Line:    num.Anchor,
+			Url:     s.externalUrl(newPath),
+		})
 	}
 
 	http.Redirect(q.Response, q.Request, newPath, http.StatusSeeOther)
@@ -479,32 +1143,99 @@ func (s *Server) insertNewPaste(q *Query, parent *Paste) error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// doRaw returns the verbatim content of a paste as plain text.
+// doRaw returns the verbatim content of a paste as plain text.  For a paste
+// with named files, /raw/{id}/{filename} returns a single file, and
+// /raw/{id}.zip returns all of them as a zip archive.
 func (s *Server) doRaw(q *Query) error {
 	if len(q.Args) < 1 {
 		return HttpError{"invalid request", http.StatusBadRequest}
 	}
 
 	idStr := q.Args[0]
-	id, err := parsePasteId(idStr)
+	archive := strings.HasSuffix(idStr, ".zip")
+	idStr = strings.TrimSuffix(idStr, ".zip")
+
+	id, err := s.parsePasteId(idStr)
 	if err != nil {
-		return HttpError{err.Error(), http.StatusBadRequest}
+		return err
 	}
 
-	paste, err := GetPaste(s.Database, id)
+	// a burn-after-reading paste is only burned once its content is ready
+	var content []byte
+	render := func(paste *Paste) (err error) {
+		if archive {
+			content, err = zipPaste(paste)
+			return err
+		}
+
+		content = []byte(paste.Content)
+		if len(q.Args) > 1 {
+			file := paste.File(q.Args[1])
+			if file == nil {
+				return HttpError{fmt.Sprintf("paste %s has no file %s", idStr, q.Args[1]), http.StatusNotFound}
+			}
+			content = []byte(file.Content)
+		}
+		return nil
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err == nil && paste != nil {
+		err = render(paste)
+	} else if err == nil {
+		paste, err = s.Store.BurnPaste(id, render)
+	}
 	if err != nil {
-		return HttpError{err.Error(), http.StatusInternalServerError}
+		return storeError(err)
 	}
 	if paste == nil {
-		return HttpError{fmt.Sprintf("paste %d not found", id), http.StatusNotFound}
+		return HttpError{fmt.Sprintf("paste %s not found", idStr), http.StatusNotFound}
 	}
 
-	q.Response.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	fmt.Fprint(q.Response, paste.Content)
+	if paste.Burn {
+		q.Response.Header().Set("Cache-Control", "no-store")
+	}
+
+	if archive {
+		q.Response.Header().Set("Content-Type", "application/zip")
+		q.Response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, paste.Ref()))
+	} else {
+		q.Response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	q.Response.Write(content)
 
 	return nil
 }
 
+// zipPaste returns every file of a paste as a zip archive.  An unnamed file is
+// named after the paste.
+func zipPaste(paste *Paste) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, f := range paste.Files() {
+		name := f.Filename
+		if name == "" {
+			name = paste.Ref() + ".txt"
+		}
+
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.Modified = paste.CreatedTime()
+		fw, err := archive.CreateHeader(header)
+		if err == nil {
+			_, err = io.WriteString(fw, f.Content)
+		}
+		if err != nil {
+			return nil, HttpError{fmt.Sprintf("error writing archive: %s", err.Error()), http.StatusInternalServerError}
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, HttpError{fmt.Sprintf("error writing archive: %s", err.Error()), http.StatusInternalServerError}
+	}
+
+	return buf.Bytes(), nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 // doStatic serves a single static file.
@@ -522,28 +1253,103 @@ func (s *Server) doStatic(q *Query) error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// doView displays a paste and any annotations with syntax highlighting.
+// doView displays a paste and any annotations with syntax highlighting, or an
+// earlier revision of a single paste (e.g. /view/12/rev/1).
 func (s *Server) doView(q *Query) error {
 	if len(q.Args) < 1 {
 		return HttpError{"invalid request", http.StatusBadRequest}
 	}
 
 	idStr := q.Args[0]
-	id, err := parsePasteId(idStr)
+	id, err := s.parsePasteId(idStr)
 	if err != nil {
-		return HttpError{err.Error(), http.StatusBadRequest}
+		return err
+	}
+
+	if len(q.Args) >= 3 && q.Args[1] == "rev" {
+		return s.viewRevision(q, id, q.Args[2])
 	}
 
-	pasteData, err := GetPasteData(s.Database, id)
+	pasteData, err := GetPasteData(s.Store, id)
 	if err != nil {
 		return HttpError{err.Error(), http.StatusInternalServerError}
 	}
 	if pasteData == nil {
-		return HttpError{fmt.Sprintf("paste %d not found", id), http.StatusNotFound}
+		return s.viewBurnedPaste(q, id)
+	}
+
+	pasteData.Viewer = ownerHash(q.Request)
+	pasteData.Highlight = s.highlight
+	deletion := s.takeDeleteNotice(q, pasteData)
+
+	pasteData.Comments, err = s.Store.GetComments(pasteData.Paste.RootId())
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+
+	// private pastes have no feed, so as not to advertise them
+	feed := ""
+	if !pasteData.Paste.Private {
+		feed = "/annotations/" + pasteData.Paste.RootRef()
 	}
 
 	return runTemplate(q.Response, "view", AnyMap{
-		"Title":   fmt.Sprintf("Paste #%d: %s", pasteData.Paste.Id, pasteData.Paste.TitleDef()),
-		"Content": pasteData,
+		"Title":    fmt.Sprintf("Paste #%s: %s", pasteData.Paste.Ref(), pasteData.Paste.TitleDef()),
+		"Content":  pasteData,
+		"Deletion": deletion,
+		"Feed":     feed,
+	})
+}
+
+// viewBurnedPaste displays a burn-after-reading paste, deleting it in the
+// process.  The page is rendered before the paste is deleted, so that the
+// paste is kept if it cannot be shown.
+func (s *Server) viewBurnedPaste(q *Query, id int64) error {
+	var page *bytes.Buffer
+	paste, err := s.Store.BurnPaste(id, func(paste *Paste) (err error) {
+		page, err = renderTemplate("view", AnyMap{
+			"Title":   fmt.Sprintf("Paste #%s: %s", paste.Ref(), paste.TitleDef()),
+			"Content": &PasteData{Paste: paste, Highlight: s.highlight},
+			"Burned":  true,
+		})
+		return err
+	})
+	if err != nil {
+		return storeError(err)
+	}
+	if paste == nil {
+		return HttpError{fmt.Sprintf("paste %s not found", q.Args[0]), http.StatusNotFound}
+	}
+
+	q.Response.Header().Set("Cache-Control", "no-store")
+	writePage(q.Response, page)
+	return nil
+}
+
+// viewRevision displays an earlier revision of a paste.
+func (s *Server) viewRevision(q *Query, id int64, numStr string) error {
+	num, err := strconv.Atoi(numStr)
+	if err != nil {
+		return HttpError{fmt.Sprintf("invalid revision '%s'", numStr), http.StatusBadRequest}
+	}
+
+	paste, err := s.Store.GetPaste(id)
+	if err != nil {
+		return HttpError{err.Error(), http.StatusInternalServerError}
+	}
+	if paste == nil {
+		return HttpError{fmt.Sprintf("paste %s not found", q.Args[0]), http.StatusNotFound}
+	}
+
+	old, err := s.pasteRevision(paste, num)
+	if err != nil {
+		return err
+	}
+
+	return runTemplate(q.Response, "revision", AnyMap{
+		"Title":  fmt.Sprintf("Paste #%s revision %d: %s", old.Ref(), num, old.TitleDef()),
+		"Paste":  old,
+		"View":   PasteView{Paste: old, Highlight: s.highlight},
+		"Latest": paste,
 	})
 }
@@ -0,0 +1,495 @@
+package gopaste
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestServer creates a server which keeps its pastes in an empty SQLite
+// database in a temporary directory.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	store, err := NewSqlStore(DefaultDriver, filepath.Join(t.TempDir(), "gopaste.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return New(&Config{ExternalHost: "paste.example.com"}, store)
+}
+
+// request sends a request to a server, with a form body if form is not nil,
+// and returns the response.
+func request(s *Server, method, path string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// postPaste submits the new paste form, or the annotation form if parent is
+// not empty, and returns the response.
+func postPaste(t *testing.T, s *Server, parent string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	path := "/new"
+	if parent != "" {
+		path = "/annotate/" + parent
+	}
+
+	w := request(s, "POST", path, form)
+	if w.Code != http.StatusSeeOther && w.Code != http.StatusOK {
+		t.Fatalf("POST %s: got status %d: %s", path, w.Code, w.Body)
+	}
+	return w
+}
+
+func TestNewPaste(t *testing.T) {
+	s := newTestServer(t)
+	w := postPaste(t, s, "", url.Values{
+		"Title":    {"build log"},
+		"Author":   {"alice"},
+		"Language": {"none"},
+		"Content":  {"make: *** [all] Error 1\n"},
+	})
+	if got := w.Header().Get("Location"); got != "/view/1" {
+		t.Fatalf("got redirect to %q, want /view/1", got)
+	}
+
+	w = request(s, "GET", "/view/1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /view/1: got status %d", w.Code)
+	}
+	for _, want := range []string{"build log", "alice", "[all] Error 1"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("GET /view/1: page does not contain %q", want)
+		}
+	}
+
+	w = request(s, "GET", "/raw/1", nil)
+	if got := w.Body.String(); got != "make: *** [all] Error 1\n" {
+		t.Errorf("GET /raw/1: got %q", got)
+	}
+}
+
+func TestAnnotatePaste(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{"Title": {"original"}, "Content": {"x = 1"}})
+
+	w := postPaste(t, s, "1", url.Values{"Title": {"fixed"}, "Content": {"x = 2"}})
+	if got := w.Header().Get("Location"); got != "/view/1#a1" {
+		t.Fatalf("got redirect to %q, want /view/1#a1", got)
+	}
+
+	w = request(s, "GET", "/view/1", nil)
+	for _, want := range []string{"original", "x = 1", "fixed", "x = 2"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("GET /view/1: page does not contain %q", want)
+		}
+	}
+
+	w = request(s, "GET", "/browse", nil)
+	if strings.Contains(w.Body.String(), "fixed") {
+		t.Errorf("GET /browse: annotation listed as a top-level paste")
+	}
+}
+
+func TestPrivatePaste(t *testing.T) {
+	s := newTestServer(t)
+	w := postPaste(t, s, "", url.Values{"Title": {"secret"}, "Content": {"hunter2"}, "Private": {"on"}})
+
+	location := w.Header().Get("Location")
+	if !regexp.MustCompile(`^/view/[A-Za-z0-9]{22}$`).MatchString(location) {
+		t.Fatalf("got redirect to %q, want a slug", location)
+	}
+
+	if w := request(s, "GET", location, nil); w.Code != http.StatusOK {
+		t.Errorf("GET %s: got status %d", location, w.Code)
+	}
+
+	slug := strings.TrimPrefix(location, "/view/")
+	for _, path := range []string{"/browse", "/feed/atom", "/search?q=hunter2"} {
+		if w := request(s, "GET", path, nil); strings.Contains(w.Body.String(), slug) {
+			t.Errorf("GET %s: private paste listed", path)
+		}
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s := newTestServer(t)
+	for _, path := range []string{"/view/1", "/raw/1", "/view/AAAAAAAAAAAAAAAAAAAAAA", "/nonexistent"} {
+		if w := request(s, "GET", path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got status %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestBurnPaste(t *testing.T) {
+	s := newTestServer(t)
+	for _, prefix := range []string{"/view/", "/raw/"} {
+		w := postPaste(t, s, "", url.Values{"Content": {"read me once"}, "Burn": {"on"}})
+		id := regexp.MustCompile(`/view/([A-Za-z0-9]+)`).FindStringSubmatch(w.Body.String())
+		if id == nil {
+			t.Fatalf("no link to the paste in %s", w.Body)
+		}
+		path := prefix + id[1]
+
+		if w := request(s, "GET", "/browse", nil); strings.Contains(w.Body.String(), id[1]) {
+			t.Errorf("GET /browse: burn-after-reading paste listed")
+		}
+
+		// asking for a file the paste doesn't have doesn't burn it
+		if w := request(s, "GET", "/raw/"+id[1]+"/missing.txt", nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET /raw/%s/missing.txt: got status %d, want 404", id[1], w.Code)
+		}
+
+		w = request(s, "GET", path, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "read me once") {
+			t.Fatalf("first GET %s: got status %d", path, w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("first GET %s: got Cache-Control %q, want no-store", path, got)
+		}
+
+		for _, again := range []string{"/view/", "/raw/"} {
+			if w := request(s, "GET", again+id[1], nil); w.Code != http.StatusNotFound {
+				t.Errorf("GET %s after %s: got status %d, want 404", again+id[1], path, w.Code)
+			}
+		}
+	}
+}
+
+func TestDeleteBurnPaste(t *testing.T) {
+	s := newTestServer(t)
+	for _, api := range []bool{false, true} {
+		// the delete link is shown on the page, as burn pastes aren't redirected to
+		w := postPaste(t, s, "", url.Values{"Content": {"unread"}, "Burn": {"on"}})
+		link := regexp.MustCompile(`href="(http://paste\.example\.com/delete/[^"]+)"`).FindStringSubmatch(w.Body.String())
+		if link == nil {
+			t.Fatalf("no delete link in %s", w.Body)
+		}
+		u, err := url.Parse(html.UnescapeString(link[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, token := strings.TrimPrefix(u.Path, "/delete/"), u.Query().Get("token")
+
+		if api {
+			req := httptest.NewRequest("DELETE", "/api/v1/pastes/"+id, nil)
+			req.Header.Set("X-Delete-Token", token)
+			w = httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+		} else {
+			path := "/delete/" + id + "?token=" + url.QueryEscape(token)
+			if w := request(s, "GET", path, nil); w.Code != http.StatusOK {
+				t.Errorf("GET %s: got status %d", path, w.Code)
+			}
+			w = request(s, "POST", path, nil)
+		}
+		if w.Code != http.StatusSeeOther && w.Code != http.StatusNoContent {
+			t.Errorf("deleting unread burn paste %s (API %v): got status %d: %s", id, api, w.Code, w.Body)
+		}
+
+		if w := request(s, "GET", "/view/"+id, nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET /view/%s after deleting: got status %d, want 404", id, w.Code)
+		}
+	}
+}
+
+// deleteToken returns the paste ID and delete token in the cookie set when a
+// paste is created.
+func deleteToken(t *testing.T, w *httptest.ResponseRecorder) (string, string) {
+	t.Helper()
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == DeleteCookie {
+			if parts := strings.SplitN(cookie.Value, ":", 2); len(parts) == 2 {
+				return parts[0], parts[1]
+			}
+		}
+	}
+	t.Fatalf("no delete token cookie in response")
+	return "", ""
+}
+
+func TestDeletePaste(t *testing.T) {
+	s := newTestServer(t)
+	id, token := deleteToken(t, postPaste(t, s, "", url.Values{"Content": {"oops"}}))
+	annId, _ := deleteToken(t, postPaste(t, s, id, url.Values{"Content": {"reply"}}))
+	path := "/delete/" + id + "?token=" + url.QueryEscape(token)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/delete/" + id, http.StatusForbidden},
+		{"GET", "/delete/" + id + "?token=wrong", http.StatusForbidden},
+		{"POST", "/delete/" + id + "?token=wrong", http.StatusForbidden},
+		{"GET", "/delete/99?token=" + url.QueryEscape(token), http.StatusNotFound},
+		{"GET", path, http.StatusOK},
+		{"GET", "/view/" + id, http.StatusOK},
+		{"POST", path, http.StatusSeeOther},
+		{"GET", "/view/" + id, http.StatusNotFound},
+		{"GET", "/view/" + annId, http.StatusNotFound},
+		{"POST", path, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		if w := request(s, test.method, test.path, nil); w.Code != test.code {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.path, w.Code, test.code)
+		}
+	}
+}
+
+func TestDeleteWebhooks(t *testing.T) {
+	config := &Config{ExternalHost: "paste.example.com", Webhooks: []string{"paste.deleted=http://hooks.example.com/"}}
+	s := New(config, NewMemoryStore())
+	postPaste(t, s, "", url.Values{"Content": {"oops, a password"}})
+	postPaste(t, s, "1", url.Values{"Content": {"first annotation"}})
+	postPaste(t, s, "1", url.Values{"Content": {"second annotation"}})
+
+	paste, err := s.Store.GetPaste(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.deletePaste(paste); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := s.Store.GetDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, d := range deliveries {
+		if d.Event != HookPasteDeleted {
+			t.Errorf("got a %s event, want only %s", d.Event, HookPasteDeleted)
+		}
+		if strings.Contains(d.Payload, "password") || strings.Contains(d.Payload, "annotation") || strings.Contains(d.Payload, `"content"`) {
+			t.Errorf("payload includes the content: %s", d.Payload)
+		}
+
+		var payload struct{ Paste WebhookDeletedPaste }
+		if err := json.Unmarshal([]byte(d.Payload), &payload); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, payload.Paste.Id)
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" {
+		t.Errorf("got events for pastes %v, want [1 2 3]", ids)
+	}
+}
+
+func TestConcurrentNewPastes(t *testing.T) {
+	server := httptest.NewServer(newTestServer(t))
+	defer server.Close()
+
+	// don't follow the redirects, which would just load every paste
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	const count = 50
+	locations := make(chan string, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			form := url.Values{"Content": {fmt.Sprintf("paste %d", i)}}
+			resp, err := client.PostForm(server.URL+"/new", form)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusSeeOther {
+				t.Errorf("POST /new: got status %d", resp.StatusCode)
+				return
+			}
+			locations <- resp.Header.Get("Location")
+		}(i)
+	}
+	wg.Wait()
+	close(locations)
+
+	seen := make(map[int]bool)
+	for location := range locations {
+		id, err := strconv.Atoi(strings.TrimPrefix(location, "/view/"))
+		if err != nil {
+			t.Fatalf("got redirect to %q, want a numeric ID", location)
+		}
+		if seen[id] {
+			t.Errorf("ID %d given to two pastes", id)
+		}
+		seen[id] = true
+	}
+
+	// every ID from 1 up is used exactly once
+	for id := 1; id <= count; id++ {
+		if !seen[id] {
+			t.Errorf("ID %d not used; got %d distinct IDs", id, len(seen))
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := newTestServer(t)
+	postPaste(t, s, "", url.Values{"Content": {"a\nb\n"}})
+	postPaste(t, s, "1", url.Values{"Content": {"a\nc\nd\n"}})
+	postPaste(t, s, "1", url.Values{"Content": {"a\n"}})
+	postPaste(t, s, "", url.Values{"Content": {"unrelated\n"}})
+
+	data, err := GetPasteData(s.Store, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range data.History() {
+		prev := "-"
+		if h.Prev != nil {
+			prev = h.Prev.Ref()
+		}
+		got = append(got, fmt.Sprintf("%s<%s +%d -%d", h.Ref(), prev, h.Added, h.Removed))
+	}
+	if want := []string{"1<- +2 -0", "2<1 +2 -1", "3<2 +0 -2"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("History: got %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/history/1", http.StatusOK, ""},
+		{"/history/3", http.StatusOK, ""},
+		{"/history/4", http.StatusOK, ""},
+		{"/history/1?from=1&to=3", http.StatusSeeOther, "/diff/1/3"},
+		{"/history/2?from=3&to=2", http.StatusSeeOther, "/diff/3/2"},
+		{"/history/1?from=1&to=4", http.StatusBadRequest, ""},
+		{"/history/1?from=1", http.StatusBadRequest, ""},
+		{"/history/99", http.StatusNotFound, ""},
+		{"/history/x", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		w := request(s, "GET", test.path, nil)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("GET %s: got status %d, location %q; want %d, %q",
+				test.path, w.Code, w.Header().Get("Location"), test.code, test.location)
+		}
+	}
+}
+
+func TestEditMultiFile(t *testing.T) {
+	s := newTestServer(t)
+	owner := &http.Cookie{Name: OwnerCookie, Value: "owner-token"}
+	send := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(owner)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	send("POST", "/new", url.Values{"Content": {"one"}})
+	send("POST", "/new", url.Values{
+		"Filename":    {"a.txt"},
+		"Content":     {"first"},
+		"FileName":    {"b.txt"},
+		"FileContent": {"second"},
+	})
+
+	if w := send("GET", "/edit/1", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /edit/1: got status %d", w.Code)
+	}
+	if w := send("GET", "/view/2", nil); strings.Contains(w.Body.String(), "/edit/2") {
+		t.Errorf("GET /view/2: multi-file paste has an edit link")
+	}
+
+	edit := url.Values{"Revision": {"1"}, "Content": {"changed"}}
+	if w := send("POST", "/edit/2", edit); w.Code != http.StatusConflict {
+		t.Errorf("POST /edit/2: got status %d, want 409", w.Code)
+	}
+	if w := request(s, "GET", "/raw/2", nil); w.Body.String() != "first" {
+		t.Errorf("GET /raw/2 after a refused edit: got %q", w.Body)
+	}
+}
+
+func TestHighlightPerServer(t *testing.T) {
+	// each server keeps its own setting, even with several in one process
+	server := newTestServer(t)
+	client := New(&Config{ExternalHost: "paste.example.com", Highlight: HighlightClient}, NewMemoryStore())
+
+	for _, test := range []struct {
+		s    *Server
+		want bool
+	}{{server, true}, {client, false}, {server, true}} {
+		w := postPaste(t, test.s, "", url.Values{"Language": {"go"}, "Content": {"package main\n"}})
+		w = request(test.s, "GET", w.Header().Get("Location"), nil)
+		if got := strings.Contains(w.Body.String(), `class="chroma`); got != test.want {
+			t.Errorf("highlight %q: got highlighted %v, want %v", test.s.Config.Highlight, got, test.want)
+		}
+	}
+}
+
+func TestAdminFormToken(t *testing.T) {
+	s := New(&Config{ExternalHost: "paste.example.com", AdminPassword: "sesame"}, NewMemoryStore())
+	d := &Delivery{Guid: "g", Notifier: "#=hubot:localhost", Event: EventPaste, Payload: "{}", Status: DeliveryDead}
+	if _, err := s.Store.InsertDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := func(method string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/notifications", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "sesame")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	w := admin("GET", nil)
+	token := regexp.MustCompile(`name="token" type="hidden" value="([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+	if token == nil {
+		t.Fatalf("no form token on the outbox page")
+	}
+
+	form := url.Values{"id": {strconv.FormatInt(d.Id, 10)}, "action": {"discard"}}
+	for _, bad := range []string{"", "0123abcd"} {
+		form.Set("token", bad)
+		if w := admin("POST", form); w.Code != http.StatusForbidden {
+			t.Errorf("POST with token %q: got status %d, want 403", bad, w.Code)
+		}
+	}
+	if got, _ := s.Store.GetDelivery(d.Id); got == nil {
+		t.Fatal("delivery discarded without a valid token")
+	}
+
+	form.Set("token", token[1])
+	if w := admin("POST", form); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST with the form token: got status %d: %s", w.Code, w.Body)
+	}
+	if got, _ := s.Store.GetDelivery(d.Id); got != nil {
+		t.Error("delivery not discarded")
+	}
+}
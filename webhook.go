@@ -0,0 +1,243 @@
+package gopaste
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Events sent to webhooks.
+const (
+	HookPasteCreated      = "paste.created"
+	HookAnnotationCreated = "annotation.created"
+	HookPasteDeleted      = "paste.deleted"
+)
+
+var hookEvents = []string{HookPasteCreated, HookAnnotationCreated, HookPasteDeleted}
+
+// Headers sent with each webhook request.
+const (
+	HookEventHeader     = "X-Gopaste-Event"
+	HookDeliveryHeader  = "X-Gopaste-Delivery"
+	HookSignatureHeader = "X-Gopaste-Signature-256"
+)
+
+// hookPrefix starts the names which identify webhooks in the outbox.
+const hookPrefix = "hook:"
+
+// Webhook posts paste events to a URL, for other tools to act on.
+type Webhook struct {
+	Url string
+
+	// Events holds the events sent to the webhook, or is nil for all of them.
+	Events map[string]bool
+
+	name string
+}
+
+// WebhookPayload is the JSON body of a webhook request.  Id is the delivery ID,
+// which is the same for every attempt at a delivery.  Paste is an *ApiPaste,
+// or a *WebhookDeletedPaste for a paste.deleted event.
+type WebhookPayload struct {
+	Id    string      `json:"id"`
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Url   string      `json:"url"`
+	Paste interface{} `json:"paste"`
+}
+
+// WebhookDeletedPaste describes a deleted paste: its IDs and metadata, but none
+// of its content.
+type WebhookDeletedPaste struct {
+	Id        int64     `json:"id"`
+	Ref       string    `json:"ref"`
+	Title     string    `json:"title,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Language  string    `json:"language,omitempty"`
+	Filename  string    `json:"filename,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+	Annotates int64     `json:"annotates,omitempty"`
+	Private   bool      `json:"private"`
+	Revision  int       `json:"revision"`
+	Created   time.Time `json:"created"`
+	Url       string    `json:"url"`
+}
+
+// NewWebhooks sets up the webhooks given by the --webhook options, each of the
+// form URL or EVENTS=URL, where EVENTS is a comma-separated list of events.
+func NewWebhooks(config *Config) ([]*Webhook, error) {
+	var hooks []*Webhook
+	names := make(map[string]bool)
+	for _, spec := range config.Webhooks {
+		hook, err := parseWebhook(spec)
+		if err != nil {
+			return nil, err
+		}
+		if names[hook.name] {
+			return nil, fmt.Errorf("duplicate webhook '%s'", hook.Url)
+		}
+		names[hook.name] = true
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// parseWebhook parses a --webhook option.
+func parseWebhook(spec string) (*Webhook, error) {
+	hook := &Webhook{Url: spec}
+
+	// an "=" before the scheme separates the events from the URL
+	if eq := strings.Index(spec, "="); eq != -1 && eq < strings.Index(spec, "://") {
+		hook.Url = spec[eq+1:]
+		hook.Events = make(map[string]bool)
+		for _, event := range strings.Split(spec[:eq], ",") {
+			if !validHookEvent(event) {
+				return nil, fmt.Errorf("unknown webhook event '%s' in '%s'; known events are %s", event, spec, strings.Join(hookEvents, ", "))
+			}
+			hook.Events[event] = true
+		}
+	}
+
+	if err := checkUrl(hook.Url); err != nil {
+		return nil, fmt.Errorf("invalid webhook '%s': %v", spec, err)
+	}
+
+	hook.name = hookPrefix + redactTarget(hook.Url)
+	return hook, nil
+}
+
+func validHookEvent(event string) bool {
+	for _, e := range hookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Wants reports whether the webhook is sent an event.
+func (h *Webhook) Wants(event string) bool {
+	return h.Events == nil || h.Events[event]
+}
+
+// webhook returns the webhook with the given outbox name, or nil if there is
+// none.
+func (s *Server) webhook(name string) *Webhook {
+	for _, h := range s.Hooks {
+		if h.name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// hookPaste returns the paste as it is described in a webhook event.
+func (s *Server) hookPaste(event string, paste *Paste) interface{} {
+	a := s.apiPaste(paste)
+	if event != HookPasteDeleted {
+		return a
+	}
+
+	return &WebhookDeletedPaste{
+		Id:        a.Id,
+		Ref:       a.Ref,
+		Title:     a.Title,
+		Author:    a.Author,
+		Language:  a.Language,
+		Filename:  a.Filename,
+		Channel:   a.Channel,
+		Annotates: a.Annotates,
+		Private:   a.Private,
+		Revision:  a.Revision,
+		Created:   a.Created,
+		Url:       a.Url,
+	}
+}
+
+// fireWebhooks queues an event about a paste in the outbox for each webhook
+// which wants it.  Nothing is sent about burn-after-reading pastes, whose
+// content is only meant to be seen once.
+func (s *Server) fireWebhooks(event string, paste *Paste) {
+	if paste.Burn {
+		return
+	}
+
+	now := time.Now()
+	for _, h := range s.Hooks {
+		if !h.Wants(event) {
+			continue
+		}
+
+		guid, err := newToken()
+		if err != nil {
+			log.Printf("[webhook] error creating delivery ID: %v", err)
+			return
+		}
+
+		payload, err := json.Marshal(&WebhookPayload{
+			Id:    guid,
+			Event: event,
+			Time:  now.UTC(),
+			Url:   s.externalUrl(viewPath(paste)),
+			Paste: s.hookPaste(event, paste),
+		})
+		if err != nil {
+			log.Printf("[webhook] error encoding payload: %v", err)
+			return
+		}
+
+		d := &Delivery{
+			Guid:        guid,
+			Notifier:    h.name,
+			Event:       event,
+			Channel:     paste.Channel.String,
+			Payload:     string(payload),
+			Status:      DeliveryPending,
+			NextAttempt: now.Unix(),
+			Created:     now.Unix(),
+		}
+		if _, err := s.Store.InsertDelivery(d); err != nil {
+			log.Printf("[webhook] error queueing %s for %s: %v", event, h.Url, err)
+			continue
+		}
+		s.wakeDeliveries(h.name)
+	}
+}
+
+// signPayload returns the signature of a webhook payload: the hex-encoded
+// HMAC-SHA256 of the body keyed with the secret, prefixed with "sha256=".
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send makes one attempt at a delivery to the webhook, signing it if there is
+// a secret, and returns the HTTP status of the response.
+func (h *Webhook) send(d *Delivery, secret string) (int, error) {
+	req, err := http.NewRequest("POST", h.Url, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gopaste-webhook")
+	req.Header.Set(HookEventHeader, d.Event)
+	req.Header.Set(HookDeliveryHeader, d.Guid)
+	if secret != "" {
+		req.Header.Set(HookSignatureHeader, signPayload(secret, []byte(d.Payload)))
+	}
+
+	resp, err := notifyClient.Do(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	return status, checkNotifyResponse(resp, err)
+}